@@ -0,0 +1,51 @@
+/*
+Package cmd defines commands
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/vishnraj/go-scraper/fetcher"
+
+	"github.com/spf13/cobra"
+)
+
+// agentsCmd represents the agents command
+var agentsCmd = &cobra.Command{
+	Use:   "agents",
+	Short: "Inspect what has been learned about the user-agents",
+	Long:  `Provides sub-commands to inspect the success and failure counts, per host, that are used to choose the user-agent for each request and are persisted in the state backend`,
+}
+
+// agentsStatsCmd represents the agents stats command
+var agentsStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Prints the success and failure counts of each user-agent per host",
+	Long:  `Prints the success and failure counts of each user-agent per host from the state backend, with the agents most likely to be chosen for a host first`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return fetcher.CommonRootChecks(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		fetcher.PrintAgentStats(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentsCmd)
+	agentsCmd.AddCommand(agentsStatsCmd)
+
+	agentsStatsCmd.Flags().String("host", "", "Only print the stats for this host")
+	agentsStatsCmd.Flags().Bool("json", false, "Print one JSON object per agent and host instead of a table")
+}
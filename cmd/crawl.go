@@ -0,0 +1,57 @@
+/*
+Package cmd defines commands
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/vishnraj/go-scraper/fetcher"
+
+	"github.com/spf13/cobra"
+)
+
+// crawlCmd represents the crawl command
+var crawlCmd = &cobra.Command{
+	Use:   "crawl",
+	Short: "Crawl pages by following links from seed URLs and write one NDJSON record per page",
+	Long:  `Starts from the seed URLs and follows links matching the include/exclude patterns, within the allowed domains, up to a max depth and page count - each page goes through the same detect, wait and extract steps as fetch`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return fetcher.CommonCrawlChecks(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		fetcher.CrawlContent(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(crawlCmd)
+
+	crawlCmd.Flags().StringSlice("seeds", nil, "URLs to start crawling from")
+	crawlCmd.Flags().StringSlice("include_patterns", nil, "Regexes of which at least one must match a link for it to be followed - if not specified all links within the allowed domains are followed")
+	crawlCmd.Flags().StringSlice("exclude_patterns", nil, "Regexes for links that must not be followed")
+	crawlCmd.Flags().StringSlice("allowed_domains", nil, "Domains (including their sub-domains) that links may point to - if not specified the domains of the seeds are used")
+	crawlCmd.Flags().Int("max_depth", fetcher.DefaultCrawlMaxDepth, "Number of link hops to follow from a seed")
+	crawlCmd.Flags().Int("max_pages", fetcher.DefaultCrawlMaxPages, "Max number of pages to visit")
+	crawlCmd.Flags().Int("crawl_workers", fetcher.DefaultCrawlWorkers, "Number of pages loaded at the same time across all hosts")
+	crawlCmd.Flags().Int("host_concurrency", fetcher.DefaultCrawlHostConcurrency, "Number of pages loaded at the same time for a single host")
+	crawlCmd.Flags().Int("crawl_delay", fetcher.DefaultCrawlDelay, "Time (milliseconds) to wait between starting two page loads on the same host")
+	crawlCmd.Flags().String("output", "", "File to write NDJSON records to - if not specified they are written to stdout")
+
+	crawlCmd.Flags().String("wait_selector", "", "Selector for element to wait for on each page - can specify either an xpath or a css selector")
+	crawlCmd.Flags().String("text_selector", "", "Gets text for the desired selector on each page and if not specified dump all content retrieved")
+	crawlCmd.Flags().String("href_selector", "", "Gets the first href for the node that match the specific selector on each page")
+	crawlCmd.Flags().String("id_selector", "", "Gets the text that matches the specific selector by id on each page")
+	crawlCmd.Flags().String("eval", "", "Evaluates the JavaScript expression, or the script file passed in as @path, on each page and records the JSON encoded result")
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -26,7 +27,9 @@ var discordCmd = &cobra.Command{
 		return fetcher.CommonWatchChecks(cmd)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		fetcher.DiscordContent(cmd)
+		if code := fetcher.DiscordContent(cmd); code != 0 {
+			os.Exit(code)
+		}
 	},
 }
 
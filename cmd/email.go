@@ -18,6 +18,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/vishnraj/go-scraper/fetcher"
 
@@ -50,7 +51,9 @@ var emailCmd = &cobra.Command{
 		return fetcher.CommonWatchChecks(cmd)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		fetcher.EmailContent(cmd)
+		if code := fetcher.EmailContent(cmd); code != 0 {
+			os.Exit(code)
+		}
 	},
 }
 
@@ -37,6 +37,14 @@ var fetchCmd = &cobra.Command{
 			return fmt.Errorf("We require a non-empty URL")
 		}
 
+		if err := fetcher.CheckScript(viper.GetString("eval")); err != nil {
+			return err
+		}
+
+		if err := fetcher.CheckPaginationSpec(u, viper.GetString("pagination"), viper.GetString("item_selector"), viper.GetString("next_selector"), viper.GetString("page_url_template")); err != nil {
+			return err
+		}
+
 		return fetcher.CommonRootChecks(cmd)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -52,4 +60,12 @@ func init() {
 	fetchCmd.Flags().String("text_selector", "", "Gets and prints text for the desired selector and if not specified dump all content retrieved - can specify either an xpath or a css selector")
 	fetchCmd.Flags().String("href_selector", "", "Gets the first href for the node that match the specific selector")
 	fetchCmd.Flags().String("id_selector", "", "Gets the text that matches the specific selector by id")
+	fetchCmd.Flags().String("item_selector", "", "CSS selector for the repeated items of a listing page - if specified the text and href of each item are printed as JSON lines")
+	fetchCmd.Flags().String("item_key", "", "What identifies an item of the listing - href, text or the name of an attribute (e.g. data-id) on the item or one of its children")
+	fetchCmd.Flags().String("item_fields", "", "CSS selectors, relative to an item and separated by |, of fields to extract for each item")
+	fetchCmd.Flags().String("pagination", "", "How to walk the pages of a listing - one of next (click next_selector), url (navigate to page_url_template) or scroll (scroll until no new items appear)")
+	fetchCmd.Flags().String("next_selector", "", "CSS selector of the element to click to get to the next page, for next pagination")
+	fetchCmd.Flags().String("page_url_template", "", "URL of the listing with {page} in place of the page number, for url pagination")
+	fetchCmd.Flags().Bool("include_headers", false, "Print the redirects, status line and response headers of the main document before the content")
+	fetchCmd.Flags().String("eval", "", "Evaluates the JavaScript expression, or the script file passed in as @path, in the page and prints the JSON encoded result - promises are awaited")
 }
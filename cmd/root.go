@@ -54,6 +54,8 @@ func init() {
 	rootCmd.PersistentFlags().Bool("headless", false, "Use headless shell")
 	rootCmd.PersistentFlags().String("user_data_dir", fetcher.DefaultUserDataDir, "User data dir for browser data if we specify non headless mode")
 	rootCmd.PersistentFlags().StringSliceP("agents", "a", fetcher.DefaultUserAgents, "User agent(s) to request as - if not specified the default is used")
+	rootCmd.PersistentFlags().StringSlice("profiles", nil, "Fingerprint profiles to rotate through instead of the agents, each setting the user-agent, platform, languages, viewport, timezone, locale and WebGL strings together - presets are windows-chrome, mac-chrome and linux-chrome")
+	rootCmd.PersistentFlags().String("profiles_file", "", "JSON file with an array of additional fingerprint profiles, each with a name, user_agent, platform, languages, width, height, device_scale, timezone, locale, webgl_vendor and webgl_renderer")
 	rootCmd.PersistentFlags().IntP("timeout", "t", -1, "Timeout for context - if none is specified a default background context will be used")
 	rootCmd.PersistentFlags().String("log_level", "INFO", "The default log level for the app - by default it will be INFO, but can specify DEBUG")
 
@@ -61,12 +63,18 @@ func init() {
 	rootCmd.PersistentFlags().Bool("error_location", false, "Logs the current URL that we have arrived at on error")
 
 	rootCmd.PersistentFlags().Bool("detect_notify_path", false, "If a desired notify path is encountered, for a given URL, perform notification action")
-	rootCmd.PersistentFlags().Bool("detect_access_denied", false, "If access denied is encoutered, then we will take a counter action")
+	rootCmd.PersistentFlags().Bool("detect_access_denied", false, "If access denied is encoutered, then we will take a counter action - same as adding access-denied to block_rules")
+	rootCmd.PersistentFlags().StringSlice("block_rules", nil, "Preset block page rules to detect, each taking its counter action(s) - access-denied, akamai, cloudflare, datadome, incapsula, perimeterx or rate-limit (429 status)")
+	rootCmd.PersistentFlags().String("block_rules_file", "", "JSON file with an array of additional block page rules, each with a name, actions (rotate_agent, rotate_proxy, backoff (right away, without waiting for backoff_after failures) and/or notify) and at least one of title (regex), body (regex), selector, status (list of HTTP status codes), header (regex matched against the name: value lines of the response headers, with lower case names) or redirect_host (regex) - every condition that is set must match")
 	rootCmd.PersistentFlags().Bool("detect_captcha_box", false, "If a captcha box is encoutered, then we will take a counter action")
 	rootCmd.PersistentFlags().String("captcha_wait_selector", fetcher.DefaultCaptchaWaitSelector, "The selector element to wait for so we can load the captcha box")
 	rootCmd.PersistentFlags().String("captcha_click_selector", fetcher.DefaultCaptchaClickSelector, "The selector element to click for the captcha box")
 	rootCmd.PersistentFlags().String("captcha_iframe_wait_selector", fetcher.DefaultCaptchaIframeWaitSelector, "The selector element to wait for the captcha iframe")
 	rootCmd.PersistentFlags().Int("captcha_click_sleep", fetcher.DefaultCaptchaClickSleep, "Time (seconds) we sleep after a captcha click, to allow the captcha challenge to get loaded into the iframe")
+	rootCmd.PersistentFlags().String("captcha_solver", "", "How a captcha challenge we are still blocked by is solved - manual (notifies with a screenshot and waits for a human to solve it in the non-headless window) or http (posts the challenge to the captcha_solver_url and injects the token it returns) - if not specified we error out")
+	rootCmd.PersistentFlags().String("captcha_solver_url", "", "URL the http captcha_solver posts the challenge type, site_key and page_url to as JSON - it must respond with a JSON token (or error)")
+	rootCmd.PersistentFlags().String("captcha_solver_key", "", "If set, sent as a bearer token to the captcha_solver_url")
+	rootCmd.PersistentFlags().Int("captcha_solve_timeout", fetcher.DefaultCaptchaSolveTimeout, "Time (seconds) we wait for a captcha to be solved")
 
 	rootCmd.PersistentFlags().Bool("redis_dump", false, "Set this option for all dumps to go to the redis database that we connet to this app")
 	rootCmd.PersistentFlags().String("redis_url", "", "If we want to send dumps to a redis database we must set a valid URL")
@@ -75,8 +83,45 @@ func init() {
 	rootCmd.PersistentFlags().Int("redis_write_timeout", fetcher.DefaultRedisWriteTimeout, "Timeout (seconds) for writing to redis")
 	rootCmd.PersistentFlags().StringSlice("override_flags", []string{}, "Override chrome flags in key=value format; if non-empty, these flags take precedence")
 
+	rootCmd.PersistentFlags().String("har_dir", "", "If set, network traffic for each run is recorded and written as a HAR 1.2 file into this directory")
+	rootCmd.PersistentFlags().Bool("har_on_error_only", false, "Only write the HAR file for runs that ended with an error")
+	rootCmd.PersistentFlags().Bool("har_include_bodies", false, "Include response bodies in the HAR file")
+	rootCmd.PersistentFlags().Int("har_max_body_size", fetcher.DefaultHarMaxBodySize, "Max size (bytes) of each response body stored in the HAR file - bodies larger than this are truncated, zero means no limit")
+
+	rootCmd.PersistentFlags().String("resource_policy", "", "Resource policy used to abort requests during page loads - one of text-only (blocks images, fonts, media and trackers), custom (uses block_resource_types and block_url_globs) or none")
+	rootCmd.PersistentFlags().StringSlice("block_resource_types", nil, "Resource types (e.g. image, font, media, stylesheet, script) blocked by the custom resource policy")
+	rootCmd.PersistentFlags().StringSlice("block_url_globs", nil, "URL globs (* and ? wildcards) blocked by the custom resource policy")
+
+	rootCmd.PersistentFlags().String("device", "", "Device to emulate, which sets the viewport, touch emulation, mobile user-agent and device scale factor - one of the chromedp device names (e.g. \"iPhone 14\", \"Pixel 5\" or \"iPad Pro\") or a device from the devices_file")
+	rootCmd.PersistentFlags().String("devices_file", "", "JSON file with an array of custom devices, each with a name, user_agent, width, height, scale, landscape, mobile and touch")
+
+	rootCmd.PersistentFlags().Int("eval_timeout", fetcher.DefaultEvalTimeout, "Time (seconds) a user supplied script (fetch --eval or the js check type) may run before it is stopped")
+
+	rootCmd.PersistentFlags().Int("pagination_max_pages", fetcher.DefaultPaginationMaxPages, "Max number of pages walked for a listing that uses pagination")
+	rootCmd.PersistentFlags().Int("page_wait", fetcher.DefaultPageWait, "Time (seconds) we sleep after moving to the next page of a listing, to allow its items to load")
+
+	rootCmd.PersistentFlags().Float64("rate_limit", 0, "Max runs per second against each host, shared by every URL on the host - zero means no limit")
+	rootCmd.PersistentFlags().Int("rate_burst", fetcher.DefaultRateBurst, "Number of runs against a host that may start at once before the rate_limit kicks in")
+	rootCmd.PersistentFlags().Int("backoff_after", fetcher.DefaultBackoffAfter, "Number of failed runs in a row (or a block rule with the backoff action) after which a URL backs off - its runs are skipped until the backoff is over")
+	rootCmd.PersistentFlags().Int("backoff_base", fetcher.DefaultBackoffBase, "Time (seconds) a URL backs off for the first time, doubled for every further failure in a row until a run succeeds")
+	rootCmd.PersistentFlags().Int("max_backoff", fetcher.DefaultMaxBackoff, "Max time (seconds) a URL backs off for")
+	rootCmd.PersistentFlags().Float64("backoff_jitter", fetcher.DefaultBackoffJitter, "Fraction of the backoff that is randomly added or taken away, so URLs that failed together don't come back together")
+
+	rootCmd.PersistentFlags().Bool("robots", false, "Obey robots.txt - URLs it disallows for the robots_agent are skipped and its Crawl-delay slows down the runs against the host")
+	rootCmd.PersistentFlags().String("robots_agent", fetcher.DefaultRobotsAgent, "Product token robots.txt rules are evaluated for, rules for * apply if none name it")
+	rootCmd.PersistentFlags().Int("robots_cache_ttl", fetcher.DefaultRobotsCacheTTL, "Time (seconds) a fetched robots.txt is cached for")
+
+	rootCmd.PersistentFlags().String("state_backend", "file", "Where state that is kept between runs, like the items seen by a list watch, is persisted - one of file (uses state_file) or redis (uses redis_url)")
+	rootCmd.PersistentFlags().String("state_file", fetcher.DefaultStateFile, "File that state is persisted to when using the file state_backend")
+
 	// Proxy configuration option
-	rootCmd.PersistentFlags().String("proxy_url", "", "Proxy URL in format http(s)://[username:password@]host:port")
+	rootCmd.PersistentFlags().String("proxy_url", "", "Proxy URL in format http(s)://[username:password@]host:port or socks5://host:port")
+	rootCmd.PersistentFlags().StringSlice("proxy_urls", nil, "Pool of proxy URLs, in the same format as proxy_url, that runs are rotated across")
+	rootCmd.PersistentFlags().String("proxy_file", "", "File with one proxy URL per line, added to the pool - empty lines and lines starting with # are skipped")
+	rootCmd.PersistentFlags().String("proxy_rotation", fetcher.RoundRobinProxyRotation, "How a proxy of the pool is chosen for each run - one of round-robin, random or sticky (keeps the same proxy for a target until it goes bad)")
+	rootCmd.PersistentFlags().Int("proxy_cooldown", fetcher.DefaultProxyCooldown, "Time (seconds) a proxy that hit access denied, a timeout or a connection error is left out of the rotation")
+	rootCmd.PersistentFlags().String("proxy_health_check_url", "", "If set, this URL is periodically requested through every proxy of the pool and the ones that fail are marked bad")
+	rootCmd.PersistentFlags().Int("proxy_health_check_interval", fetcher.DefaultProxyHealthCheckInterval, "Time (seconds) between health checks of the proxy pool")
 }
 
 // initConfig reads in config file and ENV variables if set.
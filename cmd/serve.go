@@ -0,0 +1,60 @@
+/*
+Package cmd defines commands
+Copyright © 2020 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/vishnraj/go-scraper/fetcher"
+
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the watch as a daemon with a REST API to manage its targets",
+	Long:  `This command runs the watch as a long-lived daemon - its targets are persisted in the state store and are listed, added, updated, deleted, paused, resumed and run through a REST API, which also returns the last status, value, error, dump and screenshot of each target. It takes the options of watch, except for the per-URL ones, which each target sets`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return fetcher.CommonServeChecks(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if code := fetcher.Serve(cmd); code != 0 {
+			os.Exit(code)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().String("listen", fetcher.DefaultListenAddress, "Address to serve the REST API on")
+	serveCmd.Flags().String("grpc_listen", "", "Address to serve the gRPC API on - leave empty to not serve it")
+	serveCmd.Flags().String("api_token", "", "Bearer token the REST API requires in the Authorization header - leave empty to not require one (specify as an environment variable)")
+	serveCmd.Flags().String("notifier", fetcher.LogNotifier, "Where to send what the targets notify about - log, email (with the from, to, subject and email_password flags) or discord (with the webhook and discord_username flags)")
+
+	serveCmd.Flags().String("subject", fetcher.DefaultSubject, "Subject of the emails of the email notifier")
+	serveCmd.Flags().String("from", "", "Email address the email notifier sends from")
+	serveCmd.Flags().String("to", "", "Email address the email notifier sends to")
+	serveCmd.Flags().String("email_password", "", "Password for the from email of the email notifier (specify as an environment variable)")
+	serveCmd.Flags().String("webhook", "", "Discord webhook URL of the discord notifier")
+	serveCmd.Flags().String("discord_username", "", "Username to display in the notifications of the discord notifier")
+
+	serveCmd.Flags().Int("fetch_concurrency", fetcher.DefaultFetchConcurrency, "Max number of browsers running at once, for the fetches of the API and the watched targets together")
+	serveCmd.Flags().Int("fetch_queue", fetcher.DefaultFetchQueue, "Max number of fetches of the API waiting for a browser - more are answered with 503")
+	serveCmd.Flags().Int("fetch_timeout", fetcher.DefaultFetchTimeout, "Default, and max, time (seconds) a fetch of the API may take, waiting for a browser included")
+}
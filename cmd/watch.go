@@ -22,6 +22,7 @@ import (
 	"github.com/vishnraj/go-scraper/fetcher"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // watchCmd represents the watch command
@@ -41,11 +42,30 @@ func init() {
 	rootCmd.AddCommand(watchCmd)
 
 	watchCmd.PersistentFlags().StringSlice("urls", nil, "All URLs to watch")
-	watchCmd.PersistentFlags().StringSlice("wait_selectors", nil, "All selectors, in order of URLs passed in, to wait for")
+	watchCmd.PersistentFlags().StringSlice("wait_selectors", nil, "All selectors, in order of URLs passed in, to wait for - URLs with the json check type wait for their network response instead, so theirs can be left empty")
 
 	watchCmd.PersistentFlags().StringSlice("check_selectors", nil, "Selectors that are used to check for the given expected_texts")
-	watchCmd.PersistentFlags().StringSlice("check_types", nil, "The types of selectors for each check selector in order, which correspond to the ones in check_selectors - specify none to not use one for URL at that index")
-	watchCmd.PersistentFlags().StringSlice("expected_texts", nil, "Pieces of texts that represent the normal state of an item - when the status is updated, the the desired user action will be taken")
+	watchCmd.PersistentFlags().StringSlice("check_types", nil, "The types of selectors for each check selector in order, which correspond to the ones in check_selectors - specify none to not use one for URL at that index, items to check the text of all listing items (one per line), list to notify with the listing items added or removed since the last check, diff or diff_html to notify with a unified diff of the text or HTML of the check selector region since the last check, visual to notify with an image of what changed in a screenshot of the check selector element (or the whole viewport for a check selector of viewport) since the last check, document to check the main document response using a check selector of status, status_text, final_url, redirects or header:<name> (e.g. an expected text of 404 with a check selector of status notifies once the page comes back), json to check a network response matched by json_url_patterns, or js to check the JSON encoded result of the expression (or @path script file) given as the check selector")
+	watchCmd.PersistentFlags().StringSlice("expected_texts", nil, "Pieces of texts that represent the normal state of an item - when the status is updated, the the desired user action will be taken - not needed for the list, diff, diff_html and visual check types, which can leave theirs empty")
+	watchCmd.PersistentFlags().StringSlice("json_url_patterns", nil, "Regex, for each URL in order, matched against network response URLs for the json check type - the check selector is then a gjson path into the matched response body")
+	watchCmd.PersistentFlags().Int("json_response_timeout", fetcher.DefaultJSONResponseTimeout, "Time (seconds) a json check waits for a matching network response")
+	watchCmd.PersistentFlags().StringSlice("item_selectors", nil, "CSS selector, for each URL in order, of the repeated items of a listing page - leave empty for a URL that is not a listing")
+	watchCmd.PersistentFlags().StringSlice("item_keys", nil, "What identifies an item of the listing for each URL - href, text or the name of an attribute (e.g. data-id) on the item or one of its children")
+	watchCmd.PersistentFlags().StringSlice("item_fields", nil, "CSS selectors, relative to an item and separated by |, of the fields shown for an item in notifications for each URL")
+	watchCmd.PersistentFlags().StringSlice("paginations", nil, "How to walk the pages of the listing for each URL - one of next, url or scroll, or leave empty to only use the first page")
+	watchCmd.PersistentFlags().StringSlice("next_selectors", nil, "CSS selector of the element to click to get to the next page, for each URL that uses next pagination")
+	watchCmd.PersistentFlags().StringSlice("page_url_templates", nil, "URL of the listing with {page} in place of the page number, for each URL that uses url pagination")
+	watchCmd.PersistentFlags().StringSlice("resource_policies", nil, "Override the root level resource_policy for each URL or leave empty for that URL to just use the root level one")
+	watchCmd.PersistentFlags().StringSlice("devices", nil, "Override the root level device for each URL or leave empty for that URL to just use the root level one")
+	watchCmd.PersistentFlags().StringSlice("diff_ignore", nil, "Noise filters applied before diffing a region for the diff check types - timestamps and/or counters (numbers followed by what they count, like 12 views, so prices still show up)")
+	watchCmd.PersistentFlags().StringSlice("diff_ignore_patterns", nil, "Regexes for tokens that are ignored when diffing a region for the diff check types")
+	watchCmd.PersistentFlags().Int("diff_max_size", fetcher.DefaultDiffMaxSize, "Max size (bytes) of the diff sent in a notification - larger diffs are trimmed")
+	watchCmd.PersistentFlags().Int("diff_context", fetcher.DefaultDiffContext, "Number of unchanged lines shown around each change in a diff")
+	watchCmd.PersistentFlags().String("visual_mode", fetcher.PixelVisualMode, "How screenshots are compared for the visual check type - pixel to count the changed pixels or perceptual to compare perceptual hashes")
+	watchCmd.PersistentFlags().Float64("visual_threshold", fetcher.DefaultVisualThreshold, "Percentage of changed pixels that counts as a change for the visual check type in pixel mode")
+	watchCmd.PersistentFlags().Int("visual_pixel_tolerance", fetcher.DefaultVisualPixelTolerance, "Per channel difference (0-255) below which a pixel is considered unchanged for the visual check type")
+	watchCmd.PersistentFlags().Int("visual_hash_distance", fetcher.DefaultVisualHashDistance, "Number of differing perceptual hash bits (out of 64) above which a screenshot counts as changed in perceptual mode")
+	watchCmd.PersistentFlags().StringSlice("visual_ignore_regions", nil, "Regions of the screenshot, for each URL in order, ignored by the visual check type - x:y:width:height in pixels, separated by |")
 	watchCmd.PersistentFlags().StringSlice("notify_paths", nil, "A url path/domain sequence that indicates a more unique circumstance that we might want to be notified about")
 
 	watchCmd.PersistentFlags().StringSlice("captcha_wait_selectors", nil, "Override the default captcha wait selector for each URL or leave empty for that URL to just use (user provided) default from root level cmd")
@@ -53,4 +73,18 @@ func init() {
 	watchCmd.PersistentFlags().StringSlice("captcha_iframe_wait_selectors", nil, "Override captcha iframe wait selector for each URL")
 
 	watchCmd.PersistentFlags().IntP("interval", "i", fetcher.DefaultInterval, "Interval (in seconds) to wait in between watching a selector")
+	watchCmd.PersistentFlags().StringSlice("intervals", nil, "Override the interval (in seconds) for each URL or leave empty for that URL to just use the interval")
+	watchCmd.PersistentFlags().Float64("interval_jitter", 0, "Fraction of the interval each wait is randomly made longer or shorter by, so checks are not perfectly periodic - cron schedules are only ever delayed, by up to this fraction of the wait")
+	watchCmd.PersistentFlags().StringSlice("schedules", nil, "Cron expression (minute hour day-of-month month day-of-week, or @hourly, @daily and so on) for each URL to check it at instead of every interval - e.g. \"*/2 9-17 * * mon-fri\" for every 2 minutes between 9am and 6pm on weekdays - or leave empty for that URL to use its interval")
+	watchCmd.PersistentFlags().StringSlice("active_hours", nil, "Windows of local time, for each URL, outside of which it is not checked - HH:MM-HH:MM optionally after days like mon-fri or sat, separated by | (e.g. \"mon-fri 09:00-18:00|sat 10:00-14:00\") - or leave empty for that URL to be checked at any time")
+	watchCmd.PersistentFlags().Int("concurrency", fetcher.DefaultWatchConcurrency, "Max number of targets that run at once, each in its own browser - serve uses its fetch_concurrency instead")
+	watchCmd.PersistentFlags().Int("shutdown_timeout", fetcher.DefaultShutdownTimeout, "Time (in seconds) a watch stopped by SIGINT or SIGTERM waits for the runs in flight and the queued notifications and dumps before closing the browser and exiting with a non-zero status")
+	watchCmd.PersistentFlags().Bool("watch_config", false, "Reload the config whenever its file changes, as on SIGHUP - added, removed and changed targets are applied without restarting, the others keep their state and schedule, and an invalid config is rejected with the current targets kept running")
+
+	// serve runs the same watch, so it takes the same options - except for the per-URL ones, which its targets set
+	watchCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if !fetcher.PerTargetFlag(f.Name) {
+			serveCmd.Flags().AddFlag(f)
+		}
+	})
 }
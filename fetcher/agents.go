@@ -0,0 +1,341 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	agentStatsKeyPrefix = "agent-stats-"
+
+	// agentHostsKey lists the hosts we have agent stats for, since the state store can't list its keys
+	agentHostsKey = "agent-stats-hosts"
+
+	// agentStatsFlushInterval is how often the stats recorded by runs are saved to the state store, rather than on every run
+	agentStatsFlushInterval = 30 * time.Second
+)
+
+var (
+	gAgentManager     *agentManager
+	gAgentManagerOnce sync.Once
+)
+
+// agentStats is what we learned about a single user-agent on a single host
+type agentStats struct {
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+	LastSuccess time.Time `json:"last_success"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// agentManager chooses the user-agent for each run by its success rate on the target host and persists what it learns in the state store
+type agentManager struct {
+	mu     sync.Mutex
+	agents []string
+	stats  map[string]map[string]*agentStats // host -> agent -> stats
+	hosts  map[string]bool                   // every host that has stats in the state store
+
+	// what changed since the last flush
+	dirty      map[string]bool
+	hostsDirty bool
+}
+
+// agents returns the agent manager, creating it from the agents flag on first use
+func agents() *agentManager {
+	gAgentManagerOnce.Do(func() {
+		gAgentManager = &agentManager{agents: agentsFromFlags(), stats: map[string]map[string]*agentStats{}, dirty: map[string]bool{}}
+		go gAgentManager.flushEvery(agentStatsFlushInterval)
+	})
+
+	return gAgentManager
+}
+
+// agentsFromFlags returns the fingerprint profiles, or else the user-agents, that runs rotate through
+func agentsFromFlags() []string {
+	// fingerprint profiles are rotated, and learned about, the same way as plain user-agents
+	list := viper.GetStringSlice("profiles")
+	if len(list) != 0 {
+		Log().Infof("Running with [%d] fingerprint profiles: [%s]", len(list), list)
+		return list
+	}
+	list = viper.GetStringSlice("agents")
+	if len(list) == 0 {
+		Log().Info("No user agents specified, setting to default")
+		list = DefaultUserAgents
+	}
+	Log().Infof("Running with [%d] user-agents: [%s]", len(list), list)
+	return list
+}
+
+// reload picks up changed agents or profiles flags - what was learned about each agent is kept
+func (a *agentManager) reload() {
+	list := agentsFromFlags()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.agents = list
+}
+
+// pick chooses the agent for a run against the target URL, weighted by how often each agent succeeded on its host
+func (a *agentManager) pick(targetURL string) string {
+	host := hostOf(targetURL)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := a.hostStats(host)
+	weights := make([]float64, len(a.agents))
+	total := 0.0
+	for i, agent := range a.agents {
+		weights[i] = stats[agent].rate()
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			Log().Infof("Using user-agent [%s] for URL [%s], which has a success rate of [%.2f] on host [%s]", a.agents[i], targetURL, w, host)
+			return a.agents[i]
+		}
+		r -= w
+	}
+	return a.agents[len(a.agents)-1]
+}
+
+// record updates the stats of the agent on the host of the target URL, they are persisted by the next flush
+func (a *agentManager) record(targetURL string, agent string, success bool) {
+	if len(agent) == 0 {
+		return
+	}
+	host := hostOf(targetURL)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := a.hostStats(host)
+	s, ok := stats[agent]
+	if !ok {
+		s = &agentStats{}
+		stats[agent] = s
+	}
+	if success {
+		s.Successes++
+		s.LastSuccess = time.Now().UTC()
+	} else {
+		s.Failures++
+		s.LastFailure = time.Now().UTC()
+		Log().Infof("User-agent [%s] failed for URL [%s], its success rate on host [%s] is now [%.2f]", agent, targetURL, host, s.rate())
+	}
+
+	a.dirty[host] = true
+	if !a.hosts[host] {
+		a.hosts[host] = true
+		a.hostsDirty = true
+	}
+}
+
+// flushEvery flushes the stats on the interval, for as long as the process runs
+func (a *agentManager) flushEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		a.flush()
+	}
+}
+
+// flush saves the stats of the hosts that changed since the last flush - what fails to save is tried again by the next one
+func (a *agentManager) flush() {
+	a.mu.Lock()
+	pending := map[string][]byte{}
+	for host := range a.dirty {
+		data, err := json.Marshal(a.stats[host])
+		if err != nil {
+			Log().Errorf("Failed to save user-agent stats for host [%s]: %v", host, err)
+			continue
+		}
+		pending[host] = data
+	}
+	a.dirty = map[string]bool{}
+	var hosts map[string]bool
+	if a.hostsDirty {
+		hosts = make(map[string]bool, len(a.hosts))
+		for h := range a.hosts {
+			hosts[h] = true
+		}
+		a.hostsDirty = false
+	}
+	a.mu.Unlock()
+
+	// the state store is written without holding mu, so a slow one doesn't hold up picking the agent of a run
+	var failed []string
+	for host, data := range pending {
+		if err := state().set(agentStatsKeyPrefix+host, string(data)); err != nil {
+			Log().Errorf("Failed to save user-agent stats for host [%s]: %v", host, err)
+			failed = append(failed, host)
+		}
+	}
+	hostsFailed := false
+	if hosts != nil {
+		if err := saveAgentHosts(hosts); err != nil {
+			Log().Errorf("Failed to save the hosts with user-agent stats: %v", err)
+			hostsFailed = true
+		}
+	}
+
+	if len(failed) == 0 && !hostsFailed {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, host := range failed {
+		a.dirty[host] = true
+	}
+	a.hostsDirty = a.hostsDirty || hostsFailed
+}
+
+// hostStats returns the stats for the host, loading them from the state store the first time - must hold mu
+func (a *agentManager) hostStats(host string) map[string]*agentStats {
+	if a.hosts == nil {
+		hosts, err := loadAgentHosts()
+		if err != nil {
+			Log().Errorf("Failed to load the hosts with user-agent stats, starting over: %v", err)
+		}
+		a.hosts = hosts
+	}
+
+	if stats, ok := a.stats[host]; ok {
+		return stats
+	}
+
+	stats, err := loadAgentStats(host)
+	if err != nil {
+		Log().Errorf("Failed to load user-agent stats for host [%s], starting over: %v", host, err)
+	}
+	a.stats[host] = stats
+	return stats
+}
+
+// rate is the success rate, smoothed so agents we know little about still get tried
+func (s *agentStats) rate() float64 {
+	if s == nil {
+		return 0.5
+	}
+	return float64(s.Successes+1) / float64(s.Successes+s.Failures+2)
+}
+
+func loadAgentStats(host string) (map[string]*agentStats, error) {
+	stats := map[string]*agentStats{}
+	raw, found, err := state().get(agentStatsKeyPrefix + host)
+	if err != nil || !found {
+		return stats, err
+	}
+	if err = json.Unmarshal([]byte(raw), &stats); err != nil {
+		return map[string]*agentStats{}, err
+	}
+	return stats, nil
+}
+
+func loadAgentHosts() (map[string]bool, error) {
+	hosts := map[string]bool{}
+	raw, found, err := state().get(agentHostsKey)
+	if err != nil || !found {
+		return hosts, err
+	}
+	var list []string
+	if err = json.Unmarshal([]byte(raw), &list); err != nil {
+		return hosts, err
+	}
+	for _, h := range list {
+		hosts[h] = true
+	}
+	return hosts, nil
+}
+
+func saveAgentHosts(hosts map[string]bool) error {
+	var list []string
+	for h := range hosts {
+		list = append(list, h)
+	}
+	sort.Strings(list)
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return state().set(agentHostsKey, string(data))
+}
+
+// agentStatsRecord is a row of the agents stats output
+type agentStatsRecord struct {
+	Host  string  `json:"host"`
+	Agent string  `json:"agent"`
+	Rate  float64 `json:"success_rate"`
+	agentStats
+}
+
+// PrintAgentStats prints the persisted user-agent stats, per host, best agents first
+func PrintAgentStats(cmd *cobra.Command) {
+	viper.BindPFlags(cmd.Flags())
+
+	hosts, err := loadAgentHosts()
+	if err != nil {
+		Log().Fatalf("Failed to load the hosts with user-agent stats: %v", err)
+	}
+
+	var records []agentStatsRecord
+	filter := strings.ToLower(viper.GetString("host"))
+	for h := range hosts {
+		if len(filter) != 0 && h != filter {
+			continue
+		}
+		stats, err := loadAgentStats(h)
+		if err != nil {
+			Log().Errorf("Failed to load user-agent stats for host [%s]: %v", h, err)
+			continue
+		}
+		for agent, s := range stats {
+			records = append(records, agentStatsRecord{Host: h, Agent: agent, Rate: s.rate(), agentStats: *s})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Host != records[j].Host {
+			return records[i].Host < records[j].Host
+		}
+		if records[i].Rate != records[j].Rate {
+			return records[i].Rate > records[j].Rate
+		}
+		return records[i].Agent < records[j].Agent
+	})
+
+	if viper.GetBool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			enc.Encode(r)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSUCCESS RATE\tSUCCESSES\tFAILURES\tLAST SUCCESS\tLAST FAILURE\tAGENT")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%.2f\t%d\t%d\t%s\t%s\t%s\n", r.Host, r.Rate, r.Successes, r.Failures, formatStatsTime(r.LastSuccess), formatStatsTime(r.LastFailure), r.Agent)
+	}
+	w.Flush()
+}
+
+func formatStatsTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,290 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// TextOnlyResourcePolicy preset that blocks images, fonts, media and common trackers
+	TextOnlyResourcePolicy = "text-only"
+
+	// NoResourcePolicy preset that does not block anything
+	NoResourcePolicy = "none"
+
+	// CustomResourcePolicy uses the block_resource_types and block_url_globs flags
+	CustomResourcePolicy = "custom"
+)
+
+var (
+	// DefaultTrackerGlobs URL globs for common ad and tracker hosts blocked by the text-only preset
+	DefaultTrackerGlobs = []string{
+		`*doubleclick.net*`,
+		`*googlesyndication.com*`,
+		`*google-analytics.com*`,
+		`*googletagmanager.com*`,
+		`*googletagservices.com*`,
+		`*facebook.net*`,
+		`*connect.facebook.com*`,
+		`*scorecardresearch.com*`,
+		`*hotjar.com*`,
+		`*amazon-adsystem.com*`,
+		`*adsrvr.org*`,
+		`*criteo.com*`,
+		`*taboola.com*`,
+		`*outbrain.com*`,
+	}
+
+	resourcePolicyPresets = map[string]func() *resourcePolicy{
+		TextOnlyResourcePolicy: func() *resourcePolicy {
+			return newResourcePolicy(TextOnlyResourcePolicy, []string{"image", "font", "media"}, DefaultTrackerGlobs)
+		},
+		NoResourcePolicy: func() *resourcePolicy {
+			return nil
+		},
+		CustomResourcePolicy: func() *resourcePolicy {
+			return newResourcePolicy(CustomResourcePolicy, viper.GetStringSlice("block_resource_types"), viper.GetStringSlice("block_url_globs"))
+		},
+	}
+)
+
+// resourcePolicy decides which requests of a page load get aborted
+type resourcePolicy struct {
+	name  string
+	types []network.ResourceType
+	globs []string
+	urls  []*regexp.Regexp
+}
+
+// blockActions enables request interception for the URL before we navigate to it, aborts anything the policy blocks and answers the auth challenges of the proxy
+type blockActions struct {
+	url    string
+	policy *resourcePolicy
+}
+
+func newResourcePolicy(name string, types []string, globs []string) *resourcePolicy {
+	p := &resourcePolicy{name: name, globs: globs}
+	for _, t := range types {
+		p.types = append(p.types, network.ResourceType(resourceTypeName(t)))
+	}
+	for _, g := range globs {
+		p.urls = append(p.urls, globToRegexp(g))
+	}
+
+	return p
+}
+
+// getResourcePolicy returns the named preset - a nil policy means nothing is blocked
+func getResourcePolicy(name string) (*resourcePolicy, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	preset, ok := resourcePolicyPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown resource policy [%s] - must be one of [%s], [%s] or [%s]", name, TextOnlyResourcePolicy, NoResourcePolicy, CustomResourcePolicy)
+	}
+
+	return preset(), nil
+}
+
+// checkResourcePolicies validates the resource policy names and custom policy flags that were passed in
+func checkResourcePolicies(names ...string) error {
+	for _, n := range names {
+		if _, err := getResourcePolicy(n); err != nil {
+			return err
+		}
+	}
+	for _, t := range viper.GetStringSlice("block_resource_types") {
+		if len(resourceTypeName(t)) == 0 {
+			return fmt.Errorf("Unknown resource type [%s] in block_resource_types", t)
+		}
+	}
+
+	return nil
+}
+
+func (p *resourcePolicy) patterns() []*fetch.RequestPattern {
+	var patterns []*fetch.RequestPattern
+	for _, t := range p.types {
+		patterns = append(patterns, &fetch.RequestPattern{URLPattern: "*", ResourceType: t, RequestStage: fetch.RequestStageRequest})
+	}
+	for _, g := range p.globs {
+		patterns = append(patterns, &fetch.RequestPattern{URLPattern: g, RequestStage: fetch.RequestStageRequest})
+	}
+
+	return patterns
+}
+
+func (p *resourcePolicy) blocks(resourceType network.ResourceType, requestURL string) bool {
+	// never abort the page we are actually loading
+	if resourceType == network.ResourceTypeDocument {
+		return false
+	}
+	for _, t := range p.types {
+		if t == resourceType {
+			return true
+		}
+	}
+	for _, re := range p.urls {
+		if re.MatchString(requestURL) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b blockActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
+	actions = append(actions,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			// the proxy is only known once the run starts, and there is one fetch.Enable per target, so it handles both
+			proxy := runInfoFromContext(ctx).proxy
+			auth := proxy != nil && proxy.url.User != nil
+			if b.policy == nil && !auth {
+				return nil
+			}
+
+			var patterns []*fetch.RequestPattern
+			if b.policy != nil {
+				Log().Infof("Using resource policy [%s] for URL [%s]", b.policy.name, b.url)
+				patterns = b.policy.patterns()
+			}
+			if auth {
+				// auth challenges only come for intercepted requests, so we need to see all of them
+				patterns = []*fetch.RequestPattern{{URLPattern: "*", RequestStage: fetch.RequestStageRequest}}
+			}
+
+			var blocked, allowed int64
+			var authMu sync.Mutex
+			authAttempts := map[fetch.RequestID]bool{}
+			c := chromedp.FromContext(ctx)
+			execCtx := cdp.WithExecutor(ctx, c.Target)
+
+			chromedp.ListenTarget(ctx, func(ev interface{}) {
+				switch e := ev.(type) {
+				case *fetch.EventRequestPaused:
+					// commands can't be issued from within the listener itself
+					go func() {
+						var err error
+						if b.policy != nil && b.policy.blocks(e.ResourceType, e.Request.URL) {
+							atomic.AddInt64(&blocked, 1)
+							Log().Debugf("Blocking [%s] request [%s] for URL [%s]", e.ResourceType, e.Request.URL, b.url)
+							err = fetch.FailRequest(e.RequestID, network.ErrorReasonBlockedByClient).Do(execCtx)
+						} else {
+							atomic.AddInt64(&allowed, 1)
+							err = fetch.ContinueRequest(e.RequestID).Do(execCtx)
+						}
+						if err != nil && ctx.Err() == nil {
+							Log().Debugf("Failed to resolve intercepted request [%s] for URL [%s]: %v", e.Request.URL, b.url, err)
+						}
+					}()
+				case *fetch.EventAuthRequired:
+					go func() {
+						res := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+						if e.AuthChallenge.Source == fetch.AuthChallengeSourceProxy && auth {
+							authMu.Lock()
+							retry := authAttempts[e.RequestID]
+							authAttempts[e.RequestID] = true
+							authMu.Unlock()
+
+							if retry {
+								// the credentials were already rejected once for this request, don't keep trying them
+								res.Response = fetch.AuthChallengeResponseResponseCancelAuth
+								markProxyBad(ctx, "credentials rejected")
+							} else {
+								password, _ := proxy.url.User.Password()
+								res.Response = fetch.AuthChallengeResponseResponseProvideCredentials
+								res.Username = proxy.url.User.Username()
+								res.Password = password
+							}
+						}
+						err := fetch.ContinueWithAuth(e.RequestID, res).Do(execCtx)
+						if err != nil && ctx.Err() == nil {
+							Log().Debugf("Failed to answer auth challenge for [%s] for URL [%s]: %v", e.Request.URL, b.url, err)
+						}
+					}()
+				}
+			})
+
+			if b.policy != nil {
+				go func() {
+					<-ctx.Done()
+					Log().Infof("Resource policy [%s] blocked [%d] requests and let through [%d] intercepted requests for URL [%s]", b.policy.name, atomic.LoadInt64(&blocked), atomic.LoadInt64(&allowed), b.url)
+				}()
+			}
+
+			return fetch.Enable().WithPatterns(patterns).WithHandleAuthRequests(auth).Do(ctx)
+		}))
+
+	return actions
+}
+
+// resourceTypeName maps a user supplied resource type, in any case, to the devtools name - empty if unknown
+func resourceTypeName(t string) string {
+	for _, rt := range []network.ResourceType{
+		network.ResourceTypeDocument,
+		network.ResourceTypeStylesheet,
+		network.ResourceTypeImage,
+		network.ResourceTypeMedia,
+		network.ResourceTypeFont,
+		network.ResourceTypeScript,
+		network.ResourceTypeTextTrack,
+		network.ResourceTypeXHR,
+		network.ResourceTypeFetch,
+		network.ResourceTypePrefetch,
+		network.ResourceTypeEventSource,
+		network.ResourceTypeWebSocket,
+		network.ResourceTypeManifest,
+		network.ResourceTypeSignedExchange,
+		network.ResourceTypePing,
+		network.ResourceTypeCSPViolationReport,
+		network.ResourceTypePreflight,
+		network.ResourceTypeOther,
+	} {
+		if strings.EqualFold(string(rt), t) {
+			return string(rt)
+		}
+	}
+
+	return ""
+}
+
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
+
+// targetResourcePolicy returns the policy for the URL at index i, where an empty override falls back to the root level resource_policy
+func targetResourcePolicy(overrides []string, i int) *resourcePolicy {
+	name := viper.GetString("resource_policy")
+	if len(overrides) > i && len(overrides[i]) != 0 {
+		name = overrides[i]
+	}
+
+	// names are validated in the common checks, so an error can't happen here
+	p, _ := getResourcePolicy(name)
+	return p
+}
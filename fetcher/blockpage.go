@@ -0,0 +1,313 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// RotateAgentBlockAction counts the block against the user-agent, so it is less likely to be picked for the host again
+	RotateAgentBlockAction = "rotate_agent"
+
+	// RotateProxyBlockAction leaves the proxy of the run out of the rotation for its cooldown
+	RotateProxyBlockAction = "rotate_proxy"
+
+	// BackoffBlockAction makes the target back off right away, rather than after backoff_after failures in a row
+	BackoffBlockAction = "backoff"
+
+	// NotifyBlockAction sends a screenshot of the block page to the notifier of the command
+	NotifyBlockAction = "notify"
+
+	// accessDeniedBlockRules are the preset rules detect_access_denied turns on
+	accessDeniedBlockRules = "access-denied"
+)
+
+var (
+	// blockRulePresets built in rules for the block pages of common CDNs and bot protection services
+	blockRulePresets = map[string][]*blockRule{
+		accessDeniedBlockRules: {
+			{Name: "access-denied", Title: `Access Denied`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+		},
+		"akamai": {
+			{Name: "akamai", Title: `(?i)^\s*access denied\s*$`, Body: `(?i)reference\s*#\s*[0-9a-f]+\.[0-9a-f.]+`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+		},
+		"cloudflare": {
+			{Name: "cloudflare-challenge", Title: `(?i)^\s*(just a moment|attention required)`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+			{Name: "cloudflare-challenge-response", Header: `(?im)^cf-mitigated: challenge\s*$`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+			{Name: "cloudflare-blocked", Selector: `#cf-error-details, .cf-error-details`, Status: []int64{403}, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+			{Name: "cloudflare-rate-limited", Selector: `#cf-error-details, .cf-error-details`, Status: []int64{429}, Actions: []string{BackoffBlockAction}},
+		},
+		"datadome": {
+			{Name: "datadome", Selector: `iframe[src*="captcha-delivery.com"]`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+			{Name: "datadome-response", Header: `(?m)^x-datadome: `, Status: []int64{403}, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+		},
+		"incapsula": {
+			{Name: "incapsula", Selector: `iframe[src*="_Incapsula_Resource"]`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+			{Name: "incapsula-incident", Body: `(?i)incapsula incident id`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction}},
+		},
+		"perimeterx": {
+			{Name: "perimeterx", Selector: `#px-captcha`, Actions: []string{RotateAgentBlockAction, RotateProxyBlockAction, NotifyBlockAction}},
+		},
+		"rate-limit": {
+			{Name: "rate-limit", Status: []int64{429}, Actions: []string{BackoffBlockAction}},
+		},
+	}
+
+	gBlockRules     []*blockRule
+	gBlockRulesErr  error
+	gBlockRulesOnce sync.Once
+)
+
+// blockRule detects a block page - every condition that is set must match, and then its counter actions are taken
+type blockRule struct {
+	Name         string   `json:"name"`
+	Title        string   `json:"title"`         // regex matched against the page title
+	Body         string   `json:"body"`          // regex matched against the text of the page
+	Selector     string   `json:"selector"`      // present on the page
+	Status       []int64  `json:"status"`        // HTTP status of the main document is one of these
+	Header       string   `json:"header"`        // regex matched against the response headers of the main document, one name: value line each with lower case names
+	RedirectHost string   `json:"redirect_host"` // regex matched against the host we ended up at, if it isn't the host of the target
+	Actions      []string `json:"actions"`
+
+	title        *regexp.Regexp
+	body         *regexp.Regexp
+	header       *regexp.Regexp
+	redirectHost *regexp.Regexp
+}
+
+// blockPage is what the rules are matched against, the text and selectors are only looked up when a rule needs them
+type blockPage struct {
+	targetURL string
+	title     string
+	location  string
+	status    int64
+	headers   string
+	text      *string
+}
+
+// loadBlockRules compiles the presets and reads the block_rules_file, once
+func loadBlockRules() ([]*blockRule, error) {
+	gBlockRulesOnce.Do(func() {
+		for name, rules := range blockRulePresets {
+			for _, r := range rules {
+				if err := r.compile(); err != nil {
+					gBlockRulesErr = fmt.Errorf("Invalid preset block rules [%s]: %v", name, err)
+					return
+				}
+			}
+		}
+
+		path := viper.GetString("block_rules_file")
+		if len(path) == 0 {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			gBlockRulesErr = fmt.Errorf("Failed to read block_rules_file [%s]: %v", path, err)
+			return
+		}
+		if err = json.Unmarshal(data, &gBlockRules); err != nil {
+			gBlockRulesErr = fmt.Errorf("Failed to parse block_rules_file [%s], it must be a JSON array of rules: %v", path, err)
+			return
+		}
+		for _, r := range gBlockRules {
+			if err = r.compile(); err != nil {
+				gBlockRulesErr = fmt.Errorf("Invalid rule in block_rules_file [%s]: %v", path, err)
+				return
+			}
+		}
+	})
+
+	return gBlockRules, gBlockRulesErr
+}
+
+// checkBlockRules validates the block rule flags
+func checkBlockRules() error {
+	if _, err := loadBlockRules(); err != nil {
+		return err
+	}
+	for _, name := range viper.GetStringSlice("block_rules") {
+		if _, ok := blockRulePresets[name]; !ok {
+			return fmt.Errorf("Unknown block_rules [%s] - must be one of [%s]", name, strings.Join(blockRulePresetNames(), "], ["))
+		}
+	}
+
+	return nil
+}
+
+func blockRulePresetNames() []string {
+	var names []string
+	for name := range blockRulePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// activeBlockRules returns the rules to match pages against - the presets from block_rules, the access denied preset if it is turned on and the rules of the block_rules_file
+func activeBlockRules(accessDenied bool) []*blockRule {
+	custom, err := loadBlockRules()
+	if err != nil {
+		// the rules are validated in the common checks, so this shouldn't happen
+		Log().Errorf("%v", err)
+	}
+
+	names := viper.GetStringSlice("block_rules")
+	if accessDenied {
+		names = append([]string{accessDeniedBlockRules}, names...)
+	}
+
+	var rules []*blockRule
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		rules = append(rules, blockRulePresets[name]...)
+	}
+
+	return append(rules, custom...)
+}
+
+func (r *blockRule) compile() error {
+	if len(r.Name) == 0 {
+		return fmt.Errorf("Every block rule requires a name")
+	}
+	if len(r.Title) == 0 && len(r.Body) == 0 && len(r.Selector) == 0 && len(r.Status) == 0 && len(r.Header) == 0 && len(r.RedirectHost) == 0 {
+		return fmt.Errorf("Block rule [%s] requires at least one of title, body, selector, status, header or redirect_host", r.Name)
+	}
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("Block rule [%s] requires at least one action", r.Name)
+	}
+	for _, a := range r.Actions {
+		switch a {
+		case RotateAgentBlockAction, RotateProxyBlockAction, BackoffBlockAction, NotifyBlockAction:
+		default:
+			return fmt.Errorf("Unknown action [%s] for block rule [%s] - must be one of [%s], [%s], [%s] or [%s]", a, r.Name, RotateAgentBlockAction, RotateProxyBlockAction, BackoffBlockAction, NotifyBlockAction)
+		}
+	}
+
+	var err error
+	compile := func(field string, expr string) *regexp.Regexp {
+		if len(expr) == 0 || err != nil {
+			return nil
+		}
+		re, e := regexp.Compile(expr)
+		if e != nil {
+			err = fmt.Errorf("Invalid %s regex [%s] for block rule [%s]: %v", field, expr, r.Name, e)
+		}
+		return re
+	}
+	r.title = compile("title", r.Title)
+	r.body = compile("body", r.Body)
+	r.header = compile("header", r.Header)
+	r.redirectHost = compile("redirect_host", r.RedirectHost)
+
+	return err
+}
+
+// match reports whether every condition of the rule holds for the page
+func (r *blockRule) match(ctx context.Context, p *blockPage) (bool, error) {
+	if len(r.Status) != 0 {
+		found := false
+		for _, s := range r.Status {
+			found = found || s == p.status
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if r.title != nil && !r.title.MatchString(p.title) {
+		return false, nil
+	}
+	if r.header != nil && !r.header.MatchString(p.headers) {
+		return false, nil
+	}
+	if r.redirectHost != nil {
+		host := hostOf(p.location)
+		if host == hostOf(p.targetURL) || !r.redirectHost.MatchString(host) {
+			return false, nil
+		}
+	}
+	if r.body != nil {
+		if p.text == nil {
+			var text string
+			if err := chromedp.Evaluate(`document.body ? document.body.innerText : ''`, &text).Do(ctx); err != nil {
+				return false, err
+			}
+			p.text = &text
+		}
+		if !r.body.MatchString(*p.text) {
+			return false, nil
+		}
+	}
+	if len(r.Selector) != 0 {
+		var present bool
+		if err := chromedp.Evaluate(fmt.Sprintf(`document.querySelector(%s) !== null`, jsString(r.Selector)), &present).Do(ctx); err != nil {
+			return false, err
+		}
+		if !present {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// detectBlockPage matches the page against the rules in order and returns the first that matched, nil if the page isn't a block page
+func detectBlockPage(ctx context.Context, rules []*blockRule, targetURL string) (*blockRule, *blockPage, error) {
+	p := &blockPage{targetURL: targetURL}
+	document := runInfoFromContext(ctx).document
+	p.status, _ = document.get()
+	p.headers = document.headerLines()
+	if err := chromedp.Title(&p.title).Do(ctx); err != nil {
+		return nil, p, err
+	}
+	if err := chromedp.Location(&p.location).Do(ctx); err != nil {
+		return nil, p, err
+	}
+
+	for _, r := range rules {
+		matched, err := r.match(ctx, p)
+		if err != nil {
+			return nil, p, err
+		}
+		if matched {
+			return r, p, nil
+		}
+	}
+
+	return nil, p, nil
+}
+
+// counter takes the actions of the rule that matched the page of the run
+func (r *blockRule) counter(ctx context.Context, p *blockPage, notify func(text string, image []byte)) {
+	info := runInfoFromContext(ctx)
+	for _, a := range r.Actions {
+		switch a {
+		case RotateAgentBlockAction:
+			Log().Infof("Counting block rule [%s] against the current user-agent [%s] for this URL [%s] so we are less likely to use it during the next request", r.Name, info.agent, p.targetURL)
+			info.agentBlocked = true
+		case RotateProxyBlockAction:
+			markProxyBad(ctx, fmt.Sprintf("blocked by rule %s", r.Name))
+		case BackoffBlockAction:
+			limits().block(p.targetURL)
+		case NotifyBlockAction:
+			var shot []byte
+			if err := chromedp.CaptureScreenshot(&shot).Do(ctx); err != nil {
+				Log().Errorf("Failed to take a screenshot of the block page for URL [%s]: %v", p.targetURL, err)
+			}
+			notify(fmt.Sprintf("Blocked by rule %s for %s - the page is %s (status %d) at %s", r.Name, p.targetURL, p.title, p.status, p.location), shot)
+		}
+	}
+}
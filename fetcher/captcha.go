@@ -0,0 +1,284 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ManualCaptchaSolver pauses so a human can solve the captcha in the browser window
+	ManualCaptchaSolver = "manual"
+
+	// HTTPCaptchaSolver posts the challenge to the captcha_solver_url and injects the token it returns
+	HTTPCaptchaSolver = "http"
+
+	// DefaultCaptchaSolveTimeout default time (seconds) we wait for a captcha to be solved
+	DefaultCaptchaSolveTimeout = 300
+
+	// captchaPollInterval is how often the manual solver checks whether the captcha was solved
+	captchaPollInterval = 2 * time.Second
+)
+
+// CaptchaChallenge is a captcha found on a page
+type CaptchaChallenge struct {
+	Type    string `json:"type"` // recaptcha, hcaptcha, turnstile or datadome (which only the manual solver can solve)
+	SiteKey string `json:"site_key"`
+	PageURL string `json:"page_url"`
+}
+
+// CaptchaSolver solves a captcha challenge - it returns the token to inject into the page, or an empty token if the challenge was solved in the page itself
+type CaptchaSolver interface {
+	Solve(ctx context.Context, challenge CaptchaChallenge) (string, error)
+}
+
+// manualCaptchaSolver notifies a human with a screenshot and waits for them to solve the captcha in the non-headless window
+type manualCaptchaSolver struct {
+	notify  func(text string, image []byte)
+	timeout time.Duration
+}
+
+// httpCaptchaSolver sends the challenge to a solving service, which responds with the token
+type httpCaptchaSolver struct {
+	url     string
+	key     string
+	timeout time.Duration
+}
+
+// httpCaptchaResponse is what the solving service responds with
+type httpCaptchaResponse struct {
+	Token string `json:"token"`
+	Error string `json:"error"`
+}
+
+// newCaptchaSolver returns the solver configured by captcha_solver for the run, nil if captchas aren't solved
+func newCaptchaSolver(config runConfig, notify func(text string, image []byte)) CaptchaSolver {
+	timeout := time.Duration(config.captchaSolveTimeout) * time.Second
+	switch config.captchaSolver {
+	case ManualCaptchaSolver:
+		return manualCaptchaSolver{notify: notify, timeout: timeout}
+	case HTTPCaptchaSolver:
+		return httpCaptchaSolver{url: config.captchaSolverURL, key: config.captchaSolverKey, timeout: timeout}
+	}
+
+	return nil
+}
+
+// checkCaptchaSolver validates the captcha solver flags
+func checkCaptchaSolver() error {
+	switch viper.GetString("captcha_solver") {
+	case "":
+	case ManualCaptchaSolver:
+		if viper.GetBool("headless") {
+			return fmt.Errorf("The [%s] captcha_solver needs a browser window for a human to solve the captcha in, so it can't run in headless mode", ManualCaptchaSolver)
+		}
+	case HTTPCaptchaSolver:
+		if len(viper.GetString("captcha_solver_url")) == 0 {
+			return fmt.Errorf("The [%s] captcha_solver requires a captcha_solver_url", HTTPCaptchaSolver)
+		}
+	default:
+		return fmt.Errorf("Unknown captcha_solver [%s] - must be one of [%s] or [%s]", viper.GetString("captcha_solver"), ManualCaptchaSolver, HTTPCaptchaSolver)
+	}
+
+	return nil
+}
+
+func (m manualCaptchaSolver) Solve(ctx context.Context, challenge CaptchaChallenge) (string, error) {
+	var shot []byte
+	if err := chromedp.CaptureScreenshot(&shot).Do(ctx); err != nil {
+		Log().Errorf("Failed to take a screenshot of the captcha for URL [%s]: %v", challenge.PageURL, err)
+	} else {
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("go-scraper-captcha-%d.png", time.Now().Unix()))
+		if err = os.WriteFile(path, shot, 0644); err != nil {
+			Log().Errorf("Failed to save the screenshot of the captcha for URL [%s]: %v", challenge.PageURL, err)
+		} else {
+			Log().Infof("Saved a screenshot of the captcha for URL [%s] to [%s]", challenge.PageURL, path)
+		}
+	}
+
+	text := fmt.Sprintf("A %s captcha needs to be solved by hand in the browser window for %s - waiting up to %s", challenge.Type, challenge.PageURL, m.timeout)
+	Log().Infof("%s", text)
+	if m.notify != nil {
+		m.notify(text, shot)
+	}
+
+	deadline := time.Now().Add(m.timeout)
+	for time.Now().Before(deadline) {
+		if err := chromedp.Sleep(captchaPollInterval).Do(ctx); err != nil {
+			return "", err
+		}
+		current, err := detectCaptcha(ctx)
+		if err != nil {
+			return "", err
+		}
+		if len(current.Type) == 0 || captchaAnswered(ctx, current) {
+			Log().Infof("Captcha for URL [%s] was solved by hand, resuming", challenge.PageURL)
+			return "", nil
+		}
+	}
+
+	return "", fmt.Errorf("Captcha for URL [%s] was not solved by hand within [%s]", challenge.PageURL, m.timeout)
+}
+
+func (h httpCaptchaSolver) Solve(ctx context.Context, challenge CaptchaChallenge) (string, error) {
+	body, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(h.key) != 0 {
+		req.Header.Set("Authorization", "Bearer "+h.key)
+	}
+
+	Log().Infof("Sending [%s] captcha for URL [%s] to solver [%s]", challenge.Type, challenge.PageURL, h.url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Captcha solver [%s] failed: %v", h.url, err)
+	}
+	defer resp.Body.Close()
+
+	var res httpCaptchaResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("Captcha solver [%s] returned [%s] with an invalid body: %v", h.url, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || len(res.Error) != 0 || len(res.Token) == 0 {
+		return "", fmt.Errorf("Captcha solver [%s] returned [%s] without a token: %s", h.url, resp.Status, res.Error)
+	}
+
+	return res.Token, nil
+}
+
+// detectCaptcha finds the type and site key of the captcha on the page - an empty type means there is none
+func detectCaptcha(ctx context.Context) (CaptchaChallenge, error) {
+	var c CaptchaChallenge
+	err := chromedp.Evaluate(`(() => {
+		const frameKey = host => {
+			const f = document.querySelector('iframe[src*="' + host + '"]');
+			if (!f) {
+				return null;
+			}
+			const u = new URL(f.src, location.href);
+			return u.searchParams.get('k') || u.searchParams.get('sitekey') || '';
+		};
+		const key = sel => {
+			const e = document.querySelector(sel);
+			return e ? e.getAttribute('data-sitekey') : null;
+		};
+		const found = (type, k) => k !== null ? {type: type, site_key: k} : null;
+		return found('turnstile', key('.cf-turnstile[data-sitekey]') ?? frameKey('challenges.cloudflare.com')) ||
+			found('hcaptcha', key('.h-captcha[data-sitekey]') ?? frameKey('hcaptcha.com')) ||
+			found('recaptcha', key('.g-recaptcha[data-sitekey]') ?? frameKey('/recaptcha/')) ||
+			found('datadome', frameKey('captcha-delivery.com') !== null ? '' : null) ||
+			{type: '', site_key: ''};
+	})()`, &c).Do(ctx)
+	if err != nil {
+		return c, err
+	}
+
+	err = chromedp.Location(&c.PageURL).Do(ctx)
+	return c, err
+}
+
+// captchaResponseMarker returns the bot protection that marked the main document response as a challenge, empty if none did
+func captchaResponseMarker(d *documentResponse) string {
+	if v, ok := d.header("cf-mitigated"); ok && strings.EqualFold(v, "challenge") {
+		return "cloudflare"
+	}
+	if _, ok := d.header("x-datadome"); ok {
+		if status, _ := d.get(); status == http.StatusForbidden {
+			return "datadome"
+		}
+	}
+
+	return ""
+}
+
+// captchaCleared reports whether the page is past its captcha - none is left on it unanswered and the response is no longer marked as a challenge
+func captchaCleared(ctx context.Context) (bool, error) {
+	c, err := detectCaptcha(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(c.Type) != 0 && !captchaAnswered(ctx, c) {
+		return false, nil
+	}
+
+	return len(captchaResponseMarker(runInfoFromContext(ctx).document)) == 0, nil
+}
+
+// captchaAnswered reports whether the response field of the captcha already holds a token
+func captchaAnswered(ctx context.Context, c CaptchaChallenge) bool {
+	var answered bool
+	err := chromedp.Evaluate(fmt.Sprintf(`Array.from(document.querySelectorAll(%s)).some(e => !!e.value)`, jsString(captchaResponseSelector(c.Type))), &answered).Do(ctx)
+	return err == nil && answered
+}
+
+// injectCaptchaToken puts the token into the response fields of the captcha and lets the page know it was solved
+func injectCaptchaToken(ctx context.Context, c CaptchaChallenge, token string) error {
+	return chromedp.Evaluate(fmt.Sprintf(`(() => {
+		const token = %s;
+		const fields = Array.from(document.querySelectorAll(%s));
+		fields.forEach(e => {
+			e.value = token;
+			e.dispatchEvent(new Event('change', {bubbles: true}));
+		});
+		const widget = document.querySelector('[data-sitekey][data-callback]');
+		if (widget && typeof window[widget.getAttribute('data-callback')] === 'function') {
+			window[widget.getAttribute('data-callback')](token);
+		} else if (fields.length && fields[0].form) {
+			fields[0].form.submit();
+		}
+	})()`, jsString(token), jsString(captchaResponseSelector(c.Type))), nil).Do(ctx)
+}
+
+func captchaResponseSelector(captchaType string) string {
+	switch captchaType {
+	case "hcaptcha":
+		return `[name="h-captcha-response"], [name="g-recaptcha-response"]`
+	case "turnstile":
+		return `[name="cf-turnstile-response"]`
+	}
+	return `[name="g-recaptcha-response"]`
+}
+
+// solveCaptcha detects the captcha on the page and solves it with the solver
+func solveCaptcha(ctx context.Context, solver CaptchaSolver, targetURL string) error {
+	c, err := detectCaptcha(ctx)
+	if err != nil {
+		return err
+	}
+	if len(c.Type) == 0 {
+		return fmt.Errorf("Couldn't find a supported captcha on the page for URL [%s] to solve", targetURL)
+	}
+	Log().Infof("Found a [%s] captcha with site key [%s] for URL [%s]", c.Type, c.SiteKey, targetURL)
+	if _, manual := solver.(manualCaptchaSolver); c.Type == "datadome" && !manual {
+		return fmt.Errorf("A [%s] captcha for URL [%s] can only be solved with the [%s] captcha_solver", c.Type, targetURL, ManualCaptchaSolver)
+	}
+
+	token, err := solver.Solve(ctx, c)
+	if err != nil {
+		return err
+	}
+	if len(token) == 0 {
+		return nil
+	}
+
+	Log().Infof("Injecting the captcha token for URL [%s]", targetURL)
+	return injectCaptchaToken(ctx, c, token)
+}
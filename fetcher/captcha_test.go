@@ -0,0 +1,104 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPCaptchaSolver(t *testing.T) {
+	challenge := CaptchaChallenge{Type: "hcaptcha", SiteKey: "site-key", PageURL: "https://example.com/login"}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		timeout time.Duration
+		token   string
+		err     string
+	}{
+		{
+			name: "success",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				var got CaptchaChallenge
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil || got != challenge {
+					t.Errorf("Solver got challenge %+v (%v), want %+v", got, err, challenge)
+				}
+				if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+					t.Errorf("Solver got Authorization [%s], want [Bearer secret]", auth)
+				}
+				json.NewEncoder(w).Encode(httpCaptchaResponse{Token: "solved-token"})
+			},
+			timeout: 5 * time.Second,
+			token:   "solved-token",
+		},
+		{
+			name: "solver error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(httpCaptchaResponse{Error: "unsupported captcha"})
+			},
+			timeout: 5 * time.Second,
+			err:     "unsupported captcha",
+		},
+		{
+			name: "no token",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(httpCaptchaResponse{})
+			},
+			timeout: 5 * time.Second,
+			err:     "without a token",
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			timeout: 100 * time.Millisecond,
+			err:     "deadline exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			solver := httpCaptchaSolver{url: srv.URL, key: "secret", timeout: tt.timeout}
+			token, err := solver.Solve(context.Background(), challenge)
+			if len(tt.err) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Solve() error = %v, want one containing [%s]", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Solve() error = %v", err)
+			}
+			if token != tt.token {
+				t.Errorf("Solve() = [%s], want [%s]", token, tt.token)
+			}
+		})
+	}
+}
+
+func TestNewCaptchaSolver(t *testing.T) {
+	config := runConfig{captchaSolver: HTTPCaptchaSolver, captchaSolverURL: "http://solver", captchaSolverKey: "key", captchaSolveTimeout: 30}
+	solver, ok := newCaptchaSolver(config, nil).(httpCaptchaSolver)
+	if !ok {
+		t.Fatalf("newCaptchaSolver() = %T, want httpCaptchaSolver", newCaptchaSolver(config, nil))
+	}
+	if solver.url != "http://solver" || solver.key != "key" || solver.timeout != 30*time.Second {
+		t.Errorf("newCaptchaSolver() = %+v", solver)
+	}
+
+	if s := newCaptchaSolver(runConfig{}, nil); s != nil {
+		t.Errorf("newCaptchaSolver() without a captcha_solver = %T, want nil", s)
+	}
+}
@@ -0,0 +1,70 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+)
+
+// changeCheck is a check that compares the current run against what was seen on the previous run
+type changeCheck interface {
+	// detect returns what to notify with when something changed, or nil if nothing did
+	detect(ctx context.Context) (*changeNotice, error)
+}
+
+// changeNotice is what a change check notifies with
+type changeNotice struct {
+	text  string
+	image []byte // PNG attached to the notification, if any
+}
+
+// checkSources holds the data, other than the page itself, that a watch check can read from during a run
+type checkSources struct {
+	listing *itemListing // only for the items and list check types
+	change  changeCheck  // set for check types that notify on changes instead of comparing to an expected text
+}
+
+// isChangeCheckType tells whether the check type notifies on changes since the previous run, instead of comparing to an expected text
+func isChangeCheckType(checkType string) bool {
+	switch checkType {
+	case "list", "diff", "diff_html", "visual":
+		return true
+	}
+	return false
+}
+
+// extractCheckData extracts the value that a watch check compares against its expected text
+func extractCheckData(ctx context.Context, selector string, selectorType string, sources checkSources) (string, error) {
+	switch selectorType {
+	case "json":
+		responses := runInfoFromContext(ctx).responses
+		if responses == nil {
+			err := fmt.Errorf("Check type json requires a json_url_pattern for the URL")
+			Log().Errorf("%v", err)
+			return "", err
+		}
+		res, err := responses.extract(ctx, selector)
+		if err != nil {
+			Log().Errorf("%v", err)
+			return "", err
+		}
+		return res, nil
+	case "js":
+		return evaluateScript(ctx, selector)
+	case "document":
+		res, err := runInfoFromContext(ctx).document.extract(selector)
+		if err != nil {
+			Log().Errorf("%v", err)
+			return "", err
+		}
+		return res, nil
+	case "items":
+		if sources.listing == nil {
+			err := fmt.Errorf("Check type items requires an item_selector for the URL")
+			Log().Errorf("%v", err)
+			return "", err
+		}
+		return sources.listing.text(), nil
+	}
+
+	return extractData(ctx, selector, selectorType)
+}
@@ -0,0 +1,458 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultCrawlMaxDepth default number of link hops we follow from a seed URL
+	DefaultCrawlMaxDepth = 2
+
+	// DefaultCrawlMaxPages default max number of pages visited in a crawl
+	DefaultCrawlMaxPages = 100
+
+	// DefaultCrawlWorkers default number of pages loaded at the same time across all hosts
+	DefaultCrawlWorkers = 4
+
+	// DefaultCrawlHostConcurrency default number of pages loaded at the same time for a single host
+	DefaultCrawlHostConcurrency = 1
+
+	// DefaultCrawlDelay default time (milliseconds) between two page loads on the same host
+	DefaultCrawlDelay = 1000
+)
+
+// crawlRecord is written out as one NDJSON line per crawled page
+type crawlRecord struct {
+	URL       string    `json:"url"`
+	FinalURL  string    `json:"final_url,omitempty"`
+	Depth     int       `json:"depth"`
+	Parent    string    `json:"parent,omitempty"`
+	Data      string    `json:"data,omitempty"`
+	Links     int       `json:"links"`
+	Error     string    `json:"error,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// crawlJob is a single page in the crawl frontier
+type crawlJob struct {
+	url    string
+	parent string
+	depth  int
+}
+
+// linkActions collects the absolute URL of every link on the page
+type linkActions struct {
+	url   string
+	links *[]string
+}
+
+// hostSlot limits how many pages of a host we load at once and how quickly we start them
+type hostSlot struct {
+	sem chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// crawler follows links from the seed URLs, applying the same chain as fetch to each page
+type crawler struct {
+	maxDepth        int
+	maxPages        int
+	workers         int
+	hostConcurrency int
+	delay           time.Duration
+
+	allowedDomains []string
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+
+	page crawlPageOptions
+
+	mu      sync.Mutex
+	seen    map[string]bool
+	queued  int
+	hosts   map[string]*hostSlot
+	pending sync.WaitGroup
+	jobs    chan crawlJob
+
+	outMu sync.Mutex
+	out   *json.Encoder
+}
+
+// crawlPageOptions are the detect, wait and extract options applied to each crawled page
+type crawlPageOptions struct {
+	waitSelector string
+	textSelector string
+	hrefSelector string
+	idSelector   string
+	evalScript   string
+
+	detectAccessDenied        bool
+	detectCaptchaBox          bool
+	captchaWaitSelector       string
+	captchaClickSelector      string
+	captchaIframeWaitSelector string
+	captchaClickSleep         int
+
+	dumpOnError     bool
+	locationOnError bool
+	dumpToRedis     bool
+}
+
+func (l linkActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
+	actions = append(actions,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			err := chromedp.Evaluate(`Array.from(document.querySelectorAll('a[href]')).map(a => a.href)`, l.links).Do(ctx)
+			if err != nil {
+				Log().Errorf("Failed to collect links for URL [%s]: %v", l.url, err)
+				return err
+			}
+			Log().Debugf("Collected [%d] links for URL [%s]", len(*l.links), l.url)
+			return nil
+		}))
+
+	return actions
+}
+
+func (o crawlPageOptions) generators(u string, dumps chan dumpData, links *[]string) []actionGenerator {
+	return []actionGenerator{
+		blockActions{url: u, policy: targetResourcePolicy(nil, 0)},
+		deviceActions{url: u, device: targetDevice(nil, 0)},
+		navigateActions{url: u},
+		detectActions{url: u, detectAccessDenied: o.detectAccessDenied, detectCaptchaBox: o.detectCaptchaBox, captchaWaitSelector: o.captchaWaitSelector, captchaClickSelector: o.captchaClickSelector, captchaIframeWaitSelector: o.captchaIframeWaitSelector, captchaClickSleep: o.captchaClickSleep, dumpOnError: o.dumpOnError, locationOnError: o.locationOnError, dumpToRedis: o.dumpToRedis},
+		waitActions{url: u, waitSelector: o.waitSelector, dumpOnError: o.dumpOnError, locationOnError: o.locationOnError, dumpToRedis: o.dumpToRedis},
+		dumpActions{postActionData: dumps, textSelector: o.textSelector, hrefSelector: o.hrefSelector, idSelector: o.idSelector, evalScript: o.evalScript, url: u},
+		linkActions{url: u, links: links},
+	}
+}
+
+func (c *crawler) host(u string) *hostSlot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := hostOf(u)
+	s, ok := c.hosts[h]
+	if !ok {
+		s = &hostSlot{sem: make(chan struct{}, c.hostConcurrency)}
+		c.hosts[h] = s
+	}
+	return s
+}
+
+// acquire blocks until the host has a free slot and its crawl delay has passed, or the context is done - the slot is only held if it returns nil
+func (s *hostSlot) acquire(ctx context.Context, delay time.Duration) error {
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	wait := time.Until(s.next)
+	if wait < 0 {
+		wait = 0
+	}
+	s.next = time.Now().Add(wait + delay)
+	s.mu.Unlock()
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		<-s.sem
+		return ctx.Err()
+	}
+}
+
+func (s *hostSlot) release() {
+	<-s.sem
+}
+
+// enqueue adds the URL to the frontier unless we have seen it, it is filtered out or we hit max_pages
+func (c *crawler) enqueue(j crawlJob) bool {
+	c.mu.Lock()
+	if c.seen[j.url] {
+		c.mu.Unlock()
+		return false
+	}
+	if c.queued >= c.maxPages {
+		c.mu.Unlock()
+		Log().Debugf("Reached max_pages [%d], not queueing URL [%s]", c.maxPages, j.url)
+		return false
+	}
+	c.seen[j.url] = true
+	c.queued++
+	c.pending.Add(1)
+	c.mu.Unlock()
+
+	// sent outside the lock, so a full channel can't hold up the workers - it never is, since it holds max_pages jobs
+	c.jobs <- j
+
+	return true
+}
+
+// normalizeCrawlURL parses an http(s) URL and drops its fragment, so seeds and links to the same page are only crawled once
+func normalizeCrawlURL(link string) (*url.URL, bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, false
+	}
+	u.Fragment = ""
+	return u, true
+}
+
+// follow reports whether a link found on a page should be crawled
+func (c *crawler) follow(link string) (string, bool) {
+	u, ok := normalizeCrawlURL(link)
+	if !ok {
+		return "", false
+	}
+	link = u.String()
+
+	if !domainAllowed(u.Hostname(), c.allowedDomains) {
+		return "", false
+	}
+	for _, re := range c.exclude {
+		if re.MatchString(link) {
+			return "", false
+		}
+	}
+	if len(c.include) == 0 {
+		return link, true
+	}
+	for _, re := range c.include {
+		if re.MatchString(link) {
+			return link, true
+		}
+	}
+
+	return "", false
+}
+
+func (c *crawler) visit(ctx context.Context, j crawlJob) {
+	defer c.pending.Done()
+
+	slot := c.host(j.url)
+	if err := slot.acquire(ctx, c.delay); err != nil {
+		Log().Errorf("Not crawling URL [%s] since the crawl was stopped", j.url)
+		return
+	}
+	defer slot.release()
+
+	Log().Infof("Crawling URL [%s] at depth [%d]", j.url, j.depth)
+
+	dumps := make(chan dumpData, 1)
+	var links []string
+	a := make(chromedp.Tasks, 0)
+	for _, g := range c.page.generators(j.url, dumps, &links) {
+		a = g.Generate(a)
+	}
+
+	rec := crawlRecord{URL: j.url, Depth: j.depth, Parent: j.parent, FetchedAt: time.Now().UTC()}
+	err := run(ctx, a, j.url)
+	if err != nil {
+		Log().Errorf("For URL [%s], received error [%v]", j.url, err)
+		rec.Error = err.Error()
+	} else {
+		data := <-dumps
+		rec.FinalURL = data.URL
+		rec.Data = data.ExtractText
+		rec.Links = len(links)
+	}
+	c.write(rec)
+
+	if err != nil || j.depth >= c.maxDepth {
+		return
+	}
+	for _, l := range links {
+		if next, ok := c.follow(l); ok {
+			c.enqueue(crawlJob{url: next, parent: j.url, depth: j.depth + 1})
+		}
+	}
+}
+
+func (c *crawler) write(rec crawlRecord) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	if err := c.out.Encode(rec); err != nil {
+		Log().Errorf("Failed to write crawl record for URL [%s]: %v", rec.URL, err)
+	}
+}
+
+// crawl visits the pages until there are none left or the context is done
+func (c *crawler) crawl(ctx context.Context, seeds []string) {
+	for i := 0; i < c.workers; i++ {
+		go func() {
+			for j := range c.jobs {
+				c.visit(ctx, j)
+			}
+		}()
+	}
+
+	for _, s := range seeds {
+		// seeds are checked to be absolute URLs up front
+		if u, ok := normalizeCrawlURL(s); ok {
+			c.enqueue(crawlJob{url: u.String()})
+		}
+	}
+
+	c.pending.Wait()
+	close(c.jobs)
+
+	Log().Infof("Crawl finished after visiting [%d] pages", c.queued)
+}
+
+func hostOf(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return strings.ToLower(p.Hostname())
+}
+
+// domainAllowed matches the host against the allowed domains, including their sub-domains
+func domainAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, d := range allowed {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(name string, patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s pattern [%s]: %v", name, p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// CommonCrawlChecks checks the flags for the crawl command
+func CommonCrawlChecks(cmd *cobra.Command) error {
+	viper.BindPFlags(cmd.Flags())
+
+	seeds := viper.GetStringSlice("seeds")
+	if len(seeds) == 0 {
+		return fmt.Errorf("We require a non-empty slice of seeds")
+	}
+	for _, s := range seeds {
+		if u, ok := normalizeCrawlURL(s); !ok || len(u.Host) == 0 {
+			return fmt.Errorf("Seed [%s] must be an absolute http or https URL", s)
+		}
+	}
+	if _, err := compilePatterns("include", viper.GetStringSlice("include_patterns")); err != nil {
+		return err
+	}
+	if _, err := compilePatterns("exclude", viper.GetStringSlice("exclude_patterns")); err != nil {
+		return err
+	}
+	if viper.GetInt("max_depth") < 0 {
+		return fmt.Errorf("max_depth must not be negative")
+	}
+	if viper.GetInt("max_pages") <= 0 {
+		return fmt.Errorf("max_pages must be positive")
+	}
+	if viper.GetInt("crawl_workers") <= 0 || viper.GetInt("host_concurrency") <= 0 {
+		return fmt.Errorf("crawl_workers and host_concurrency must be positive")
+	}
+	if err := CheckScript(viper.GetString("eval")); err != nil {
+		return err
+	}
+
+	return CommonRootChecks(cmd)
+}
+
+// CrawlContent crawls from the seed URLs and writes one NDJSON record per page
+func CrawlContent(cmd *cobra.Command) {
+	viper.BindPFlags(cmd.Flags())
+
+	seeds := viper.GetStringSlice("seeds")
+	allowedDomains := viper.GetStringSlice("allowed_domains")
+	if len(allowedDomains) == 0 {
+		for _, s := range seeds {
+			allowedDomains = append(allowedDomains, hostOf(s))
+		}
+	}
+	include, _ := compilePatterns("include", viper.GetStringSlice("include_patterns"))
+	exclude, _ := compilePatterns("exclude", viper.GetStringSlice("exclude_patterns"))
+
+	var out io.Writer = os.Stdout
+	if path := viper.GetString("output"); len(path) != 0 {
+		f, err := os.Create(path)
+		if err != nil {
+			Log().Errorf("Failed to create output file [%s]: %v", path, err)
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+
+	c := &crawler{
+		maxDepth:        viper.GetInt("max_depth"),
+		maxPages:        viper.GetInt("max_pages"),
+		workers:         viper.GetInt("crawl_workers"),
+		hostConcurrency: viper.GetInt("host_concurrency"),
+		delay:           time.Duration(viper.GetInt("crawl_delay")) * time.Millisecond,
+		allowedDomains:  allowedDomains,
+		include:         include,
+		exclude:         exclude,
+		page: crawlPageOptions{
+			waitSelector:              viper.GetString("wait_selector"),
+			textSelector:              viper.GetString("text_selector"),
+			hrefSelector:              viper.GetString("href_selector"),
+			idSelector:                viper.GetString("id_selector"),
+			evalScript:                viper.GetString("eval"),
+			detectAccessDenied:        viper.GetBool("detect_access_denied"),
+			detectCaptchaBox:          viper.GetBool("detect_captcha_box"),
+			captchaWaitSelector:       viper.GetString("captcha_wait_selector"),
+			captchaClickSelector:      viper.GetString("captcha_click_selector"),
+			captchaIframeWaitSelector: viper.GetString("captcha_iframe_wait_selector"),
+			captchaClickSleep:         viper.GetInt("captcha_click_sleep"),
+			dumpOnError:               viper.GetBool("error_dump"),
+			locationOnError:           viper.GetBool("error_location"),
+			dumpToRedis:               viper.GetBool("redis_dumps"),
+		},
+		seen:  map[string]bool{},
+		hosts: map[string]*hostSlot{},
+		out:   json.NewEncoder(out),
+	}
+	c.jobs = make(chan crawlJob, c.maxPages)
+
+	Log().Infof("Crawling from seeds [%v] within domains [%v]", seeds, allowedDomains)
+	Log().Infof("Using max depth [%d], max pages [%d], [%d] workers, [%d] per host and a delay of [%s] between pages on the same host", c.maxDepth, c.maxPages, c.workers, c.hostConcurrency, c.delay)
+
+	if c.page.dumpToRedis {
+		setupRedis(cmd)
+	}
+
+	// SIGINT or SIGTERM stop the crawl, the pages that are still queued are skipped
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	c.crawl(ctx, seeds)
+	agents().flush()
+}
@@ -0,0 +1,19 @@
+package fetcher
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles are the static files of the web dashboard of serve, which runs on the REST API
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardHandler serves the dashboard under /dashboard/
+func dashboardHandler() http.Handler {
+	// the directory is embedded, so it is always there
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,211 @@
+// The dashboard of go-scraper serve - it polls the REST API, sending the API token if one was given
+(function () {
+  "use strict";
+
+  var refreshInterval = 5000;
+  var tokenKey = "go-scraper-token";
+  var selected = null;
+
+  function token() {
+    return sessionStorage.getItem(tokenKey) || "";
+  }
+
+  function askToken() {
+    var t = prompt("API token of serve (leave empty if it has none)", token());
+    if (t !== null) {
+      sessionStorage.setItem(tokenKey, t);
+      refresh();
+    }
+  }
+
+  function api(method, path) {
+    var headers = {};
+    if (token()) {
+      headers.Authorization = "Bearer " + token();
+    }
+    return fetch(path, { method: method, headers: headers }).then(function (resp) {
+      if (resp.status === 401) {
+        throw new Error("The API requires a valid token - set it with the API token button");
+      }
+      if (!resp.ok) {
+        return resp.json().then(function (body) {
+          throw new Error(body.error || resp.statusText);
+        }, function () {
+          throw new Error(resp.statusText);
+        });
+      }
+      return resp;
+    });
+  }
+
+  function showError(err) {
+    var el = document.getElementById("error");
+    el.textContent = err ? err.message : "";
+    el.hidden = !err;
+  }
+
+  function formatTime(value) {
+    return value ? new Date(value).toLocaleString() : "";
+  }
+
+  function cell(row, text, className) {
+    var td = document.createElement("td");
+    td.textContent = text === undefined ? "" : text;
+    if (className) {
+      td.className = className;
+    }
+    row.appendChild(td);
+    return td;
+  }
+
+  function button(parent, label, onClick) {
+    var b = document.createElement("button");
+    b.type = "button";
+    b.textContent = label;
+    b.addEventListener("click", function (ev) {
+      ev.stopPropagation();
+      onClick();
+    });
+    parent.appendChild(b);
+  }
+
+  // the page of the last run is opened as a blob, since a link can't carry the token - the dump is shown as text so its scripts don't run here
+  function openPage(id, kind) {
+    var win = window.open("", "_blank");
+    api("GET", "/targets/" + encodeURIComponent(id) + "/" + kind).then(function (resp) {
+      return resp.blob();
+    }).then(function (blob) {
+      if (kind === "dump") {
+        blob = new Blob([blob], { type: "text/plain" });
+      }
+      win.location = URL.createObjectURL(blob);
+    }).catch(function (err) {
+      win.close();
+      showError(err);
+    });
+  }
+
+  function act(id, action) {
+    api("POST", "/targets/" + encodeURIComponent(id) + "/" + action).then(refresh).catch(showError);
+  }
+
+  function renderTargets(targets) {
+    var body = document.getElementById("targets");
+    body.textContent = "";
+    document.getElementById("empty").hidden = targets.length !== 0;
+
+    targets.forEach(function (t) {
+      var st = t.status;
+      var row = document.createElement("tr");
+      if (t.id === selected) {
+        row.className = "selected";
+      }
+
+      var target = cell(row, t.url, "url");
+      target.title = "Target " + t.id + " - click for its history";
+      target.addEventListener("click", function () {
+        selected = t.id;
+        refresh();
+      });
+
+      var state = document.createElement("span");
+      state.className = "state " + st.state;
+      state.textContent = st.state;
+      var stateCell = cell(row, "");
+      stateCell.appendChild(state);
+      if (st.http_status) {
+        stateCell.appendChild(document.createTextNode(" " + st.http_status));
+      }
+
+      if (st.state === "error") {
+        cell(row, st.error, "error");
+      } else {
+        cell(row, st.value, "value");
+      }
+      cell(row, formatTime(st.last_run) + (st.duration ? " (" + st.duration + ")" : ""));
+      cell(row, formatTime(st.next_run));
+      cell(row, st.failures);
+      cell(row, st.agent);
+      cell(row, st.proxy);
+
+      var page = cell(row, "");
+      if (st.state === "error") {
+        button(page, "Dump", function () { openPage(t.id, "dump"); });
+        button(page, "Screenshot", function () { openPage(t.id, "screenshot"); });
+      }
+
+      var actions = cell(row, "");
+      if (t.paused) {
+        button(actions, "Resume", function () { act(t.id, "resume"); });
+      } else {
+        button(actions, "Run now", function () { act(t.id, "run"); });
+        button(actions, "Pause", function () { act(t.id, "pause"); });
+      }
+
+      body.appendChild(row);
+    });
+  }
+
+  function renderHistory(target, history) {
+    var section = document.getElementById("history");
+    section.hidden = false;
+    document.getElementById("history-target").textContent = target.url;
+
+    // a bar for each run, oldest on the left
+    var chart = document.getElementById("history-chart");
+    chart.textContent = "";
+    var width = 600 / Math.max(history.length, 1);
+    history.forEach(function (h, i) {
+      var bar = document.createElementNS("http://www.w3.org/2000/svg", "rect");
+      bar.setAttribute("x", i * width);
+      bar.setAttribute("width", Math.max(width - 1, 1));
+      bar.setAttribute("height", 24);
+      bar.setAttribute("fill", h.state === "ok" ? "#2a8a3e" : "#c0392b");
+      var title = document.createElementNS("http://www.w3.org/2000/svg", "title");
+      title.textContent = formatTime(h.at) + ": " + (h.state === "ok" ? h.value : h.error);
+      bar.appendChild(title);
+      chart.appendChild(bar);
+    });
+
+    var body = document.getElementById("history-runs");
+    body.textContent = "";
+    history.slice().reverse().forEach(function (h) {
+      var row = document.createElement("tr");
+      cell(row, formatTime(h.at));
+      cell(row, h.state);
+      if (h.state === "ok") {
+        cell(row, h.value, "value");
+      } else {
+        cell(row, h.error, "error");
+      }
+      body.appendChild(row);
+    });
+  }
+
+  function refresh() {
+    api("GET", "/targets").then(function (resp) {
+      return resp.json();
+    }).then(function (targets) {
+      renderTargets(targets);
+      document.getElementById("updated").textContent = "Updated " + new Date().toLocaleTimeString();
+
+      var target = targets.find(function (t) { return t.id === selected; });
+      if (!target) {
+        selected = null;
+        document.getElementById("history").hidden = true;
+        return;
+      }
+      return api("GET", "/targets/" + encodeURIComponent(target.id) + "/history").then(function (resp) {
+        return resp.json();
+      }).then(function (history) {
+        renderHistory(target, history);
+      });
+    }).then(function () {
+      showError(null);
+    }).catch(showError);
+  }
+
+  document.getElementById("token").addEventListener("click", askToken);
+  refresh();
+  setInterval(refresh, refreshInterval);
+})();
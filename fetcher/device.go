@@ -0,0 +1,186 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/device"
+	"github.com/spf13/viper"
+)
+
+// DefaultMaxTouchPoints touch points reported by a device with touch emulation
+const DefaultMaxTouchPoints = 5
+
+var (
+	gDevices       deviceSet
+	gDevicesLoaded bool
+	gDevicesMu     sync.Mutex
+)
+
+// deviceSet is the device definitions by lower case name, or why they couldn't be loaded
+type deviceSet struct {
+	all map[string]device.Info
+	err error
+}
+
+// customDevice is a device definition from the devices_file
+type customDevice struct {
+	Name      string  `json:"name"`
+	UserAgent string  `json:"user_agent"`
+	Width     int64   `json:"width"`
+	Height    int64   `json:"height"`
+	Scale     float64 `json:"scale"`
+	Landscape bool    `json:"landscape"`
+	Mobile    bool    `json:"mobile"`
+	Touch     bool    `json:"touch"`
+}
+
+// deviceActions emulates the device for the URL before we navigate to it
+// the device is looked up by name on each run, so a reload picks up changes to its definition in the devices_file
+type deviceActions struct {
+	url    string
+	device string
+}
+
+// deviceDefinitions returns the chromedp device presets together with the ones from the devices_file, by lower case name
+func deviceDefinitions() (map[string]device.Info, error) {
+	gDevicesMu.Lock()
+	defer gDevicesMu.Unlock()
+
+	if !gDevicesLoaded {
+		gDevices = loadDevices()
+		gDevicesLoaded = true
+	}
+	return gDevices.all, gDevices.err
+}
+
+// reloadDevices reads the devices_file again and returns the devices it replaced, so a config that is rejected can put them back
+func reloadDevices() deviceSet {
+	gDevicesMu.Lock()
+	defer gDevicesMu.Unlock()
+
+	previous := gDevices
+	gDevices = loadDevices()
+	gDevicesLoaded = true
+	return previous
+}
+
+func restoreDevices(previous deviceSet) {
+	gDevicesMu.Lock()
+	defer gDevicesMu.Unlock()
+
+	gDevices = previous
+}
+
+func loadDevices() deviceSet {
+	res := deviceSet{all: map[string]device.Info{}}
+	// the presets are only exposed as constants, MotoG4landscape being the last of them
+	for d := device.Reset + 1; d <= device.MotoG4landscape; d++ {
+		info := d.Device()
+		res.all[strings.ToLower(info.Name)] = info
+	}
+
+	path := viper.GetString("devices_file")
+	if len(path) == 0 {
+		return res
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		res.err = fmt.Errorf("Failed to read devices_file [%s]: %v", path, err)
+		return res
+	}
+	var custom []customDevice
+	if err = json.Unmarshal(data, &custom); err != nil {
+		res.err = fmt.Errorf("Failed to parse devices_file [%s], it must be a JSON array of devices: %v", path, err)
+		return res
+	}
+	for _, c := range custom {
+		if len(c.Name) == 0 || len(c.UserAgent) == 0 || c.Width <= 0 || c.Height <= 0 {
+			res.err = fmt.Errorf("Invalid device [%s] in devices_file [%s] - every device requires a name, user_agent and a positive width and height", c.Name, path)
+			return res
+		}
+		if c.Scale == 0 {
+			c.Scale = 1
+		}
+		res.all[strings.ToLower(c.Name)] = device.Info{
+			Name:      c.Name,
+			UserAgent: c.UserAgent,
+			Width:     c.Width,
+			Height:    c.Height,
+			Scale:     c.Scale,
+			Landscape: c.Landscape,
+			Mobile:    c.Mobile,
+			Touch:     c.Touch,
+		}
+	}
+
+	return res
+}
+
+// getDevice returns the named device - nil means no device is emulated
+func getDevice(name string) (*device.Info, error) {
+	if len(name) == 0 {
+		return nil, nil
+	}
+	all, err := deviceDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	info, ok := all[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown device [%s] - must be one of the chromedp device names (e.g. iPhone 14, Pixel 5 or iPad Pro) or defined in the devices_file", name)
+	}
+
+	return &info, nil
+}
+
+// checkDevices validates the device names that were passed in
+func checkDevices(names ...string) error {
+	for _, n := range names {
+		if _, err := getDevice(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// targetDevice returns the name of the device for the URL at index i, where an empty override falls back to the root level device
+func targetDevice(overrides []string, i int) string {
+	if len(overrides) > i && len(overrides[i]) != 0 {
+		return overrides[i]
+	}
+	return viper.GetString("device")
+}
+
+func (d deviceActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
+	if len(d.device) == 0 {
+		return actions
+	}
+
+	actions = append(actions,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			// names are validated in the common checks, and a reload only swaps in devices that pass them
+			info, err := getDevice(d.device)
+			if err != nil {
+				return err
+			}
+			// the device user-agent wins over the agent or profile picked for the run, since a mobile site expects one
+			Log().Infof("Emulating device [%s] for URL [%s] with user-agent [%s]", info.Name, d.url, info.UserAgent)
+			if err := chromedp.Emulate(info).Do(ctx); err != nil {
+				return err
+			}
+			if info.Touch {
+				return emulation.SetTouchEmulationEnabled(true).WithMaxTouchPoints(DefaultMaxTouchPoints).Do(ctx)
+			}
+			return nil
+		}))
+
+	return actions
+}
@@ -0,0 +1,374 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultDiffMaxSize default max size (bytes) of a diff sent in a notification
+	DefaultDiffMaxSize = 4000
+
+	// DefaultDiffContext default number of unchanged lines shown around each change
+	DefaultDiffContext = 3
+
+	diffStateKeyPrefix = "diff-snapshots-"
+
+	// diffMaxCells bounds the work done to diff two snapshots, past it we report the whole changed part of the region as replaced
+	diffMaxCells = 1000000
+)
+
+var (
+	// diffIgnorePresets named noise filters for diff checks
+	diffIgnorePresets = map[string][]string{
+		"timestamps": {
+			`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?`,
+			`\d{1,2}:\d{2}(:\d{2})?\s*([AaPp][Mm])?`,
+			`\d{1,2}/\d{1,2}/\d{2,4}`,
+			`(?i)\b\d+\s+(second|minute|hour|day|week|month|year)s?\s+ago\b`,
+		},
+		// only numbers followed by what they count, so prices and other values still show up in a diff
+		"counters": {
+			`(?i)\b\d[\d,.]*[km]?\s+(views?|likes?|comments?|replies|reviews?|ratings?|votes?|followers?|subscribers?|shares?|visitors?|watching|online)\b`,
+		},
+	}
+
+	htmlTagBoundary = regexp.MustCompile(`>\s*<`)
+	whitespaceRun   = regexp.MustCompile(`[ \t]+`)
+)
+
+// diffSnapshot is the content of the watched region at one point in time
+type diffSnapshot struct {
+	Content    string    `json:"content"`
+	Normalized string    `json:"normalized"`
+	At         time.Time `json:"at"`
+}
+
+// diffSnapshots is what we keep in the state store for a diff check
+type diffSnapshots struct {
+	Current  *diffSnapshot `json:"current"`
+	Previous *diffSnapshot `json:"previous,omitempty"`
+}
+
+// regionDiffCheck notifies with a unified diff of a page region whenever it changed since the previous run
+type regionDiffCheck struct {
+	url          string
+	selector     string
+	selectorType string // text or html
+	ignore       []*regexp.Regexp
+	maxSize      int
+	context      int
+}
+
+// newRegionDiffCheck builds a diff check using the diff_* flags
+func newRegionDiffCheck(u string, selector string, selectorType string) (regionDiffCheck, error) {
+	ignore, err := diffIgnorePatterns()
+	if err != nil {
+		return regionDiffCheck{}, err
+	}
+
+	return regionDiffCheck{
+		url:          u,
+		selector:     selector,
+		selectorType: selectorType,
+		ignore:       ignore,
+		maxSize:      viper.GetInt("diff_max_size"),
+		context:      viper.GetInt("diff_context"),
+	}, nil
+}
+
+// diffIgnorePatterns compiles the preset and user supplied noise filters
+func diffIgnorePatterns() ([]*regexp.Regexp, error) {
+	var patterns []string
+	for _, p := range viper.GetStringSlice("diff_ignore") {
+		preset, ok := diffIgnorePresets[p]
+		if !ok {
+			return nil, fmt.Errorf("Unknown diff_ignore preset [%s] - must be one of [timestamps] or [counters]", p)
+		}
+		patterns = append(patterns, preset...)
+	}
+	patterns = append(patterns, viper.GetStringSlice("diff_ignore_patterns")...)
+
+	return compilePatterns("diff_ignore", patterns)
+}
+
+func (r regionDiffCheck) detect(ctx context.Context) (*changeNotice, error) {
+	var content string
+	var err error
+	if r.selectorType == "html" {
+		content, err = extractHTML(ctx, r.selector)
+	} else {
+		content, err = extractData(ctx, r.selector, "text")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current := &diffSnapshot{Content: content, Normalized: joinKeys(r.lines(content)), At: time.Now().UTC()}
+
+	key := checkStateKey(diffStateKeyPrefix, r.url, r.selector, r.selectorType)
+	raw, found, err := state().get(key)
+	if err != nil {
+		Log().Errorf("Failed to load snapshots for URL [%s]: %v", r.url, err)
+		return nil, err
+	}
+	var snaps diffSnapshots
+	if found {
+		if err = json.Unmarshal([]byte(raw), &snaps); err != nil || snaps.Current == nil {
+			Log().Errorf("Snapshots for URL [%s] are corrupt, starting over: %v", r.url, err)
+			found = false
+		}
+	}
+
+	if found && snaps.Current.Normalized == current.Normalized {
+		Log().Infof("Region [%s] for URL [%s] has not changed since [%s]", r.selector, r.url, snaps.Current.At.Format(time.RFC3339))
+		return nil, nil
+	}
+
+	previous := snaps.Current
+	snaps = diffSnapshots{Current: current, Previous: previous}
+	data, err := json.Marshal(snaps)
+	if err != nil {
+		return nil, err
+	}
+	if err = state().set(key, string(data)); err != nil {
+		Log().Errorf("Failed to save snapshots for URL [%s]: %v", r.url, err)
+		return nil, err
+	}
+
+	if !found {
+		Log().Infof("First run for diff URL [%s], recorded region [%s] as the baseline", r.url, r.selector)
+		return nil, nil
+	}
+
+	d := unifiedDiff(r.lines(previous.Content), r.lines(current.Content), "previous "+previous.At.Format(time.RFC3339), "current "+current.At.Format(time.RFC3339), r.context)
+	Log().Infof("Region [%s] for URL [%s] changed since the last check", r.selector, r.url)
+
+	return &changeNotice{text: trimDiff(d, r.maxSize)}, nil
+}
+
+// diffLine is a line of a region - the text is what a diff shows, the key is the text without the noise we don't want to alert on, which is what is compared
+type diffLine struct {
+	text string
+	key  string
+}
+
+// lines splits the content of the region into the lines that are diffed, leaving out blank ones
+func (r regionDiffCheck) lines(content string) []diffLine {
+	if r.selectorType == "html" {
+		content = htmlTagBoundary.ReplaceAllString(content, ">\n<")
+	}
+
+	var lines []diffLine
+	for _, l := range strings.Split(content, "\n") {
+		l = strings.TrimSpace(whitespaceRun.ReplaceAllString(l, " "))
+		if len(l) == 0 {
+			continue
+		}
+		key := l
+		for _, re := range r.ignore {
+			key = re.ReplaceAllString(key, "…")
+		}
+		lines = append(lines, diffLine{text: l, key: key})
+	}
+
+	return lines
+}
+
+// joinKeys joins the keys of the lines, for comparing a region with what it was before
+func joinKeys(lines []diffLine) string {
+	keys := make([]string, len(lines))
+	for i, l := range lines {
+		keys[i] = l.key
+	}
+	return strings.Join(keys, "\n")
+}
+
+func extractHTML(ctx context.Context, selector string) (string, error) {
+	var res string
+	err := chromedp.OuterHTML(selector, &res).Do(ctx)
+	if err != nil {
+		Log().Errorf("%v", err)
+		return "", err
+	}
+	return res, nil
+}
+
+// trimDiff cuts the diff down to maxSize bytes on a line boundary
+func trimDiff(d string, maxSize int) string {
+	if maxSize <= 0 || len(d) <= maxSize {
+		return d
+	}
+
+	cut := strings.LastIndex(d[:maxSize], "\n")
+	if cut <= 0 {
+		cut = maxSize
+	}
+	return d[:cut] + fmt.Sprintf("\n... diff trimmed, %d more bytes\n", len(d)-cut)
+}
+
+// diffOp is a single line of an edit script
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff returns a unified diff of the lines of a and b, comparing them by their keys
+func unifiedDiff(a []diffLine, b []diffLine, aName string, bName string, context int) string {
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+
+	// group the edit script into hunks with the requested lines of context
+	i := 0
+	for i < len(ops) {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			// look ahead to see if another change is close enough to join this hunk
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*context {
+				end = next
+				continue
+			}
+			end += context
+			if end > len(ops) {
+				end = len(ops)
+			}
+			break
+		}
+
+		aStart, bStart := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				aStart++
+			}
+			if op.kind != '-' {
+				bStart++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+
+		// an empty range is numbered by the line it follows
+		if aLen == 0 {
+			aStart--
+		}
+		if bLen == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+
+	return out.String()
+}
+
+// diffLines computes an edit script between two sets of lines using the longest common subsequence of their keys
+// unchanged lines are shown as they are now
+func diffLines(a []diffLine, b []diffLine) []diffOp {
+	// strip the common prefix and suffix, which is most of the region for typical changes
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre].key == b[pre].key {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf].key == b[len(b)-1-suf].key {
+		suf++
+	}
+
+	var ops []diffOp
+	for _, l := range b[:pre] {
+		ops = append(ops, diffOp{' ', l.text})
+	}
+
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma)*len(mb) > diffMaxCells {
+		for _, l := range ma {
+			ops = append(ops, diffOp{'-', l.text})
+		}
+		for _, l := range mb {
+			ops = append(ops, diffOp{'+', l.text})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of ma[i:] and mb[j:]
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i].key == mb[j].key {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(ma) && j < len(mb) {
+			switch {
+			case ma[i].key == mb[j].key:
+				ops = append(ops, diffOp{' ', mb[j].text})
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				ops = append(ops, diffOp{'-', ma[i].text})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', mb[j].text})
+				j++
+			}
+		}
+		for ; i < len(ma); i++ {
+			ops = append(ops, diffOp{'-', ma[i].text})
+		}
+		for ; j < len(mb); j++ {
+			ops = append(ops, diffOp{'+', mb[j].text})
+		}
+	}
+
+	for _, l := range b[len(b)-suf:] {
+		ops = append(ops, diffOp{' ', l.text})
+	}
+
+	return ops
+}
@@ -0,0 +1,332 @@
+package fetcher
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// plainLines returns lines that are compared by their text
+func plainLines(texts ...string) []diffLine {
+	var res []diffLine
+	for _, t := range texts {
+		res = append(res, diffLine{text: t, key: t})
+	}
+	return res
+}
+
+// formatOps prints an edit script the way a diff shows it, one line per op
+func formatOps(ops []diffOp) string {
+	var b strings.Builder
+	for _, op := range ops {
+		b.WriteByte(op.kind)
+		b.WriteString(op.line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []diffLine
+		b    []diffLine
+		want string
+	}{
+		{
+			name: "identical",
+			a:    plainLines("x", "y"),
+			b:    plainLines("x", "y"),
+			want: " x\n y\n",
+		},
+		{
+			name: "both empty",
+		},
+		{
+			name: "old side empty",
+			b:    plainLines("x", "y"),
+			want: "+x\n+y\n",
+		},
+		{
+			name: "new side empty",
+			a:    plainLines("x", "y"),
+			want: "-x\n-y\n",
+		},
+		{
+			name: "insert at the start",
+			a:    plainLines("x", "y"),
+			b:    plainLines("n", "x", "y"),
+			want: "+n\n x\n y\n",
+		},
+		{
+			name: "delete at the end",
+			a:    plainLines("x", "y", "z"),
+			b:    plainLines("x", "y"),
+			want: " x\n y\n-z\n",
+		},
+		{
+			name: "replace in the middle",
+			a:    plainLines("x", "old", "common", "y"),
+			b:    plainLines("x", "new", "common", "y"),
+			want: " x\n-old\n+new\n common\n y\n",
+		},
+		{
+			name: "lines with the same key are unchanged and shown as they are now",
+			a:    []diffLine{{text: "seen 1 hour ago", key: "seen …"}, {text: "x", key: "x"}},
+			b:    []diffLine{{text: "seen 2 hours ago", key: "seen …"}, {text: "y", key: "y"}},
+			want: " seen 2 hours ago\n-x\n+y\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatOps(diffLines(tt.a, tt.b)); got != tt.want {
+				t.Errorf("diffLines() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesMaxCells(t *testing.T) {
+	// big enough that the changed part is past diffMaxCells, with a line in the middle that the LCS would keep
+	var a, b []string
+	for i := 0; i < 1000; i++ {
+		if i == 500 {
+			a = append(a, "same")
+			b = append(b, "same")
+		}
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+	if len(a)*len(b) <= diffMaxCells {
+		t.Fatalf("Test needs more than [%d] cells, has [%d]", diffMaxCells, len(a)*len(b))
+	}
+	a = append(append([]string{"head"}, a...), "tail")
+	b = append(append([]string{"head"}, b...), "tail")
+
+	ops := diffLines(plainLines(a...), plainLines(b...))
+	if len(ops) != 2+len(a)-2+len(b)-2 {
+		t.Fatalf("Got [%d] ops, want [%d]", len(ops), 2+len(a)-2+len(b)-2)
+	}
+	if ops[0] != (diffOp{' ', "head"}) || ops[len(ops)-1] != (diffOp{' ', "tail"}) {
+		t.Errorf("Common prefix and suffix are %+v and %+v, want them unchanged", ops[0], ops[len(ops)-1])
+	}
+	// the whole changed part is removed, then added again
+	removed := len(a) - 2
+	for i, op := range ops[1 : len(ops)-1] {
+		var want diffOp
+		if i < removed {
+			want = diffOp{'-', a[1+i]}
+		} else {
+			want = diffOp{'+', b[1+i-removed]}
+		}
+		if op != want {
+			t.Fatalf("Op [%d] = %+v, want %+v", i, op, want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       []diffLine
+		b       []diffLine
+		context int
+		want    string
+	}{
+		{
+			name:    "identical",
+			a:       plainLines("x", "y"),
+			b:       plainLines("x", "y"),
+			context: 1,
+			want:    "",
+		},
+		{
+			name:    "insert at the start",
+			a:       plainLines("x", "y"),
+			b:       plainLines("n", "x", "y"),
+			context: 1,
+			want:    "@@ -1,1 +1,2 @@\n+n\n x\n",
+		},
+		{
+			name:    "insert at the end",
+			a:       plainLines("x", "y"),
+			b:       plainLines("x", "y", "n"),
+			context: 1,
+			want:    "@@ -2,1 +2,2 @@\n y\n+n\n",
+		},
+		{
+			name:    "delete at the start",
+			a:       plainLines("x", "y", "z"),
+			b:       plainLines("y", "z"),
+			context: 1,
+			want:    "@@ -1,2 +1,1 @@\n-x\n y\n",
+		},
+		{
+			name:    "delete at the end",
+			a:       plainLines("x", "y", "z"),
+			b:       plainLines("x", "y"),
+			context: 1,
+			want:    "@@ -2,2 +2,1 @@\n y\n-z\n",
+		},
+		{
+			name:    "old side empty",
+			b:       plainLines("x", "y"),
+			context: 3,
+			want:    "@@ -0,0 +1,2 @@\n+x\n+y\n",
+		},
+		{
+			name:    "new side empty",
+			a:       plainLines("x", "y"),
+			context: 3,
+			want:    "@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			name:    "changes twice the context apart share a hunk",
+			a:       plainLines("x", "c1", "c2", "y"),
+			b:       plainLines("X", "c1", "c2", "Y"),
+			context: 1,
+			want:    "@@ -1,4 +1,4 @@\n-x\n+X\n c1\n c2\n-y\n+Y\n",
+		},
+		{
+			name:    "changes further apart get their own hunks",
+			a:       plainLines("x", "c1", "c2", "c3", "y"),
+			b:       plainLines("X", "c1", "c2", "c3", "Y"),
+			context: 1,
+			want:    "@@ -1,2 +1,2 @@\n-x\n+X\n c1\n@@ -4,2 +4,2 @@\n c3\n-y\n+Y\n",
+		},
+		{
+			name:    "context is cut at the edges",
+			a:       plainLines("c1", "x", "c2"),
+			b:       plainLines("c1", "X", "c2"),
+			context: 3,
+			want:    "@@ -1,3 +1,3 @@\n c1\n-x\n+X\n c2\n",
+		},
+		{
+			name:    "no context",
+			a:       plainLines("c1", "x", "c2"),
+			b:       plainLines("c1", "c2"),
+			context: 0,
+			want:    "@@ -2,1 +1,0 @@\n-x\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := "--- previous\n+++ current\n" + tt.want
+			if got := unifiedDiff(tt.a, tt.b, "previous", "current", tt.context); got != want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDiffIgnorePresets(t *testing.T) {
+	tests := []struct {
+		name   string
+		preset string
+		text   string
+		key    string
+	}{
+		{
+			name:   "ISO timestamp",
+			preset: "timestamps",
+			text:   "Updated 2024-05-01T10:00:00.123+02:00",
+			key:    "Updated …",
+		},
+		{
+			name:   "date and time",
+			preset: "timestamps",
+			text:   "Updated 2024-05-01 10:00",
+			key:    "Updated …",
+		},
+		{
+			name:   "clock time",
+			preset: "timestamps",
+			text:   "Last seen at 9:45 PM",
+			key:    "Last seen at …",
+		},
+		{
+			name:   "short date",
+			preset: "timestamps",
+			text:   "Posted on 5/1/24",
+			key:    "Posted on …",
+		},
+		{
+			name:   "relative time",
+			preset: "timestamps",
+			text:   "Posted 3 hours ago",
+			key:    "Posted …",
+		},
+		{
+			name:   "timestamps leave prices alone",
+			preset: "timestamps",
+			text:   "Price $1,299.99",
+			key:    "Price $1,299.99",
+		},
+		{
+			name:   "counter",
+			preset: "counters",
+			text:   "1,234 views and 56 comments",
+			key:    "… and …",
+		},
+		{
+			name:   "abbreviated counter",
+			preset: "counters",
+			text:   "12k likes",
+			key:    "…",
+		},
+		{
+			name:   "counters leave prices alone",
+			preset: "counters",
+			text:   "Price $1,299.99 for 2 items",
+			key:    "Price $1,299.99 for 2 items",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ignore, err := compilePatterns("diff_ignore", diffIgnorePresets[tt.preset])
+			if err != nil {
+				t.Fatalf("Preset [%s] doesn't compile: %v", tt.preset, err)
+			}
+			r := regionDiffCheck{selectorType: "text", ignore: ignore}
+			want := []diffLine{{text: tt.text, key: tt.key}}
+			if got := r.lines(tt.text); !reflect.DeepEqual(got, want) {
+				t.Errorf("lines(%s) = %+v, want %+v", tt.text, got, want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesOfRegion(t *testing.T) {
+	tests := []struct {
+		name         string
+		selectorType string
+		content      string
+		want         []string
+	}{
+		{
+			name:         "text skips blank lines and collapses whitespace",
+			selectorType: "text",
+			content:      "  first\t\tline \n\n   \nsecond   line\n",
+			want:         []string{"first line", "second line"},
+		},
+		{
+			name:         "html is split between tags",
+			selectorType: "html",
+			content:      "<ul> <li>one</li><li>two</li>\n</ul>",
+			want:         []string{"<ul>", "<li>one</li>", "<li>two</li>", "</ul>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := regionDiffCheck{selectorType: tt.selectorType}
+			if got := r.lines(tt.content); !reflect.DeepEqual(got, plainLines(tt.want...)) {
+				t.Errorf("lines() = %+v, want %+v", got, plainLines(tt.want...))
+			}
+		})
+	}
+}
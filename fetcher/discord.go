@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"strings"
 	"time"
@@ -17,8 +18,9 @@ import (
 
 // discordData holds the URL and text for the Discord notification.
 type discordData struct {
-	URL  string
-	Text string
+	URL   string
+	Text  string
+	Image []byte // PNG uploaded with the message, only set by checks that produce an image
 }
 
 // discordActions is an action generator that checks page content and sends a notification
@@ -28,6 +30,7 @@ type discordActions struct {
 	checkSelector  string
 	checkType      string
 	expectedText   string
+	sources        checkSources
 	url            string
 }
 
@@ -36,24 +39,37 @@ type discordActions struct {
 func (d discordActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 	actions = append(actions,
 		chromedp.ActionFunc(func(ctx context.Context) error {
+			if d.sources.change != nil {
+				notice, err := d.sources.change.detect(ctx)
+				if err != nil {
+					return err
+				}
+				if notice != nil {
+					Log().Infof("For URL [%s] found changes since the last check, sending Discord notification.", d.url)
+					info := runInfoFromContext(ctx)
+					info.value, info.notified = notice.text, true
+					enqueue(d.postActionData, discordData{URL: d.url, Text: notice.text, Image: notice.image})
+				}
+				return nil
+			}
 			if len(d.checkSelector) != 0 && len(d.expectedText) != 0 {
-				res, err := extractData(ctx, d.checkSelector, d.checkType)
+				res, err := extractCheckData(ctx, d.checkSelector, d.checkType, d.sources)
 				if err != nil {
 					return err
 				}
+				info := runInfoFromContext(ctx)
+				info.value = res
 				if !strings.Contains(res, d.expectedText) {
 					Log().Infof("For URL [%s] found update: [%s] (expected: [%s]), sending Discord notification.", d.url, res, d.expectedText)
-					go func() {
-						d.postActionData <- discordData{URL: d.url, Text: res}
-					}()
+					info.notified = true
+					enqueue(d.postActionData, discordData{URL: d.url, Text: res})
 				} else {
 					Log().Infof("For URL [%s] the result matches expected text.", d.url)
 				}
 			} else {
 				Log().Infof("Condition met for URL [%s]; sending Discord notification.", d.url)
-				go func() {
-					d.postActionData <- discordData{URL: d.url}
-				}()
+				runInfoFromContext(ctx).notified = true
+				enqueue(d.postActionData, discordData{URL: d.url})
 			}
 			return nil
 		}),
@@ -87,7 +103,24 @@ func (d discordWatchFunc) sendDiscordNotification(data discordData) {
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
 	}
-	resp, err := client.Post(d.webhookURL, "application/json", bytes.NewBuffer(jsonData))
+	body := bytes.NewBuffer(jsonData)
+	contentType := "application/json"
+	if len(data.Image) != 0 {
+		// upload the image as a file attachment, with the message as payload_json
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		w.WriteField("payload_json", string(jsonData))
+		f, err := w.CreateFormFile("files[0]", "diff.png")
+		if err != nil {
+			Log().Errorf("Error attaching image to Discord payload: %v", err)
+			return
+		}
+		f.Write(data.Image)
+		w.Close()
+		body = &b
+		contentType = w.FormDataContentType()
+	}
+	resp, err := client.Post(d.webhookURL, contentType, body)
 	if err != nil {
 		Log().Errorf("Error sending Discord notification: %v", err)
 		return
@@ -101,60 +134,52 @@ func (d discordWatchFunc) sendDiscordNotification(data discordData) {
 }
 
 // DiscordContent sets up and starts the watch executor that monitors URLs and sends
-// Discord notifications via the specified webhook and username.
-func DiscordContent(cmd *cobra.Command) {
+// Discord notifications via the specified webhook and username, and returns the exit status.
+func DiscordContent(cmd *cobra.Command) int {
 	viper.BindPFlags(cmd.Flags())
 
 	// Get required configuration values.
 	webhook := viper.GetString("webhook")
 	if webhook == "" {
 		Log().Errorf("Discord webhook URL must be provided via --webhook")
-		return
+		return 1
 	}
 	username := viper.GetString("discord_username")
 	if username == "" {
 		username = "Go-Scraper Discord Alert"
 	}
 
-	// Retrieve common flags.
-	urls := viper.GetStringSlice("urls")
-	waitSelectors := viper.GetStringSlice("wait_selectors")
-	checkSelectors := viper.GetStringSlice("check_selectors")
-	checkTypes := viper.GetStringSlice("check_types")
-	expectedTexts := viper.GetStringSlice("expected_texts")
-
-	Log().Infof("Watching URLs: %v", urls)
-	Log().Infof("Waiting on selectors: %v", waitSelectors)
-	Log().Infof("Using check_selectors: %v", checkSelectors)
-	Log().Infof("Using check_types: %v", checkTypes)
-	Log().Infof("Using expected_texts: %v", expectedTexts)
-
-	// Retrieve detection flags.
-	detectAccessDeniedOn := viper.GetBool("detect_access_denied")
-	detectCaptchaBoxOn := viper.GetBool("detect_captcha_box")
-	captchaWaitSelector := viper.GetString("captcha_wait_selector")
-	captchaClickSelector := viper.GetString("captcha_click_selector")
-	captchaIframeWaitSelector := viper.GetString("captcha_iframe_wait_selector")
-	captchaClickSleep := viper.GetInt("captcha_click_sleep")
-
-	// Optionally detect notify-paths.
-	detectNotifyPath := viper.GetBool("detect_notify_path")
-	notifyPaths := viper.GetStringSlice("notify_paths")
-	var notifyPath string
-	if detectNotifyPath && len(notifyPaths) > 0 {
-		notifyPath = notifyPaths[0] // Adapt as needed per URL.
-	}
-
-	// Error dump settings.
-	errorDump := viper.GetBool("error_dump")
-	errorLocation := viper.GetBool("error_location")
+	// Start the Redis worker, only here so a reload can't turn the dumps on or off.
 	redisDumpOn := viper.GetBool("redis_dumps")
 	if redisDumpOn {
 		setupRedis(cmd)
 	}
 
 	// Set up the Discord notification channel and notifier.
-	discordMetaData := make(chan discordData)
+	discordMetaData := startDiscordWorker(webhook, username)
+
+	// Build the targets, again on every reload.
+	build := func() ([][]actionGenerator, []string, error) {
+		return watchTargets(nil, discordMetaData, redisDumpOn)
+	}
+	actionGens, urls, err := build()
+	if err != nil {
+		Log().Errorf("%v", err)
+		return 1
+	}
+
+	// Initialize and execute the watch executor.
+	e := executors["watch"].(*watchExecutor)
+	e.Init(actionGens, urls)
+	e.reloadWith(func() error { return CommonWatchChecks(cmd) }, build)
+	Log().Infof("Starting Discord watch executor for URLs: %v", urls)
+	e.Execute() // Blocks until the watch is stopped.
+	return e.code
+}
+
+// startDiscordWorker starts sending the notifications put on the channel it returns to the webhook.
+func startDiscordWorker(webhook string, username string) chan discordData {
+	discordMetaData := make(chan discordData, workerQueueSize)
 	discordNotifier := discordWatchFunc{
 		webhookURL: webhook,
 		username:   username,
@@ -163,58 +188,9 @@ func DiscordContent(cmd *cobra.Command) {
 		for {
 			data := <-discordMetaData
 			discordNotifier.sendDiscordNotification(data)
+			handled()
 		}
 	}()
 
-	// Build action generators for each URL.
-	actionGens := make([][]actionGenerator, len(urls))
-	for i, u := range urls {
-		actionGens[i] = make([]actionGenerator, 0)
-
-		// Navigation action.
-		actionGens[i] = append(actionGens[i], navigateActions{url: u})
-
-		// Detection actions.
-		actionGens[i] = append(actionGens[i], detectActions{
-			url:                       u,
-			detectAccessDenied:        detectAccessDeniedOn,
-			detectCaptchaBox:          detectCaptchaBoxOn,
-			captchaWaitSelector:       captchaWaitSelector,
-			captchaClickSelector:      captchaClickSelector,
-			captchaIframeWaitSelector: captchaIframeWaitSelector,
-			captchaClickSleep:         captchaClickSleep,
-			dumpOnError:               errorDump,
-			locationOnError:           errorLocation,
-			dumpToRedis:               redisDumpOn,
-			notifyPath:                notifyPath,
-			detectNotifyPath:          detectNotifyPath,
-		})
-
-		// Wait action.
-		actionGens[i] = append(actionGens[i], waitActions{
-			url:             u,
-			waitSelector:    waitSelectors[i],
-			dumpOnError:     errorDump,
-			locationOnError: errorLocation,
-			dumpToRedis:     redisDumpOn,
-		})
-
-		// Discord notification action.
-		da := discordActions{
-			postActionData: discordMetaData,
-			url:            u,
-		}
-		if len(checkSelectors) > i && len(checkTypes) > i && len(expectedTexts) > i {
-			da.checkSelector = checkSelectors[i]
-			da.checkType = checkTypes[i]
-			da.expectedText = expectedTexts[i]
-		}
-		actionGens[i] = append(actionGens[i], da)
-	}
-
-	// Initialize and execute the watch executor.
-	e := executors["watch"].(*watchExecutor)
-	e.Init(actionGens, urls)
-	Log().Infof("Starting Discord watch executor for URLs: %v", urls)
-	e.Execute() // Blocks indefinitely.
+	return discordMetaData
 }
@@ -0,0 +1,239 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// documentHeaderPrefix is how a check selector of the document check type names a response header
+	documentHeaderPrefix = "header:"
+)
+
+// documentHop is a single request of the main document, a redirect unless it is the last one
+type documentHop struct {
+	url    string
+	status int64
+}
+
+// documentResponse is the response of the main document of a run, with the redirects that led to it
+type documentResponse struct {
+	mu         sync.Mutex
+	status     int64
+	statusText string
+	protocol   string
+	url        string
+	headers    map[string]string // by lower case name
+	names      map[string]string // lower case name -> name as sent
+	hops       []documentHop
+}
+
+// listenDocument records the main document response of the run, it must be called before the run starts
+func listenDocument(ctx context.Context) *documentResponse {
+	d := &documentResponse{}
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			if e.Type == network.ResourceTypeDocument && isMainFrame(ctx, string(e.FrameID)) {
+				d.onRequest(e)
+			}
+		case *network.EventResponseReceived:
+			if e.Type == network.ResourceTypeDocument && e.Response != nil && isMainFrame(ctx, string(e.FrameID)) {
+				d.onResponse(e.Response)
+			}
+		}
+	})
+
+	return d
+}
+
+// isMainFrame reports whether the frame is the main frame of the target, which shares its id with the target - iframes don't
+func isMainFrame(ctx context.Context, frameID string) bool {
+	c := chromedp.FromContext(ctx)
+	return c != nil && c.Target != nil && frameID == string(c.Target.TargetID)
+}
+
+func (d *documentResponse) onRequest(e *network.EventRequestWillBeSent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// a redirect re-uses the request, completing the previous hop with the redirect response
+	if e.RedirectResponse != nil && len(d.hops) != 0 {
+		d.hops[len(d.hops)-1].status = e.RedirectResponse.Status
+	}
+	d.hops = append(d.hops, documentHop{url: e.Request.URL})
+}
+
+func (d *documentResponse) onResponse(r *network.Response) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.status = r.Status
+	d.statusText = r.StatusText
+	d.protocol = r.Protocol
+	d.url = r.URL
+	d.headers = map[string]string{}
+	d.names = map[string]string{}
+	for k, v := range r.Headers {
+		d.headers[strings.ToLower(k)] = fmt.Sprintf("%v", v)
+		d.names[strings.ToLower(k)] = k
+	}
+	if len(d.hops) != 0 && d.hops[len(d.hops)-1].url == r.URL {
+		d.hops[len(d.hops)-1].status = r.Status
+	} else {
+		d.hops = append(d.hops, documentHop{url: r.URL, status: r.Status})
+	}
+}
+
+// get returns the status and final URL of the main document - a zero status means no response was received yet
+func (d *documentResponse) get() (int64, string) {
+	if d == nil {
+		return 0, ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status, d.url
+}
+
+// header returns the value of the response header, by case insensitive name
+func (d *documentResponse) header(name string) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, ok := d.headers[strings.ToLower(name)]
+	return v, ok
+}
+
+// headerLines returns the response headers of the main document as name: value lines, by lower case name in sorted order
+func (d *documentResponse) headerLines() string {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var keys []string
+	for k := range d.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range strings.Split(d.headers[k], "\n") {
+			fmt.Fprintf(&b, "%s: %s\n", k, v)
+		}
+	}
+	return b.String()
+}
+
+// redirects returns the chain of requests that led to the main document, as url (status) -> url (status)
+func (d *documentResponse) redirects() string {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var parts []string
+	for _, h := range d.hops {
+		parts = append(parts, fmt.Sprintf("%s (%d)", h.url, h.status))
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// headerBlock formats the redirects, status line and headers of the main document like an HTTP response head, redirects first
+func (d *documentResponse) headerBlock() string {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b strings.Builder
+	for i, h := range d.hops {
+		if i == len(d.hops)-1 && h.url == d.url {
+			break
+		}
+		fmt.Fprintf(&b, "# %d %s\n", h.status, h.url)
+	}
+	if d.status == 0 {
+		b.WriteString("# no response was received for the main document\n\n")
+		return b.String()
+	}
+
+	// chrome reports the ALPN ids, like http/1.1, h2 and h3 - when it didn't say, the version is left out
+	protocol := strings.ToUpper(d.protocol)
+	switch {
+	case len(protocol) == 0:
+		protocol = "HTTP"
+	case protocol == "H2":
+		protocol = "HTTP/2"
+	case strings.HasPrefix(protocol, "H3"):
+		protocol = "HTTP/3"
+	}
+	fmt.Fprintf(&b, "%s %d %s\n", protocol, d.status, d.statusText)
+
+	var keys []string
+	for k := range d.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// a header sent more than once is joined with newlines
+		for _, v := range strings.Split(d.headers[k], "\n") {
+			fmt.Fprintf(&b, "%s: %s\n", d.names[k], v)
+		}
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// extract returns the part of the main document that a document check selector names - status, status_text, final_url, redirects or header:<name>
+func (d *documentResponse) extract(selector string) (string, error) {
+	status, finalURL := d.get()
+	if status == 0 {
+		return "", fmt.Errorf("No response was received for the main document, so there is no [%s] to check", selector)
+	}
+
+	switch selector {
+	case "status":
+		return fmt.Sprintf("%d", status), nil
+	case "status_text":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.statusText, nil
+	case "final_url":
+		return finalURL, nil
+	case "redirects":
+		return d.redirects(), nil
+	}
+
+	name := strings.TrimPrefix(selector, documentHeaderPrefix)
+	v, ok := d.header(name)
+	if !ok {
+		return "", fmt.Errorf("Main document response for [%s] has no [%s] header", finalURL, name)
+	}
+	return v, nil
+}
+
+// checkDocumentSelector validates a check selector of the document check type
+func checkDocumentSelector(selector string) error {
+	switch selector {
+	case "status", "status_text", "final_url", "redirects":
+		return nil
+	}
+	if strings.HasPrefix(selector, documentHeaderPrefix) && len(strings.TrimPrefix(selector, documentHeaderPrefix)) != 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Unknown document check selector [%s] - must be one of status, status_text, final_url, redirects or %s<name>", selector, documentHeaderPrefix)
+}
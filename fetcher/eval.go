@@ -0,0 +1,87 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// DefaultEvalTimeout default time (seconds) a user supplied script may run for
+	DefaultEvalTimeout = 10
+
+	// scriptFilePrefix marks a script argument as a path to a file containing the script
+	scriptFilePrefix = "@"
+)
+
+// loadScript returns the script itself, or the contents of the file if it is passed in as @path
+func loadScript(script string) (string, error) {
+	if !strings.HasPrefix(script, scriptFilePrefix) {
+		return script, nil
+	}
+
+	path := strings.TrimPrefix(script, scriptFilePrefix)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read script file [%s]: %v", path, err)
+	}
+
+	return string(data), nil
+}
+
+// evaluateScript runs the script in the page, awaits it if it returns a promise and JSON encodes the result
+func evaluateScript(ctx context.Context, script string) (string, error) {
+	expression, err := loadScript(script)
+	if err != nil {
+		Log().Errorf("%v", err)
+		return "", err
+	}
+
+	timeout := time.Duration(runInfoFromContext(ctx).config.evalTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultEvalTimeout * time.Second
+	}
+
+	// the context bounds how long we wait, the runtime timeout stops the script itself so it can't wedge the page
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var raw []byte
+	err = chromedp.Evaluate(expression, &raw, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+		return p.WithAwaitPromise(true).WithTimeout(runtime.TimeDelta(timeout.Milliseconds()))
+	}).Do(tctx)
+	if err != nil {
+		if tctx.Err() != nil && ctx.Err() == nil {
+			err = fmt.Errorf("Script did not finish within %s: %v", timeout, err)
+		}
+		Log().Errorf("%v", err)
+		return "", err
+	}
+
+	if len(raw) == 0 {
+		// undefined results come back empty
+		return "null", nil
+	}
+
+	var buf bytes.Buffer
+	if err = json.Compact(&buf, raw); err != nil {
+		err = fmt.Errorf("Script result [%s] is not valid JSON: %v", raw, err)
+		Log().Errorf("%v", err)
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// CheckScript makes sure a script argument can be loaded, which matters when it is passed in as @path
+func CheckScript(script string) error {
+	_, err := loadScript(script)
+	return err
+}
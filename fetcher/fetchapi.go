@@ -0,0 +1,268 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultFetchConcurrency default number of browsers serve runs at once, for fetches and the watch together
+	DefaultFetchConcurrency = 2
+
+	// DefaultFetchQueue default number of fetches that wait for a browser before more are turned away
+	DefaultFetchQueue = 16
+
+	// DefaultFetchTimeout default, and max, time (seconds) a fetch may take
+	DefaultFetchTimeout = 60
+
+	maxFetchFields = 50
+)
+
+// browserPool limits how many browsers serve runs at once - the watch waits for one like a fetch does, but isn't turned away
+type browserPool struct {
+	slots   chan struct{}
+	queue   int32
+	waiting int32
+}
+
+// fetchField is a piece of the page a fetch extracts, either the text, href or id of a selector or the JSON encoded result of a script
+type fetchField struct {
+	Selector string `json:"selector,omitempty"`
+	Type     string `json:"type,omitempty"` // text (the default), href or id
+	Script   string `json:"script,omitempty"`
+}
+
+// fetchRequest is the body of POST /fetch
+type fetchRequest struct {
+	URL          string                `json:"url"`
+	WaitSelector string                `json:"wait_selector,omitempty"`
+	Fields       map[string]fetchField `json:"fields,omitempty"`
+	Steps        []pageStep            `json:"steps,omitempty"`
+	HTML         bool                  `json:"html,omitempty"`
+	Screenshot   bool                  `json:"screenshot,omitempty"`
+	Timeout      int                   `json:"timeout,omitempty"` // seconds
+}
+
+// fetchResponse is what POST /fetch answers with - the screenshot is a base64 encoded PNG
+type fetchResponse struct {
+	URL        string            `json:"url"`
+	FinalURL   string            `json:"final_url,omitempty"`
+	Status     int64             `json:"status,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	HTML       string            `json:"html,omitempty"`
+	Screenshot []byte            `json:"screenshot,omitempty"`
+	Duration   string            `json:"duration"`
+	Error      string            `json:"error,omitempty"`
+
+	duration time.Duration
+}
+
+func newBrowserPool(size int, queue int) *browserPool {
+	return &browserPool{slots: make(chan struct{}, size), queue: int32(queue)}
+}
+
+// acquire waits for a browser - a bounded wait is turned away once the queue is full
+func (p *browserPool) acquire(ctx context.Context, bounded bool) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if n := atomic.AddInt32(&p.waiting, 1); bounded && n > p.queue {
+		atomic.AddInt32(&p.waiting, -1)
+		return &apiError{http.StatusServiceUnavailable, fmt.Sprintf("All browsers are busy and [%d] fetches are already waiting for one", p.queue)}
+	}
+	defer atomic.AddInt32(&p.waiting, -1)
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *browserPool) release() {
+	<-p.slots
+}
+
+// checkFetchFlags validates the fetch flags of serve
+func checkFetchFlags() error {
+	if viper.GetInt("fetch_concurrency") < 1 {
+		return fmt.Errorf("The fetch_concurrency must be at least 1")
+	}
+	if viper.GetInt("fetch_queue") < 0 {
+		return fmt.Errorf("The fetch_queue can't be negative")
+	}
+	if viper.GetInt("fetch_timeout") < 1 {
+		return fmt.Errorf("The fetch_timeout must be at least 1")
+	}
+
+	return nil
+}
+
+func (f *fetchRequest) validate() error {
+	t := serveTarget{URL: f.URL}
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if f.Timeout < 0 || f.Timeout > viper.GetInt("fetch_timeout") {
+		return fmt.Errorf("The timeout must be between 0, for the default, and [%d] seconds", viper.GetInt("fetch_timeout"))
+	}
+	if len(f.Fields) > maxFetchFields {
+		return fmt.Errorf("At most [%d] fields can be extracted, got [%d]", maxFetchFields, len(f.Fields))
+	}
+	for name, field := range f.Fields {
+		if len(field.Script) != 0 {
+			if len(field.Selector) != 0 || len(field.Type) != 0 {
+				return fmt.Errorf("Field [%s] can have either a script or a selector, not both", name)
+			}
+			if strings.HasPrefix(field.Script, scriptFilePrefix) {
+				return fmt.Errorf("Field [%s] can't load its script from a file", name)
+			}
+			continue
+		}
+		if len(field.Selector) == 0 {
+			return fmt.Errorf("Field [%s] requires a selector or a script", name)
+		}
+		switch field.Type {
+		case "", "text", "href", "id":
+		default:
+			return fmt.Errorf("Unknown type [%s] for field [%s] - must be one of [text], [href] or [id]", field.Type, name)
+		}
+	}
+
+	return checkSteps(f.Steps)
+}
+
+// actions builds the same pipeline as fetch for the request, with a dump for each field and one for the HTML if it is asked for
+func (f *fetchRequest) actions(fields map[string]chan dumpData, html chan dumpData) []actionGenerator {
+	u := f.URL
+	errorDump := viper.GetBool("error_dump")
+	errorLocation := viper.GetBool("error_location")
+
+	gens := []actionGenerator{
+		blockActions{url: u, policy: targetResourcePolicy(nil, 0)},
+		deviceActions{url: u, device: targetDevice(nil, 0)},
+		navigateActions{url: u},
+		detectActions{url: u, detectAccessDenied: viper.GetBool("detect_access_denied"), detectCaptchaBox: viper.GetBool("detect_captcha_box"), captchaWaitSelector: viper.GetString("captcha_wait_selector"), captchaClickSelector: viper.GetString("captcha_click_selector"), captchaIframeWaitSelector: viper.GetString("captcha_iframe_wait_selector"), captchaClickSleep: viper.GetInt("captcha_click_sleep"), dumpOnError: errorDump, locationOnError: errorLocation},
+		waitActions{url: u, waitSelector: f.WaitSelector, dumpOnError: errorDump, locationOnError: errorLocation},
+		stepActions{url: u, steps: f.Steps},
+	}
+	for name, field := range f.Fields {
+		d := dumpActions{postActionData: fields[name], evalScript: field.Script, url: u}
+		switch field.Type {
+		case "href":
+			d.hrefSelector = field.Selector
+		case "id":
+			d.idSelector = field.Selector
+		default:
+			d.textSelector = field.Selector
+		}
+		gens = append(gens, d)
+	}
+	if html != nil {
+		gens = append(gens, dumpActions{postActionData: html, url: u})
+	}
+
+	return gens
+}
+
+// fetch runs a fetch on demand and answers with what it extracted
+func (s *watchServer) fetch(w http.ResponseWriter, r *http.Request) {
+	req := &fetchRequest{}
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, serveMaxRequestSize))
+	d.DisallowUnknownFields()
+	if err := d.Decode(req); err != nil {
+		writeError(w, &apiError{http.StatusBadRequest, fmt.Sprintf("Invalid fetch: %v", err)})
+		return
+	}
+
+	resp, err := s.runFetch(r.Context(), req)
+	if resp != nil {
+		code := http.StatusOK
+		if err != nil {
+			code = http.StatusBadGateway
+		}
+		writeJSON(w, code, resp)
+		return
+	}
+	if e, ok := err.(*apiError); ok && e.code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	writeError(w, err)
+}
+
+// runFetch validates the request and runs it once a browser is free - a fetch that ran returns its response, with the error if it failed
+func (s *watchServer) runFetch(ctx context.Context, req *fetchRequest) (*fetchResponse, error) {
+	fields := map[string]chan dumpData{}
+	for name := range req.Fields {
+		fields[name] = make(chan dumpData, 1)
+	}
+	var html chan dumpData
+	if req.HTML || len(req.Fields) == 0 {
+		html = make(chan dumpData, 1)
+	}
+
+	// the actions are built from the config, which a target edit or reload may be changing
+	gConfigMu.RLock()
+	err := req.validate()
+	timeout := time.Duration(viper.GetInt("fetch_timeout")) * time.Second
+	var actions chromedp.Tasks
+	if err == nil {
+		actions = generate(req.actions(fields, html))
+	}
+	gConfigMu.RUnlock()
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, err.Error()}
+	}
+
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+	// the fetch stops with the request, or once the watch closes its browsers
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stop := context.AfterFunc(s.l.runs, cancel)
+	defer stop()
+
+	if err := s.pool.acquire(ctx, true); err != nil {
+		if _, ok := err.(*apiError); !ok {
+			err = &apiError{http.StatusServiceUnavailable, fmt.Sprintf("Gave up waiting for a browser: %v", err)}
+		}
+		return nil, err
+	}
+	defer s.pool.release()
+
+	Log().Infof("Fetching URL [%s] for an API request", req.URL)
+	res := &runResult{started: time.Now(), keepScreenshot: req.Screenshot}
+	err = run(context.WithValue(ctx, runResultContextKey{}, res), actions, req.URL)
+	res.duration = time.Since(res.started)
+	resp := &fetchResponse{URL: req.URL, FinalURL: res.finalURL, Status: res.status, Screenshot: res.screenshot, Duration: res.duration.Round(time.Millisecond).String(), duration: res.duration}
+	if err != nil {
+		resp.Error = err.Error()
+		return resp, err
+	}
+
+	// every dump was sent during the run, since it succeeded
+	if len(fields) != 0 {
+		resp.Fields = map[string]string{}
+		for name, ch := range fields {
+			resp.Fields[name] = (<-ch).ExtractText
+		}
+	}
+	if html != nil {
+		resp.HTML = (<-html).ExtractText
+	}
+
+	return resp, nil
+}
@@ -1,15 +1,19 @@
 package fetcher
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"math/rand"
+	"mime/multipart"
 	"net/smtp"
-	"net/url"
+	"net/textproto"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/apsdehal/go-logger"
@@ -46,8 +50,6 @@ const (
 
 	// DefaultCaptchaClickSleep default time (seconds) we sleep after a captcha click, to allow the captcha challenge to get loaded into the iframe
 	DefaultCaptchaClickSleep = 5
-
-	accessDeniedMessage = "Access Denied"
 )
 
 var (
@@ -61,15 +63,14 @@ var (
 		"watch": &watchExecutor{},
 	}
 
-	gLog    *logger.Logger
-	gAgents = []string{}
+	gLog *logger.Logger
 
-	gSelectedAgents = map[string]string{}
-	gWorkingAgents  = map[string]string{}
+	gWaitErrorDumps   = make(chan dumpData, workerQueueSize)
+	gDetectErrorDumps = make(chan dumpData, workerQueueSize)
+	gCaptchaDumps     = make(chan dumpData, workerQueueSize)
 
-	gWaitErrorDumps   = make(chan dumpData)
-	gDetectErrorDumps = make(chan dumpData)
-	gCaptchaDumps     = make(chan dumpData)
+	// gConfigMu guards the viper config, which serve changes for target edits and reloads while fetches run - changes hold it for writing, runs for reading while they take their runConfig
+	gConfigMu sync.RWMutex
 )
 
 type actionGenerator interface {
@@ -81,6 +82,64 @@ type actionExecutor interface {
 	Execute()
 }
 
+// runInfo is what was chosen for a single run, kept in its context so the actions can report back on it
+type runInfo struct {
+	targetURL string
+	agent     string              // the user-agent, or the name of the profile when rotating fingerprint profiles
+	profile   *fingerprintProfile // only set when rotating fingerprint profiles
+	proxy     *proxyEntry
+	document  *documentResponse
+	responses *responseCapture // only for the json check type
+	config    runConfig
+
+	// set when a block rule counted the page against the agent, so the run is recorded as a failure of it
+	agentBlocked bool
+
+	// what the check of the run found, and whether it sent a notification for it
+	value    string
+	notified bool
+}
+
+type runInfoContextKey struct{}
+
+// runConfig is the config a run reads once it started, taken when it starts so the config can change while it runs
+type runConfig struct {
+	timeout       int
+	headless      bool
+	overrideFlags []string
+	userDataDir   string
+	evalTimeout   int
+
+	harDir           string
+	harOnErrorOnly   bool
+	harIncludeBodies bool
+	harMaxBodySize   int
+
+	captchaSolver       string
+	captchaSolverURL    string
+	captchaSolverKey    string
+	captchaSolveTimeout int
+}
+
+// currentRunConfig takes the config for a run - the caller must hold gConfigMu for reading
+func currentRunConfig() runConfig {
+	return runConfig{
+		timeout:             viper.GetInt("timeout"),
+		headless:            viper.GetBool("headless"),
+		overrideFlags:       viper.GetStringSlice("override_flags"),
+		userDataDir:         viper.GetString("user_data_dir"),
+		evalTimeout:         viper.GetInt("eval_timeout"),
+		harDir:              viper.GetString("har_dir"),
+		harOnErrorOnly:      viper.GetBool("har_on_error_only"),
+		harIncludeBodies:    viper.GetBool("har_include_bodies"),
+		harMaxBodySize:      viper.GetInt("har_max_body_size"),
+		captchaSolver:       viper.GetString("captcha_solver"),
+		captchaSolverURL:    viper.GetString("captcha_solver_url"),
+		captchaSolverKey:    viper.GetString("captcha_solver_key"),
+		captchaSolveTimeout: viper.GetInt("captcha_solve_timeout"),
+	}
+}
+
 type dumpData struct {
 	URL         string
 	ExtractText string
@@ -88,8 +147,9 @@ type dumpData struct {
 }
 
 type emailData struct {
-	URL  string
-	Text string
+	URL   string
+	Text  string
+	Image []byte // PNG attachment, only set by checks that produce an image
 }
 
 type navigateActions struct {
@@ -111,9 +171,10 @@ type detectActions struct {
 
 	dumpToRedis bool
 
-	detectNotifyPath bool
-	notifyPath       string         // a url path/domain sequence that indicates a more unique circumstance that we might want to be notified about
-	postActionEmail  chan emailData // only if we want to email on certain detection cases
+	detectNotifyPath  bool
+	notifyPath        string           // a url path/domain sequence that indicates a more unique circumstance that we might want to be notified about
+	postActionEmail   chan emailData   // only if we want to email on certain detection cases
+	postActionDiscord chan discordData // only if we want to notify Discord on certain detection cases
 }
 
 type waitActions struct {
@@ -132,6 +193,7 @@ type dumpActions struct {
 	textSelector string
 	hrefSelector string
 	idSelector   string
+	evalScript   string
 
 	url string
 }
@@ -142,6 +204,7 @@ type emailActions struct {
 	checkSelector string
 	checkType     string
 	expectedText  string
+	sources       checkSources
 
 	url string
 }
@@ -154,11 +217,28 @@ type fetchExecutor struct {
 }
 
 type watchExecutor struct {
-	interval int
-	urls     []string
-	actions  []chromedp.Tasks
+	schedules  []*targetSchedule
+	urls       []string
+	actions    []chromedp.Tasks
+	signatures []string
+
+	// what a reload validates the config with and rebuilds the targets from
+	check func() error
+	build targetBuilder
+
+	// set by serve, to be told about the runs and to change the targets between them
+	observer watchObserver
+	calls    chan func(q *runQueue) *runQueue
+	pool     *browserPool
+
+	// the runs in flight, handed back through done once they finished
+	running []*watchRun
+	done    chan *watchRun
 
 	dumpOnError bool
+
+	// the exit status of the watch, set once Execute returns
+	code int
 }
 
 type emailWatchFunc struct {
@@ -199,17 +279,15 @@ func (n navigateActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 			err := chromedp.Navigate(n.url).Do(ctx)
 			if err != nil {
 				Log().Errorf("%v", err)
-				// suspecting it's a user agent issue, so we unset any working agent that may have failed at this point
-				if len(gWorkingAgents[n.url]) != 0 {
-					Log().Errorf("User-agent [%s] for URL [%s] no longer working, will unset it and try a different one on the next request", gWorkingAgents[n.url], n.url)
-					gWorkingAgents[n.url] = ""
-				}
-			} else {
-				// if a navigate succeeded, we pick the selected agent from that request as the working one
-				if len(gWorkingAgents[n.url]) == 0 {
-					Log().Infof("User-agent [%s] for URL [%s] succeeded, so it will be set as the current working agent", gSelectedAgents[n.url], n.url)
-					gWorkingAgents[n.url] = gSelectedAgents[n.url]
-				}
+			}
+			// only a page load that times out counts against the proxy, a timeout later in the run is down to the page
+			if errors.Is(err, context.DeadlineExceeded) {
+				markProxyBad(ctx, "timeout")
+			}
+
+			info := runInfoFromContext(ctx)
+			if status, finalURL := info.document.get(); status != 0 {
+				Log().Infof("Main document for URL [%s] returned status [%d] from [%s] after [%s]", n.url, status, finalURL, info.document.redirects())
 			}
 
 			return err
@@ -233,11 +311,9 @@ func (d detectActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 				if strings.Contains(currentURL, d.notifyPath) {
 					err = fmt.Errorf("Found [%s] path in URL [%s], for target URL [%s] so we are performing notify action(s) that are set", d.notifyPath, currentURL, d.url)
 
-					go func() {
-						if d.postActionEmail != nil {
-							d.postActionEmail <- emailData{URL: d.url, Text: currentURL}
-						}
-					}()
+					if d.postActionEmail != nil {
+						enqueue(d.postActionEmail, emailData{URL: d.url, Text: currentURL})
+					}
 					return err
 				}
 
@@ -246,7 +322,7 @@ func (d detectActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 				return nil
 			}))
 	}
-	if d.detectAccessDenied {
+	if rules := activeBlockRules(d.detectAccessDenied); len(rules) != 0 {
 		actions = append(actions,
 			chromedp.ActionFunc(func(ctx context.Context) error {
 				s := pageSnaps{targetURL: d.url, checkLocation: d.locationOnError, dumpPageContents: true, sendDumps: d.dumpToRedis, dumps: gDetectErrorDumps, dumpOnError: d.dumpOnError}
@@ -257,23 +333,20 @@ func (d detectActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 					return err
 				}
 
-				var title string
-				err = chromedp.Title(&title).Do(ctx)
+				rule, page, err := detectBlockPage(ctx, rules, d.url)
+				if err == nil && rule != nil {
+					Log().Infof("Block rule [%s] matched the page for URL [%s] with title [%s] and status [%d] at [%s], taking its actions [%s]", rule.Name, d.url, page.title, page.status, page.location, rule.Actions)
+					rule.counter(ctx, page, d.notify)
+					err = fmt.Errorf("Blocked by rule [%s] for URL [%s]", rule.Name, d.url)
+				}
 				err = s.after(ctx, err)
 				if err != nil {
 					Log().Errorf("%v", err)
 					return err
 				}
 
-				if !strings.Contains(title, accessDeniedMessage) {
-					Log().Infof("Didn't find the [%s] message on the page for URL [%s], nothing to do here, proceeding to next step", accessDeniedMessage, d.url)
-					return nil
-				}
-
-				Log().Infof("Encountered [%s] message, will unset the current user-agent for this URL [%s], which is currently [%s] so we try a different one during the next request", accessDeniedMessage, d.url, gWorkingAgents[d.url])
-				gWorkingAgents[d.url] = ""
-
-				return err
+				Log().Infof("None of the [%d] block rules matched the page for URL [%s], proceeding to next step", len(rules), d.url)
+				return nil
 			}))
 	}
 	if d.detectCaptchaBox {
@@ -288,8 +361,7 @@ func (d detectActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 				}
 
 				if d.url == s.currentURL {
-					Log().Infof("No location change detected for target URL [%s] so we will not try to detect a captcha box", d.url)
-					return nil
+					return d.inlineCaptcha(ctx, &s)
 				}
 				Log().Infof("Detected location change for target URL [%s] to current URL [%s] so we will proceed to check for a captcha box", d.url, s.currentURL)
 
@@ -346,11 +418,33 @@ func (d detectActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 						Log().Errorf("%v", err)
 						return err
 					}
-					err = fmt.Errorf("Successfully loaded the captcha challenge, but we are still blocked by it, so we are just going to error out")
+					solver := newCaptchaSolver(runInfoFromContext(ctx).config, d.notify)
+					if solver == nil {
+						err = fmt.Errorf("Successfully loaded the captcha challenge, but we are still blocked by it, so we are just going to error out")
+						err = c.after(ctx, err)
+						if err != nil {
+							Log().Errorf("%v", err)
+						}
+						return err
+					}
+
+					err = solveCaptcha(ctx, solver, d.url)
+					if err == nil {
+						Log().Infof("Sleeping for [%d] seconds to allow the page for URL [%s] to accept the captcha", d.captchaClickSleep, d.url)
+						err = chromedp.Sleep(time.Duration(d.captchaClickSleep) * time.Second).Do(ctx)
+					}
+					if err == nil {
+						err = s.before(ctx)
+					}
+					if err == nil && d.url != s.currentURL {
+						err = fmt.Errorf("Solved the captcha challenge, but we are still blocked at [%s], so we are just going to error out", s.currentURL)
+					}
 					err = c.after(ctx, err)
 					if err != nil {
 						Log().Errorf("%v", err)
+						return err
 					}
+					Log().Infof("Solved the captcha challenge for URL [%s], proceeding to next step", d.url)
 				}
 
 				return err
@@ -360,6 +454,61 @@ func (d detectActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 	return actions
 }
 
+// inlineCaptcha takes action against a captcha shown at the target URL itself, like a turnstile widget or a challenge page, found by the page content or the markers of the response
+func (d detectActions) inlineCaptcha(ctx context.Context, s *pageSnaps) error {
+	info := runInfoFromContext(ctx)
+	c, err := detectCaptcha(ctx)
+	if err != nil {
+		Log().Errorf("%v", err)
+		return err
+	}
+	marker := captchaResponseMarker(info.document)
+	if len(c.Type) == 0 && len(marker) == 0 {
+		Log().Infof("No location change detected and no captcha found on the page for target URL [%s], so there is no captcha box to take action against", d.url)
+		return nil
+	}
+	Log().Infof("No location change detected for target URL [%s], but found captcha [%s] on the page with response marked as a challenge by [%s]", d.url, c.Type, marker)
+
+	// a challenge page without a captcha on it may pass by itself, so it only needs the time to do so
+	if len(c.Type) != 0 {
+		solver := newCaptchaSolver(info.config, d.notify)
+		if solver == nil {
+			err = fmt.Errorf("Found a [%s] captcha at target URL [%s], but we are still blocked by it, so we are just going to error out", c.Type, d.url)
+		} else {
+			err = solveCaptcha(ctx, solver, d.url)
+		}
+	}
+	if err == nil {
+		Log().Infof("Sleeping for [%d] seconds to allow the page for URL [%s] to accept the captcha", d.captchaClickSleep, d.url)
+		err = chromedp.Sleep(time.Duration(d.captchaClickSleep) * time.Second).Do(ctx)
+	}
+	if err == nil {
+		var cleared bool
+		cleared, err = captchaCleared(ctx)
+		if err == nil && !cleared {
+			err = fmt.Errorf("Still blocked by the captcha at target URL [%s], so we are just going to error out", d.url)
+		}
+	}
+	err = s.after(ctx, err)
+	if err != nil {
+		Log().Errorf("%v", err)
+		return err
+	}
+
+	Log().Infof("Got past the captcha for URL [%s], proceeding to next step", d.url)
+	return nil
+}
+
+// notify sends the text and image to whichever notifier is set for the detection
+func (d detectActions) notify(text string, image []byte) {
+	if d.postActionEmail != nil {
+		enqueue(d.postActionEmail, emailData{URL: d.url, Text: text, Image: image})
+	}
+	if d.postActionDiscord != nil {
+		enqueue(d.postActionDiscord, discordData{URL: d.url, Text: text, Image: image})
+	}
+}
+
 func (w waitActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 	if len(w.waitSelector) != 0 {
 		actions = append(actions,
@@ -413,6 +562,11 @@ func (d dumpActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 				if err != nil {
 					return err
 				}
+			} else if len(d.evalScript) != 0 {
+				res, err = evaluateScript(ctx, d.evalScript)
+				if err != nil {
+					return err
+				}
 			} else {
 				// by default, this will grab pretty much everything
 				res, err = extractData(ctx, "", "dump")
@@ -422,7 +576,7 @@ func (d dumpActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 			}
 
 			go func() {
-				d.postActionData <- dumpData{URL: currentURL, ExtractText: res}
+				d.postActionData <- dumpData{URL: currentURL, ExtractText: res, Ctx: ctx}
 			}()
 
 			return err
@@ -434,25 +588,39 @@ func (d dumpActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 func (e emailActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
 	actions = append(actions,
 		chromedp.ActionFunc(func(ctx context.Context) error {
+			if e.sources.change != nil {
+				notice, err := e.sources.change.detect(ctx)
+				if err != nil {
+					return err
+				}
+				if notice != nil {
+					Log().Infof("Found changes for URL [%s] since the last check, so we will perform the desired action!", e.url)
+					info := runInfoFromContext(ctx)
+					info.value, info.notified = notice.text, true
+					enqueue(e.postActionData, emailData{URL: e.url, Text: notice.text, Image: notice.image})
+				}
+				return nil
+			}
+
 			if len(e.checkSelector) != 0 && len(e.expectedText) != 0 {
-				res, err := extractData(ctx, e.checkSelector, e.checkType)
+				res, err := extractCheckData(ctx, e.checkSelector, e.checkType, e.sources)
 				if err != nil {
 					return err
 				}
+				info := runInfoFromContext(ctx)
+				info.value = res
 
 				if !strings.Contains(res, e.expectedText) {
 					Log().Infof("Result found for URL [%s] was [%s], which has been updated from the original value of expected text [%s] so we will perform the desired action!", e.url, res, e.expectedText)
-					go func() {
-						e.postActionData <- emailData{URL: e.url, Text: res}
-					}()
+					info.notified = true
+					enqueue(e.postActionData, emailData{URL: e.url, Text: res})
 				} else {
 					Log().Infof("Result found for URL [%s] was still [%s], which matches the expected text [%s], so we take no action", e.url, res, e.expectedText)
 				}
 			} else {
 				Log().Infof("We were simply told to wait for a page load so we could take action for URL [%s] - this condition has been met, so we are now performing the desired action", e.url)
-				go func() {
-					e.postActionData <- emailData{URL: e.url}
-				}()
+				runInfoFromContext(ctx).notified = true
+				enqueue(e.postActionData, emailData{URL: e.url})
 			}
 
 			return nil
@@ -476,7 +644,7 @@ func (f *fetchExecutor) Init(actionGens [][]actionGenerator, urls []string) {
 
 func (f *fetchExecutor) Execute() {
 	for i, a := range f.actions {
-		err := run(a, f.urls[i])
+		err := run(context.Background(), a, f.urls[i])
 		if err != nil {
 			Log().Errorf("For URL [%s], received error [%v]", f.urls[i], err)
 		}
@@ -488,38 +656,32 @@ func (f *fetchExecutor) Execute() {
 
 func (w *watchExecutor) Init(actionGens [][]actionGenerator, urls []string) {
 	w.urls = urls
-	w.interval = viper.GetInt("interval")
 
-	Log().Infof("Will check for updates every %d seconds\n", w.interval)
+	for i := range urls {
+		// the schedules are validated in the common checks, so an error can't happen here
+		sched, _ := newTargetSchedule(i)
+		w.schedules = append(w.schedules, sched)
+		w.signatures = append(w.signatures, targetSignature(i))
+	}
 
 	for _, gens := range actionGens {
-		a := make(chromedp.Tasks, 0)
-		for _, g := range gens {
-			a = g.Generate(a)
-		}
-		w.actions = append(w.actions, a)
+		w.actions = append(w.actions, generate(gens))
 	}
 }
 
-func (w *watchExecutor) Execute() {
-	for i, a := range w.actions {
-		err := run(a, w.urls[i])
-		if err != nil {
-			Log().Errorf("Data for %s was not available during this check - received error %s\n", w.urls[i], err.Error())
-		}
-	}
-	ticker := time.NewTicker(time.Duration(w.interval) * time.Second)
-	for {
-		select {
-		case _ = <-ticker.C:
-			for i, a := range w.actions {
-				err := run(a, w.urls[i])
-				if err != nil {
-					Log().Errorf("Data for %s was not available during this check - received error %s\n", w.urls[i], err.Error())
-				}
-			}
-		}
+// generate chains the actions of the generators of a URL
+func generate(gens []actionGenerator) chromedp.Tasks {
+	a := make(chromedp.Tasks, 0)
+	for _, g := range gens {
+		a = g.Generate(a)
 	}
+	return a
+}
+
+func (w *watchExecutor) Execute() {
+	l := newWatchLifecycle()
+	w.schedule(l)
+	w.code = l.shutdown()
 }
 
 func (e emailWatchFunc) sendEmail(data emailData) {
@@ -563,12 +725,17 @@ func (e emailWatchFunc) sendEmail(data emailData) {
 		return
 	}
 
-	message := "To: " + e.toEmail + "\r\n" +
-		"Subject: " + e.toSubject + "\r\n" +
-		"\r\n" +
-		"URL: " + data.URL + "\r\n"
+	body := "URL: " + data.URL + "\r\n"
 	if len(data.Text) != 0 {
-		message += "Text: " + data.Text + "\r\n"
+		body += "Text: " + data.Text + "\r\n"
+	}
+
+	message := "To: " + e.toEmail + "\r\n" +
+		"Subject: " + e.toSubject + "\r\n"
+	if len(data.Image) != 0 {
+		message += imageMessage(body, data.Image)
+	} else {
+		message += "\r\n" + body
 	}
 
 	_, err = w.Write([]byte(message))
@@ -585,6 +752,34 @@ func (e emailWatchFunc) sendEmail(data emailData) {
 	Log().Infof("Emailed %s successfully\n", e.toEmail)
 }
 
+// imageMessage is the MIME headers and body of an email with the text body and a PNG attachment
+func imageMessage(body string, img []byte) string {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	p, _ := w.CreatePart(h)
+	p.Write([]byte(body))
+
+	h = textproto.MIMEHeader{}
+	h.Set("Content-Type", "image/png")
+	h.Set("Content-Transfer-Encoding", "base64")
+	h.Set("Content-Disposition", `attachment; filename="diff.png"`)
+	p, _ = w.CreatePart(h)
+	encoded := base64.StdEncoding.EncodeToString(img)
+	for len(encoded) > 76 {
+		p.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	p.Write([]byte(encoded + "\r\n"))
+	w.Close()
+
+	return "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=" + w.Boundary() + "\r\n" +
+		"\r\n" + b.String()
+}
+
 func extractData(ctx context.Context, selector string, selectorType string) (string, error) {
 	var res string
 	switch selectorType {
@@ -683,47 +878,35 @@ func (s *pageSnaps) before(ctx context.Context) error {
 
 func (s *pageSnaps) after(ctx context.Context, err error) error {
 	Log().Debugf("Performing after page snap steps for URL [%s]", s.targetURL)
+	document := runInfoFromContext(ctx).document
 	if err != nil && (s.dumpPageContents || s.dumpCaptcha) && s.dumpOnError {
+		// the response head of the main document goes first, so the dump shows how we got to the page
+		dump := document.headerBlock() + s.pageDump
 		if s.sendDumps {
 			Log().Errorf("Dumping content for URL [%s] to redis", s.targetURL)
-			go func() {
-				s.dumps <- dumpData{URL: s.targetURL, ExtractText: s.pageDump, Ctx: ctx}
-			}()
+			enqueue(s.dumps, dumpData{URL: s.targetURL, ExtractText: dump, Ctx: ctx})
 		} else {
 			Log().Errorf("Dumping content for URL [%s] to stdout:", s.targetURL)
-			fmt.Printf("%s", s.pageDump)
+			fmt.Printf("%s", dump)
 		}
 	}
 	if err != nil && s.checkLocation {
-		Log().Errorf("Logging the current URL location as [%s] for our original target [%s]", s.currentURL, s.targetURL)
+		status, _ := document.get()
+		Log().Errorf("Logging the current URL location as [%s] with main document status [%d] for our original target [%s]", s.currentURL, status, s.targetURL)
 	}
 
 	Log().Debugf("Done with after page snap steps for URL [%s]", s.targetURL)
 	return err
 }
 
-func getAgent(agents []string) string {
-	rand.Seed(time.Now().UTC().UnixNano())
-	rand.Shuffle(len(agents), func(i, j int) {
-		agents[i], agents[j] = agents[j], agents[i]
-	})
-	index := rand.Intn(len(agents))
-	return agents[index]
-}
-
-func setOpt(targetURL string) ([]func(*chromedp.ExecAllocator), error) {
-	var agent string
-	if len(gWorkingAgents[targetURL]) == 0 {
-		gSelectedAgents[targetURL] = getAgent(gAgents)
-		agent = gSelectedAgents[targetURL]
-		Log().Infof("No working agent for URL [%s], so using selected user-agent [%s] for this attempt", targetURL, agent)
-	} else {
-		agent = gWorkingAgents[targetURL]
-		Log().Infof("Last working agent was [%s] for URL [%s], so will continue using it", agent, targetURL)
+func setOpt(info *runInfo) ([]func(*chromedp.ExecAllocator), error) {
+	agent := info.agent
+	if info.profile != nil {
+		agent = info.profile.UserAgent
 	}
 
-	runHeadless := viper.GetBool("headless")
-	overrideFlags := viper.GetStringSlice("override_flags")
+	runHeadless := info.config.headless
+	overrideFlags := info.config.overrideFlags
 	var opts []func(*chromedp.ExecAllocator)
 
 	if len(overrideFlags) > 0 {
@@ -736,7 +919,7 @@ func setOpt(targetURL string) ([]func(*chromedp.ExecAllocator), error) {
 		}
 	} else {
 		if !runHeadless {
-			userDataDir := viper.GetString("user_data_dir")
+			userDataDir := info.config.userDataDir
 			Log().Infof("Running without headless enabled, using user_data_dir [%s]", userDataDir)
 			opts = []chromedp.ExecAllocatorOption{
 				chromedp.UserAgent(agent),
@@ -763,72 +946,72 @@ func setOpt(targetURL string) ([]func(*chromedp.ExecAllocator), error) {
 		}
 	}
 
-	// Setup proxy if configured
-	proxyURL := viper.GetString("proxy_url")
-	if proxyURL != "" {
-		proxyOpts, err := setupProxyForChrome(proxyURL)
-		if err != nil {
-			Log().Errorf("Failed to set up proxy: %v", err)
-			return opts, err
-		}
-
-		// Add proxy options to the existing Chrome options
-		if proxyOpts != nil {
-			opts = append(opts, proxyOpts...)
-		}
+	if info.profile != nil {
+		opts = append(opts, chromedp.WindowSize(int(info.profile.Width), int(info.profile.Height)))
 	}
+	opts = append(opts, setupProxyForChrome(info.proxy)...)
 
 	return opts, nil
 }
 
-// setupProxyForChrome creates Chrome options for proxy configuration when proxy URL is provided
-func setupProxyForChrome(proxyURL string) ([]chromedp.ExecAllocatorOption, error) {
-	if proxyURL == "" {
-		return nil, nil // No proxy configuration needed
+func createChromeContext(parent context.Context, opts []func(*chromedp.ExecAllocator), timeout int) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancelTimeout context.CancelFunc
+	if timeout > 0 {
+		Log().Infof("Timeout specified: %ds\n", timeout)
+		ctx, cancelTimeout = context.WithTimeout(parent, time.Duration(timeout)*time.Second)
+	} else {
+		ctx, cancelTimeout = context.WithCancel(parent)
 	}
 
-	var proxyOpts []chromedp.ExecAllocatorOption
+	ctx, cancelAllocator := chromedp.NewExecAllocator(ctx, opts...)
+	ctx, cancelBrowser := chromedp.NewContext(ctx)
 
-	parsedURL, err := url.Parse(proxyURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	// closing the browser first lets it shut down cleanly, the allocator then waits for the process to exit
+	return ctx, func() {
+		cancelBrowser()
+		cancelAllocator()
+		cancelTimeout()
 	}
+}
 
-	// Extract host:port from the URL
-	proxyHostPort := parsedURL.Host
-	if proxyHostPort == "" {
-		return nil, fmt.Errorf("proxy URL must contain host:port")
+// runInfoFromContext returns what was chosen for the run, empty outside of a run
+func runInfoFromContext(ctx context.Context) *runInfo {
+	if info, ok := ctx.Value(runInfoContextKey{}).(*runInfo); ok {
+		return info
 	}
-
-	Log().Infof("Using proxy server [%s] for Chrome", proxyHostPort)
-
-	// Add proxy server flag
-	proxyOpts = append(proxyOpts,
-		chromedp.ProxyServer(proxyHostPort),
-	)
-
-	return proxyOpts, nil
+	return &runInfo{}
 }
 
-func createChromeContext(opts []func(*chromedp.ExecAllocator)) (context.Context, context.CancelFunc) {
-	var ctx context.Context
-	var cancel context.CancelFunc
+func run(parent context.Context, actions chromedp.Tasks, targetURL string) error {
+	// what the run reads from the config is read up front, so the config can change while it runs
+	gConfigMu.RLock()
+	limiter, policy, pool := limits(), robots(), proxies()
+	info := &runInfo{targetURL: targetURL, config: currentRunConfig()}
+	gConfigMu.RUnlock()
 
-	ctx = context.Background()
-	timeout := viper.GetInt("timeout")
-	if timeout > 0 {
-		Log().Infof("Timeout specified: %ds\n", timeout)
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	if until, ok := limiter.backingOff(targetURL); ok {
+		return fmt.Errorf("Target [%s] is backing off until [%s] after failing too many times in a row, skipping this run", targetURL, until.Format(time.RFC3339))
+	}
+	if !policy.allowed(targetURL) {
+		err := fmt.Errorf("Skipping URL [%s] since the robots.txt of host [%s] disallows it for agent token [%s]", targetURL, hostOf(targetURL), policy.agentToken())
+		Log().Warningf("%v", err)
+		return err
+	}
+	if err := limiter.wait(parent, targetURL); err != nil {
+		return err
 	}
 
-	ctx, _ = chromedp.NewExecAllocator(ctx, opts...)
-	ctx, cancel = chromedp.NewContext(ctx)
-
-	return ctx, cancel
-}
+	started := time.Now()
+	gConfigMu.RLock()
+	info.agent = agents().pick(targetURL)
+	info.profile = runProfile(info.agent)
+	gConfigMu.RUnlock()
+	if pool != nil {
+		info.proxy = pool.pick(targetURL)
+	}
 
-func run(actions chromedp.Tasks, targetURL string) error {
-	opts, err := setOpt(targetURL)
+	opts, err := setOpt(info)
 	if err != nil {
 		return err
 	}
@@ -837,10 +1020,34 @@ func run(actions chromedp.Tasks, targetURL string) error {
 	// between calls - this may involved saving the first one we init
 	// and reusing it in callers, but we'll leave this for now
 	// as it suits most of the current use cases
-	ctx, cancel := createChromeContext(opts)
+	ctx, cancel := createChromeContext(parent, opts, info.config.timeout)
 	defer cancel()
+	ctx = context.WithValue(ctx, runInfoContextKey{}, info)
+	info.document = listenDocument(ctx)
+
+	if info.profile != nil {
+		actions = append(chromedp.Tasks{info.profile.emulate(targetURL)}, actions...)
+	}
+
+	var har *harRecorder
+	if len(info.config.harDir) != 0 {
+		har = newHarRecorder(targetURL, info.config.harIncludeBodies, info.config.harMaxBodySize)
+		har.listen(ctx)
+	}
 
 	err = chromedp.Run(ctx, actions...)
+	if res := runResultFromContext(parent); res != nil {
+		res.collect(ctx, info, err)
+	}
+	if har != nil {
+		finishHar(har, info.config, err)
+	}
+	if info.proxy != nil {
+		pool.report(info.proxy, started, err)
+	}
+	// one outcome for the agent per run, once the page was checked - a failed run may well be what got us blocked
+	agents().record(targetURL, info.agent, err == nil && !info.agentBlocked)
+	limiter.record(targetURL, err)
 	return err
 }
 
@@ -881,16 +1088,19 @@ func redisWorker(redisURL string, redisPassword string, redisKeyExpiration int,
 		case d := <-gWaitErrorDumps:
 			{
 				redisWrite(client, d.ExtractText, "wait-errors", d.URL, redisKeyExpiration)
+				handled()
 				break
 			}
 		case d := <-gDetectErrorDumps:
 			{
 				redisWrite(client, d.ExtractText, "detect-errors", d.URL, redisKeyExpiration)
+				handled()
 				break
 			}
 		case d := <-gCaptchaDumps:
 			{
 				redisWrite(client, d.ExtractText, "catpcha-dumps", d.URL, redisKeyExpiration)
+				handled()
 				break
 			}
 		}
@@ -930,12 +1140,42 @@ func CommonRootChecks(cmd *cobra.Command) error {
 		return fmt.Errorf("If we are not running in headless mode, we need to specify a non-empty user_data_dir")
 	}
 
-	gAgents = viper.GetStringSlice("agents")
-	if len(gAgents) == 0 {
-		Log().Info("No user agents specified, setting to default")
-		gAgents = DefaultUserAgents
+	if err := checkProfiles(); err != nil {
+		return err
+	}
+	agents()
+
+	if err := checkResourcePolicies(viper.GetString("resource_policy")); err != nil {
+		return err
+	}
+
+	if err := checkDevices(viper.GetString("device")); err != nil {
+		return err
+	}
+
+	if err := checkStateBackend(); err != nil {
+		return err
+	}
+
+	if err := checkProxyFlags(); err != nil {
+		return err
+	}
+
+	if err := checkCaptchaSolver(); err != nil {
+		return err
+	}
+
+	if err := checkBlockRules(); err != nil {
+		return err
+	}
+
+	if err := checkRateLimitFlags(); err != nil {
+		return err
+	}
+
+	if err := checkRobotsFlags(); err != nil {
+		return err
 	}
-	Log().Infof("Running with [%d] user-agents: [%s]", len(gAgents), gAgents)
 
 	if viper.GetBool("redis_dumps") && !viper.IsSet("redis_url") {
 		return fmt.Errorf("We require a valid redis_url to dump to redis, specify one")
@@ -957,10 +1197,6 @@ func CommonWatchChecks(cmd *cobra.Command) error {
 	if len(urls) == 0 {
 		return fmt.Errorf("We require a non-empty slice of URLs")
 	}
-	waitSelectors := viper.GetStringSlice("wait_selectors")
-	if len(waitSelectors) == 0 {
-		return fmt.Errorf("We require a non-empty slice of wait_selectors")
-	}
 
 	checkSelectors := viper.GetStringSlice("check_selectors")
 	if len(checkSelectors) == 0 {
@@ -970,12 +1206,27 @@ func CommonWatchChecks(cmd *cobra.Command) error {
 	if len(checkTypes) == 0 {
 		return fmt.Errorf("We require a non-empty slice of check_types")
 	}
+
+	// json checks wait for their network response instead of a selector, so only the other check types need one
+	waitSelectors := viper.GetStringSlice("wait_selectors")
+	if len(waitSelectors) == 0 {
+		for _, t := range checkTypes {
+			if t != "json" {
+				return fmt.Errorf("We require a non-empty slice of wait_selectors unless every URL uses the json check type")
+			}
+		}
+	}
+	// the check types that notify on changes compare against what they saw before, so only the other check types need an expected text
 	expectedTexts := viper.GetStringSlice("expected_texts")
 	if len(expectedTexts) == 0 {
-		return fmt.Errorf("We require a non-empty slice of expected_texts")
+		for _, t := range checkTypes {
+			if !isChangeCheckType(t) {
+				return fmt.Errorf("We require a non-empty slice of expected_texts unless every URL uses the list, diff, diff_html or visual check type")
+			}
+		}
 	}
 
-	if len(urls) != len(waitSelectors) {
+	if len(waitSelectors) != 0 && len(urls) != len(waitSelectors) {
 		return fmt.Errorf("Number of URLs and wait_selectors passed in must have the same length")
 	}
 	if len(urls) != len(checkSelectors) {
@@ -984,10 +1235,82 @@ func CommonWatchChecks(cmd *cobra.Command) error {
 	if len(urls) != len(checkTypes) {
 		return fmt.Errorf("Number of URLs and check_types passed in must have the same length")
 	}
-	if len(urls) != len(expectedTexts) {
+	if len(expectedTexts) != 0 && len(urls) != len(expectedTexts) {
 		return fmt.Errorf("Number of URLs and expected_texts passed in must have the same length")
 	}
 
+	for i, t := range checkTypes {
+		if t != "json" {
+			continue
+		}
+		jsonURLPatterns := viper.GetStringSlice("json_url_patterns")
+		if len(urls) != len(jsonURLPatterns) {
+			return fmt.Errorf("Number of URLs and json_url_patterns passed in must have the same length when a json check type is used")
+		}
+		if len(jsonURLPatterns[i]) == 0 {
+			return fmt.Errorf("We require a non-empty json_url_pattern for URL [%s] since it uses the json check type", urls[i])
+		}
+		if _, err := regexp.Compile(jsonURLPatterns[i]); err != nil {
+			return fmt.Errorf("Invalid json_url_pattern [%s] for URL [%s]: %v", jsonURLPatterns[i], urls[i], err)
+		}
+	}
+	for i, t := range checkTypes {
+		if t != "js" {
+			continue
+		}
+		if err := CheckScript(checkSelectors[i]); err != nil {
+			return fmt.Errorf("Invalid js check selector for URL [%s]: %v", urls[i], err)
+		}
+	}
+	for i, t := range checkTypes {
+		if t != "document" {
+			continue
+		}
+		if err := checkDocumentSelector(checkSelectors[i]); err != nil {
+			return fmt.Errorf("Invalid document check selector for URL [%s]: %v", urls[i], err)
+		}
+	}
+
+	if err := checkWatchPagination(urls, checkTypes); err != nil {
+		return err
+	}
+
+	if _, err := diffIgnorePatterns(); err != nil {
+		return err
+	}
+
+	if err := checkVisualFlags(urls, checkTypes); err != nil {
+		return err
+	}
+
+	if err := checkSchedules(urls); err != nil {
+		return err
+	}
+
+	if viper.GetBool("watch_config") && len(viper.ConfigFileUsed()) == 0 {
+		return fmt.Errorf("We require a config file, passed with config or at its default path, to watch it with watch_config")
+	}
+
+	resourcePolicies := viper.GetStringSlice("resource_policies")
+	if len(resourcePolicies) != 0 {
+		if len(urls) != len(resourcePolicies) {
+			return fmt.Errorf("Number of URLs and resource_policies passed in must have the same length")
+		}
+		if err := checkResourcePolicies(resourcePolicies...); err != nil {
+			return err
+		}
+	}
+
+	devices := viper.GetStringSlice("devices")
+	if len(devices) != 0 {
+		if len(urls) != len(devices) {
+			return fmt.Errorf("Number of URLs and devices passed in must have the same length")
+		}
+		if err := checkDevices(devices...); err != nil {
+			return err
+		}
+	}
+
 	if viper.GetBool("detect_captcha_box") {
 		captchaWaitSelectors := viper.GetStringSlice("captcha_wait_selectors")
 		if len(captchaWaitSelectors) == 0 {
@@ -1040,6 +1363,18 @@ func PrintContent(cmd *cobra.Command) {
 	if len(id) != 0 {
 		Log().Infof("Will dump data for id selector: [%s]", id)
 	}
+	eval := viper.GetString("eval")
+	if len(eval) != 0 {
+		Log().Infof("Will print the JSON encoded result of script: [%s]", eval)
+	}
+	spec := fetchPaginationSpec()
+	if len(spec.itemSelector) != 0 {
+		Log().Infof("Will print items for item selector [%s] as JSON lines, using pagination [%s] for up to [%d] pages", spec.itemSelector, spec.mode, spec.maxPages)
+	}
+	includeHeaders := viper.GetBool("include_headers")
+	if includeHeaders {
+		Log().Info("Will print the redirects, status line and headers of the main document before the content")
+	}
 
 	detectAccessDeniedOn := viper.GetBool("detect_access_denied")
 	if detectAccessDeniedOn {
@@ -1073,36 +1408,90 @@ func PrintContent(cmd *cobra.Command) {
 	actionGens := make([][]actionGenerator, 0)
 	actionGens = append(actionGens, make([]actionGenerator, 0))
 
+	actionGens[0] = append(actionGens[0], blockActions{url: u, policy: targetResourcePolicy(nil, 0)})
+	actionGens[0] = append(actionGens[0], deviceActions{url: u, device: targetDevice(nil, 0)})
 	actionGens[0] = append(actionGens[0], navigateActions{url: u})
 	actionGens[0] = append(actionGens[0], detectActions{url: u, detectAccessDenied: detectAccessDeniedOn, detectCaptchaBox: detectCaptchaBoxOn, captchaWaitSelector: captchaWaitSelector, captchaClickSelector: captchaClickSelector, captchaIframeWaitSelector: captchaIframeWaitSelector, captchaClickSleep: captchaClickSleep, dumpOnError: errorDump, locationOnError: errorLocation, dumpToRedis: redisDumpOn})
 	actionGens[0] = append(actionGens[0], waitActions{url: u, waitSelector: w, dumpOnError: errorDump, locationOnError: errorLocation, dumpToRedis: redisDumpOn})
-	actionGens[0] = append(actionGens[0], dumpActions{postActionData: fetchDumps, textSelector: t, hrefSelector: h, idSelector: id, url: u})
+	if len(spec.itemSelector) != 0 {
+		actionGens[0] = append(actionGens[0], paginateActions{url: u, spec: spec, listing: &itemListing{}, postActionData: fetchDumps})
+	} else {
+		actionGens[0] = append(actionGens[0], dumpActions{postActionData: fetchDumps, textSelector: t, hrefSelector: h, idSelector: id, evalScript: eval, url: u})
+	}
 
 	f := executors["fetch"].(*fetchExecutor)
 	f.Init(actionGens, []string{u})
 	f.Execute()
+	agents().flush()
 	if err := <-f.errs; err == nil {
 		data := <-fetchDumps
+		if includeHeaders {
+			fmt.Print(runInfoFromContext(data.Ctx).document.headerBlock())
+		}
 		fmt.Printf(data.ExtractText)
 	}
 }
 
-// EmailContent will watch content and take action if content is available
-func EmailContent(cmd *cobra.Command) {
+// EmailContent will watch content and take action if content is available, returning the exit status
+func EmailContent(cmd *cobra.Command) int {
 	viper.BindPFlags(cmd.Flags())
 
 	subject := viper.GetString("subject")
 	from := viper.GetString("from")
 	to := viper.GetString("to")
 
-	urls := viper.GetStringSlice("urls")
-	waitSelectors := viper.GetStringSlice("wait_selectors")
-	password := viper.GetString("email_password")
-
 	Log().Infof("Using email subject: [%s]", subject)
 	Log().Infof("Using from email: [%s]", from)
 	Log().Infof("Using to email: [%s]", to)
 
+	// the redis worker is only started here, so a reload can't turn the dumps on or off
+	redisDumpOn := viper.GetBool("redis_dumps")
+	if redisDumpOn {
+		setupRedis(cmd)
+	}
+
+	emailMetaData := startEmailWorker()
+	build := func() ([][]actionGenerator, []string, error) {
+		return watchTargets(emailMetaData, nil, redisDumpOn)
+	}
+	actionGens, urls, err := build()
+	if err != nil {
+		Log().Errorf("%v", err)
+		return 1
+	}
+
+	e := executors["watch"].(*watchExecutor)
+	e.Init(actionGens, urls)
+	e.reloadWith(func() error { return CommonWatchChecks(cmd) }, build)
+	e.Execute() // blocks
+	return e.code
+}
+
+// startEmailWorker starts sending the emails put on the channel it returns
+func startEmailWorker() chan emailData {
+	emailMetaData := make(chan emailData, workerQueueSize)
+	postAction := emailWatchFunc{
+		fromEmail:      viper.GetString("from"),
+		toEmail:        viper.GetString("to"),
+		toSubject:      viper.GetString("subject"),
+		senderPassword: viper.GetString("email_password"),
+	}
+	go func() {
+		for {
+			data := <-emailMetaData
+			postAction.sendEmail(data)
+			handled()
+		}
+	}()
+
+	return emailMetaData
+}
+
+// watchTargets builds the actions for each URL of the watch from the config, notifying on whichever of the email or Discord channels is set
+func watchTargets(emailMetaData chan emailData, discordMetaData chan discordData, redisDumpOn bool) ([][]actionGenerator, []string, error) {
+	urls := viper.GetStringSlice("urls")
+	waitSelectors := viper.GetStringSlice("wait_selectors")
+
 	Log().Infof("Watching URLs: [%v]", urls)
 	Log().Infof("Waiting on selectors: [%v]", waitSelectors)
 
@@ -1143,11 +1532,6 @@ func EmailContent(cmd *cobra.Command) {
 		Log().Info("Will log the current URL location on wait errors")
 	}
 
-	redisDumpOn := viper.GetBool("redis_dumps")
-	if redisDumpOn {
-		setupRedis(cmd)
-	}
-
 	checkSelectors := viper.GetStringSlice("check_selectors")
 	checkTypes := viper.GetStringSlice("check_types")
 	expectedTexts := viper.GetStringSlice("expected_texts")
@@ -1156,19 +1540,27 @@ func EmailContent(cmd *cobra.Command) {
 	Log().Infof("Using check_types: [%v]", checkTypes)
 	Log().Infof("Using expected_texts: [%v]", expectedTexts)
 
-	emailMetaData := make(chan emailData)
-	postAction := emailWatchFunc{
-		fromEmail:      from,
-		toEmail:        to,
-		toSubject:      subject,
-		senderPassword: password,
+	jsonURLPatterns := viper.GetStringSlice("json_url_patterns")
+	jsonResponseTimeout := viper.GetInt("json_response_timeout")
+	if len(jsonURLPatterns) != 0 {
+		Log().Infof("Using json_url_patterns: [%v]", jsonURLPatterns)
+	}
+
+	resourcePolicies := viper.GetStringSlice("resource_policies")
+	if len(resourcePolicies) != 0 {
+		Log().Infof("Using resource_policies: [%v]", resourcePolicies)
+	}
+
+	devices := viper.GetStringSlice("devices")
+	if len(devices) != 0 {
+		Log().Infof("Using devices: [%v]", devices)
+	}
+
+	itemSelectors := viper.GetStringSlice("item_selectors")
+	paginations := viper.GetStringSlice("paginations")
+	if len(itemSelectors) != 0 {
+		Log().Infof("Using item_selectors: [%v] and paginations: [%v]", itemSelectors, paginations)
 	}
-	go func() {
-		for {
-			data := <-emailMetaData
-			postAction.sendEmail(data)
-		}
-	}()
 
 	actionGens := make([][]actionGenerator, 0)
 	for i := 0; i < len(urls); i++ {
@@ -1181,41 +1573,75 @@ func EmailContent(cmd *cobra.Command) {
 		capClickSelector := captchaClickSelector
 		capIframeWaitSelector := captchaIframeWaitSelector
 
-		overrideCapWaitSelector := captchaOverrideWaitSelectors[i]
-		overrideCapClickSelector := captchaOverrideClickSelectors[i]
-		overrideCapIframeWaitSelector := captchaOverrideIframeWaitSelectors[i]
-		if len(overrideCapWaitSelector) != 0 {
-			capWaitSelector = overrideCapWaitSelector
+		if len(captchaOverrideWaitSelectors) > i && len(captchaOverrideWaitSelectors[i]) != 0 {
+			capWaitSelector = captchaOverrideWaitSelectors[i]
 			Log().Infof("Using override captcha wait selector [%s] for URL [%s]", capWaitSelector, u)
 		}
-		if len(overrideCapClickSelector) != 0 {
-			capClickSelector = overrideCapClickSelector
+		if len(captchaOverrideClickSelectors) > i && len(captchaOverrideClickSelectors[i]) != 0 {
+			capClickSelector = captchaOverrideClickSelectors[i]
 			Log().Infof("Using override captcha click selector [%s] for URL [%s]", capClickSelector, u)
 		}
-		if len(overrideCapIframeWaitSelector) != 0 {
-			capIframeWaitSelector = overrideCapIframeWaitSelector
+		if len(captchaOverrideIframeWaitSelectors) > i && len(captchaOverrideIframeWaitSelectors[i]) != 0 {
+			capIframeWaitSelector = captchaOverrideIframeWaitSelectors[i]
 			Log().Infof("Using override iframe wait selector [%s] for URL [%s]", capIframeWaitSelector, u)
 		}
-		if len(notifyPaths) != 0 {
+		if len(notifyPaths) > i {
 			notifyPath = notifyPaths[i]
 		}
 
+		if checkTypes[i] == "json" {
+			capture, err := newCaptureActions(u, jsonURLPatterns[i], jsonResponseTimeout)
+			if err != nil {
+				return nil, nil, err
+			}
+			actionGens[i] = append(actionGens[i], capture)
+		}
+
+		actionGens[i] = append(actionGens[i], blockActions{url: u, policy: targetResourcePolicy(resourcePolicies, i)})
+
+		actionGens[i] = append(actionGens[i], deviceActions{url: u, device: targetDevice(devices, i)})
+
 		actionGens[i] = append(actionGens[i], navigateActions{url: u})
 
-		actionGens[i] = append(actionGens[i], detectActions{url: u, detectAccessDenied: detectAccessDeniedOn, detectCaptchaBox: detectCaptchaBoxOn, captchaWaitSelector: capWaitSelector, captchaClickSelector: capClickSelector, captchaIframeWaitSelector: capIframeWaitSelector, captchaClickSleep: captchaClickSleep, dumpOnError: errorDump, locationOnError: errorLocation, dumpToRedis: redisDumpOn, notifyPath: notifyPath, postActionEmail: emailMetaData, detectNotifyPath: detectNotifyPath})
+		actionGens[i] = append(actionGens[i], detectActions{url: u, detectAccessDenied: detectAccessDeniedOn, detectCaptchaBox: detectCaptchaBoxOn, captchaWaitSelector: capWaitSelector, captchaClickSelector: capClickSelector, captchaIframeWaitSelector: capIframeWaitSelector, captchaClickSleep: captchaClickSleep, dumpOnError: errorDump, locationOnError: errorLocation, dumpToRedis: redisDumpOn, notifyPath: notifyPath, postActionEmail: emailMetaData, postActionDiscord: discordMetaData, detectNotifyPath: detectNotifyPath})
 
-		actionGens[i] = append(actionGens[i], waitActions{url: u, waitSelector: waitSelectors[i], dumpOnError: errorDump, locationOnError: errorLocation, dumpToRedis: redisDumpOn})
+		// the json check waits for its network response instead of anything on the page
+		if checkTypes[i] != "json" {
+			actionGens[i] = append(actionGens[i], waitActions{url: u, waitSelector: waitSelectors[i], dumpOnError: errorDump, locationOnError: errorLocation, dumpToRedis: redisDumpOn})
+		}
 
-		e := emailActions{postActionData: emailMetaData, url: u}
-		if checkSelectors != nil && expectedTexts != nil {
-			e.checkSelector = checkSelectors[i]
-			e.checkType = checkTypes[i]
-			e.expectedText = expectedTexts[i]
+		listing := &itemListing{}
+		spec := watchPaginationSpec(i)
+		actionGens[i] = append(actionGens[i], paginateActions{url: u, spec: spec, listing: listing})
+
+		var expectedText string
+		if len(expectedTexts) > i {
+			expectedText = expectedTexts[i]
+		}
+		sources := checkSources{listing: listing}
+		switch checkTypes[i] {
+		case "list":
+			sources.change = listDiffCheck{url: u, spec: spec, listing: listing}
+		case "diff", "diff_html":
+			diff, err := newRegionDiffCheck(u, checkSelectors[i], strings.TrimPrefix(checkTypes[i], "diff_"))
+			if err != nil {
+				return nil, nil, err
+			}
+			sources.change = diff
+		case "visual":
+			visual, err := newVisualDiffCheck(u, checkSelectors[i], i)
+			if err != nil {
+				return nil, nil, err
+			}
+			sources.change = visual
+		}
+
+		if discordMetaData != nil {
+			actionGens[i] = append(actionGens[i], discordActions{postActionData: discordMetaData, url: u, checkSelector: checkSelectors[i], checkType: checkTypes[i], expectedText: expectedText, sources: sources})
+		} else {
+			actionGens[i] = append(actionGens[i], emailActions{postActionData: emailMetaData, url: u, checkSelector: checkSelectors[i], checkType: checkTypes[i], expectedText: expectedText, sources: sources})
 		}
-		actionGens[i] = append(actionGens[i], e)
 	}
 
-	e := executors["watch"].(*watchExecutor)
-	e.Init(actionGens, urls)
-	e.Execute() // blocks
+	return actionGens, urls, nil
 }
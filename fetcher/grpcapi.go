@@ -0,0 +1,291 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/vishnraj/go-scraper/scraperpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer is the gRPC API of serve, it works on the targets of the watch the same way as the REST API
+type grpcServer struct {
+	scraperpb.UnimplementedScraperServer
+	s *watchServer
+}
+
+// serveGRPC starts the gRPC API on the address and returns what stops it
+func (s *watchServer) serveGRPC(address string) (func(), error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on [%s]: %v", address, err)
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(s.authorizeUnary), grpc.StreamInterceptor(s.authorizeStream))
+	scraperpb.RegisterScraperServer(srv, &grpcServer{s: s})
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			Log().Errorf("The gRPC API stopped serving: %v", err)
+		}
+	}()
+	Log().Infof("Serving the gRPC API at [%s]", ln.Addr())
+
+	return func() {
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(serveStopTimeout):
+			srv.Stop()
+		}
+	}, nil
+}
+
+// authorize checks the bearer token of the call, the same one the REST API takes
+func (s *watchServer) authorize(ctx context.Context) error {
+	if len(s.token) == 0 {
+		return nil
+	}
+	var auth string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) != 0 {
+		auth = md.Get("authorization")[0]
+	}
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.token)) != 1 {
+		return status.Error(codes.Unauthenticated, "A valid bearer token is required")
+	}
+	return nil
+}
+
+func (s *watchServer) authorizeUnary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *watchServer) authorizeStream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (g *grpcServer) Fetch(ctx context.Context, in *scraperpb.FetchRequest) (*scraperpb.FetchResponse, error) {
+	req := &fetchRequest{URL: in.Url, WaitSelector: in.WaitSelector, HTML: in.Html, Screenshot: in.Screenshot, Timeout: int(in.Timeout)}
+	if len(in.Fields) != 0 {
+		req.Fields = map[string]fetchField{}
+		for name, f := range in.Fields {
+			req.Fields[name] = fetchField{Selector: f.GetSelector(), Type: f.GetType(), Script: f.GetScript()}
+		}
+	}
+	for _, step := range in.Steps {
+		req.Steps = append(req.Steps, pageStep{Action: step.Action, Selector: step.Selector, Value: step.Value, Seconds: step.Seconds})
+	}
+
+	resp, err := g.s.runFetch(ctx, req)
+	if err != nil {
+		if resp != nil {
+			return nil, status.Errorf(codes.Unavailable, "Failed to fetch URL [%s]: %v", in.Url, err)
+		}
+		return nil, grpcError(err)
+	}
+
+	return &scraperpb.FetchResponse{Url: resp.URL, FinalUrl: resp.FinalURL, Status: resp.Status, Fields: resp.Fields, Html: resp.HTML, Screenshot: resp.Screenshot, Duration: durationpb.New(resp.duration)}, nil
+}
+
+func (g *grpcServer) ListTargets(ctx context.Context, _ *scraperpb.ListTargetsRequest) (*scraperpb.ListTargetsResponse, error) {
+	resp := &scraperpb.ListTargetsResponse{}
+	g.s.mu.Lock()
+	for _, t := range g.s.targets {
+		v, _ := g.s.view(t.ID)
+		resp.Targets = append(resp.Targets, targetToProto(v))
+	}
+	g.s.mu.Unlock()
+
+	return resp, nil
+}
+
+func (g *grpcServer) UpsertTarget(ctx context.Context, in *scraperpb.UpsertTargetRequest) (*scraperpb.Target, error) {
+	if in.Target == nil {
+		return nil, status.Error(codes.InvalidArgument, "A target is required")
+	}
+	t := targetFromProto(in.Target)
+	if err := t.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(t.ID) == 0 {
+		t.ID = newTargetID()
+	}
+
+	updated := false
+	err := g.s.change(func(targets []*serveTarget) ([]*serveTarget, error) {
+		for i, o := range targets {
+			if o.ID == t.ID {
+				targets[i] = t
+				updated = true
+				return targets, nil
+			}
+		}
+		return append(targets, t), nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if updated {
+		Log().Infof("Updated target [%s] for URL [%s] through the API", t.ID, t.URL)
+	} else {
+		Log().Infof("Added target [%s] for URL [%s] through the API", t.ID, t.URL)
+	}
+
+	g.s.mu.Lock()
+	v, ok := g.s.view(t.ID)
+	g.s.mu.Unlock()
+	if !ok {
+		// deleted in the meantime
+		return nil, status.Errorf(codes.NotFound, "No target [%s]", t.ID)
+	}
+	return targetToProto(v), nil
+}
+
+func (g *grpcServer) DeleteTarget(ctx context.Context, in *scraperpb.DeleteTargetRequest) (*scraperpb.DeleteTargetResponse, error) {
+	if err := g.s.deleteTarget(in.Id); err != nil {
+		return nil, grpcError(err)
+	}
+	return &scraperpb.DeleteTargetResponse{}, nil
+}
+
+// WatchEvents streams the finished runs until the client goes away or the watch stops
+func (g *grpcServer) WatchEvents(in *scraperpb.WatchEventsRequest, stream grpc.ServerStreamingServer[scraperpb.WatchEvent]) error {
+	ids := map[string]bool{}
+	for _, id := range in.TargetIds {
+		ids[id] = true
+	}
+
+	events, unsubscribe := g.s.subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-g.s.l.stop.Done():
+			return status.Error(codes.Unavailable, "The watch is stopping")
+		case ev := <-events:
+			if len(ids) != 0 && !ids[ev.ID] || !in.AllRuns && !ev.Notified {
+				continue
+			}
+			err := stream.Send(&scraperpb.WatchEvent{
+				TargetId: ev.ID, Url: ev.URL, State: ev.State, Notified: ev.Notified, Value: ev.Value, Error: ev.Error,
+				HttpStatus: ev.HTTPStatus, Time: timestamppb.New(ev.Time), Duration: durationpb.New(ev.Duration),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcError turns an error of the API into the gRPC status of its code
+func grpcError(err error) error {
+	var e *apiError
+	if !errors.As(err, &e) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	code := codes.Internal
+	switch e.code {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, e.msg)
+}
+
+func targetFromProto(p *scraperpb.Target) *serveTarget {
+	return &serveTarget{
+		ID:                        p.Id,
+		URL:                       p.Url,
+		WaitSelector:              p.WaitSelector,
+		CheckSelector:             p.CheckSelector,
+		CheckType:                 p.CheckType,
+		ExpectedText:              p.ExpectedText,
+		JSONURLPattern:            p.JsonUrlPattern,
+		ItemSelector:              p.ItemSelector,
+		ItemKey:                   p.ItemKey,
+		ItemFields:                p.ItemFields,
+		Pagination:                p.Pagination,
+		NextSelector:              p.NextSelector,
+		PageURLTemplate:           p.PageUrlTemplate,
+		ResourcePolicy:            p.ResourcePolicy,
+		Device:                    p.Device,
+		VisualIgnoreRegions:       p.VisualIgnoreRegions,
+		NotifyPath:                p.NotifyPath,
+		CaptchaWaitSelector:       p.CaptchaWaitSelector,
+		CaptchaClickSelector:      p.CaptchaClickSelector,
+		CaptchaIframeWaitSelector: p.CaptchaIframeWaitSelector,
+		Interval:                  int(p.Interval),
+		Schedule:                  p.Schedule,
+		ActiveHours:               p.ActiveHours,
+		Paused:                    p.Paused,
+	}
+}
+
+func targetToProto(v *targetView) *scraperpb.Target {
+	t := v.serveTarget
+	st := &scraperpb.TargetStatus{State: v.Status.State, Value: v.Status.Value, Notified: v.Status.Notified, Error: v.Status.Error, HttpStatus: v.Status.HTTPStatus,
+		Failures: int32(v.Status.Failures), Agent: v.Status.Agent, Proxy: v.Status.Proxy}
+	if v.Status.LastRun != nil {
+		st.LastRun = timestamppb.New(*v.Status.LastRun)
+	}
+	if d, err := time.ParseDuration(v.Status.Duration); err == nil {
+		st.Duration = durationpb.New(d)
+	}
+	if v.Status.NextRun != nil {
+		st.NextRun = timestamppb.New(*v.Status.NextRun)
+	}
+
+	return &scraperpb.Target{
+		Id:                        t.ID,
+		Url:                       t.URL,
+		WaitSelector:              t.WaitSelector,
+		CheckSelector:             t.CheckSelector,
+		CheckType:                 t.CheckType,
+		ExpectedText:              t.ExpectedText,
+		JsonUrlPattern:            t.JSONURLPattern,
+		ItemSelector:              t.ItemSelector,
+		ItemKey:                   t.ItemKey,
+		ItemFields:                t.ItemFields,
+		Pagination:                t.Pagination,
+		NextSelector:              t.NextSelector,
+		PageUrlTemplate:           t.PageURLTemplate,
+		ResourcePolicy:            t.ResourcePolicy,
+		Device:                    t.Device,
+		VisualIgnoreRegions:       t.VisualIgnoreRegions,
+		NotifyPath:                t.NotifyPath,
+		CaptchaWaitSelector:       t.CaptchaWaitSelector,
+		CaptchaClickSelector:      t.CaptchaClickSelector,
+		CaptchaIframeWaitSelector: t.CaptchaIframeWaitSelector,
+		Interval:                  int32(t.Interval),
+		Schedule:                  t.Schedule,
+		ActiveHours:               t.ActiveHours,
+		Paused:                    t.Paused,
+		Status:                    st,
+	}
+}
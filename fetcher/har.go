@@ -0,0 +1,437 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// DefaultHarMaxBodySize default max size (bytes) of a response body stored in a HAR entry
+	DefaultHarMaxBodySize = 512 * 1024
+
+	harVersion     = "1.2"
+	harCreatorName = "go-scraper"
+)
+
+// harFile is the top level HAR 1.2 document
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Pages   []harPage   `json:"pages"`
+	Entries []*harEntry `json:"entries"`
+	Comment string      `json:"comment,omitempty"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPage struct {
+	StartedDateTime string         `json:"startedDateTime"`
+	ID              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     harPageTimings `json:"pageTimings"`
+}
+
+type harPageTimings struct {
+	OnContentLoad float64 `json:"onContentLoad"`
+	OnLoad        float64 `json:"onLoad"`
+}
+
+type harEntry struct {
+	Pageref         string      `json:"pageref,omitempty"`
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+
+	// only used while recording, to compute the total time of the entry
+	start time.Time
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harRecorder listens to the network events of a single run and turns them into HAR entries
+type harRecorder struct {
+	targetURL     string
+	includeBodies bool
+	maxBodySize   int
+
+	mu      sync.Mutex
+	started time.Time
+	entries []*harEntry
+	current map[network.RequestID]*harEntry
+
+	bodies sync.WaitGroup
+}
+
+func newHarRecorder(targetURL string, includeBodies bool, maxBodySize int) *harRecorder {
+	return &harRecorder{
+		targetURL:     targetURL,
+		includeBodies: includeBodies,
+		maxBodySize:   maxBodySize,
+		started:       time.Now(),
+		current:       map[network.RequestID]*harEntry{},
+	}
+}
+
+// listen attaches the recorder to the chromedp context - this can be called before the first run on the context
+func (h *harRecorder) listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			h.onRequest(ev)
+		case *network.EventResponseReceived:
+			h.onResponse(ev)
+		case *network.EventLoadingFinished:
+			h.onFinished(ctx, ev)
+		case *network.EventLoadingFailed:
+			h.onFailed(ev)
+		}
+	})
+}
+
+func (h *harRecorder) onRequest(ev *network.EventRequestWillBeSent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// redirects re-use the request id, so the previous hop is completed with the redirect response
+	if prev, ok := h.current[ev.RequestID]; ok && ev.RedirectResponse != nil {
+		prev.Response = newHarResponse(ev.RedirectResponse)
+		prev.Response.RedirectURL = ev.Request.URL
+		prev.Time = msSince(prev.start, monotonic(ev.Timestamp))
+	}
+
+	start := monotonic(ev.Timestamp)
+	wall := time.Now()
+	if ev.WallTime != nil {
+		wall = ev.WallTime.Time()
+	}
+
+	e := &harEntry{
+		Pageref:         "page_1",
+		StartedDateTime: wall.Format(time.RFC3339Nano),
+		Request:         newHarRequest(ev.Request),
+		Response:        harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		Timings:         harTimings{Send: 0, Wait: -1, Receive: -1},
+		start:           start,
+	}
+	h.entries = append(h.entries, e)
+	h.current[ev.RequestID] = e
+}
+
+func (h *harRecorder) onResponse(ev *network.EventResponseReceived) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.current[ev.RequestID]
+	if !ok {
+		return
+	}
+	e.Response = newHarResponse(ev.Response)
+	e.ServerIPAddress = ev.Response.RemoteIPAddress
+	e.Timings.Wait = msSince(e.start, monotonic(ev.Timestamp))
+}
+
+func (h *harRecorder) onFinished(ctx context.Context, ev *network.EventLoadingFinished) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.current[ev.RequestID]
+	if !ok {
+		return
+	}
+	e.Time = msSince(e.start, monotonic(ev.Timestamp))
+	if e.Timings.Wait >= 0 {
+		e.Timings.Receive = e.Time - e.Timings.Wait
+	}
+	e.Response.BodySize = int64(ev.EncodedDataLength)
+
+	if !h.includeBodies {
+		return
+	}
+
+	// we can't issue commands from inside the listener, so the body is fetched on the side
+	h.bodies.Add(1)
+	go func(id network.RequestID) {
+		defer h.bodies.Done()
+
+		c := chromedp.FromContext(ctx)
+		if c == nil || c.Target == nil {
+			return
+		}
+		body, err := network.GetResponseBody(id).Do(cdp.WithExecutor(ctx, c.Target))
+		if err != nil {
+			Log().Debugf("Could not get response body for request [%s] for URL [%s]: %v", id, h.targetURL, err)
+			return
+		}
+		h.setBody(id, body)
+	}(ev.RequestID)
+}
+
+func (h *harRecorder) onFailed(ev *network.EventLoadingFailed) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.current[ev.RequestID]
+	if !ok {
+		return
+	}
+	e.Time = msSince(e.start, monotonic(ev.Timestamp))
+	e.Comment = ev.ErrorText
+	if len(ev.BlockedReason) != 0 {
+		e.Comment += " (blocked: " + ev.BlockedReason.String() + ")"
+	}
+}
+
+func (h *harRecorder) setBody(id network.RequestID, body []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.current[id]
+	if !ok {
+		return
+	}
+	e.Response.Content.Size = int64(len(body))
+	if h.maxBodySize > 0 && len(body) > h.maxBodySize {
+		body = body[:h.maxBodySize]
+		e.Response.Content.Comment = fmt.Sprintf("Body truncated to %d bytes", h.maxBodySize)
+	}
+	if utf8.Valid(body) {
+		e.Response.Content.Text = string(body)
+	} else {
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		e.Response.Content.Encoding = "base64"
+	}
+}
+
+// write waits for any in-flight body fetches and then writes out the HAR file into dir
+func (h *harRecorder) write(dir string, runErr error) (string, error) {
+	h.bodies.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]*harEntry, len(h.entries))
+	copy(entries, h.entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].start.Before(entries[j].start)
+	})
+
+	f := harFile{
+		Log: harLog{
+			Version: harVersion,
+			Creator: harCreator{Name: harCreatorName, Version: harVersion},
+			Pages: []harPage{{
+				StartedDateTime: h.started.Format(time.RFC3339Nano),
+				ID:              "page_1",
+				Title:           h.targetURL,
+				PageTimings:     harPageTimings{OnContentLoad: -1, OnLoad: -1},
+			}},
+			Entries: entries,
+		},
+	}
+	if runErr != nil {
+		f.Log.Comment = runErr.Error()
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, harFileName(h.targetURL, h.started))
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// finishHar writes the HAR file for a run, respecting the har_on_error_only option
+func finishHar(h *harRecorder, config runConfig, runErr error) {
+	if runErr == nil && config.harOnErrorOnly {
+		Log().Debugf("Run for URL [%s] succeeded, so skipping HAR file", h.targetURL)
+		return
+	}
+
+	path, err := h.write(config.harDir, runErr)
+	if err != nil {
+		Log().Errorf("Failed to write HAR file for URL [%s]: %v", h.targetURL, err)
+		return
+	}
+	Log().Infof("Wrote HAR file [%s] for URL [%s]", path, h.targetURL)
+}
+
+func newHarRequest(r *network.Request) harRequest {
+	req := harRequest{
+		Method:      r.Method,
+		URL:         r.URL + r.URLFragment,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(r.Headers),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+	if u, err := url.Parse(r.URL); err == nil {
+		for k, vs := range u.Query() {
+			for _, v := range vs {
+				req.QueryString = append(req.QueryString, harNameValue{Name: k, Value: v})
+			}
+		}
+	}
+	if r.HasPostData {
+		var text string
+		for _, p := range r.PostDataEntries {
+			if b, err := base64.StdEncoding.DecodeString(p.Bytes); err == nil {
+				text += string(b)
+			}
+		}
+		req.PostData = &harPostData{MimeType: headerValue(r.Headers, "Content-Type"), Text: text}
+		req.BodySize = int64(len(text))
+	}
+
+	return req
+}
+
+func newHarResponse(r *network.Response) harResponse {
+	httpVersion := strings.ToUpper(r.Protocol)
+	if len(httpVersion) == 0 {
+		httpVersion = "HTTP/1.1"
+	}
+
+	return harResponse{
+		Status:      r.Status,
+		StatusText:  r.StatusText,
+		HTTPVersion: httpVersion,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(r.Headers),
+		Content:     harContent{Size: -1, MimeType: r.MimeType},
+		RedirectURL: headerValue(r.Headers, "Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+}
+
+func harHeaders(headers network.Headers) []harNameValue {
+	res := make([]harNameValue, 0, len(headers))
+	for k, v := range headers {
+		res = append(res, harNameValue{Name: k, Value: fmt.Sprintf("%v", v)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+func headerValue(headers network.Headers, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return fmt.Sprintf("%v", v)
+		}
+	}
+	return ""
+}
+
+func monotonic(t *cdp.MonotonicTime) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.Time()
+}
+
+func msSince(start time.Time, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return -1
+	}
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+
+func harFileName(targetURL string, started time.Time) string {
+	host := targetURL
+	if u, err := url.Parse(targetURL); err == nil && len(u.Host) != 0 {
+		host = u.Host
+	}
+	host = strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, host)
+
+	return strconv.FormatInt(started.UnixNano(), 10) + "-" + host + ".har"
+}
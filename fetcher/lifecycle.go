@@ -0,0 +1,225 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apsdehal/go-logger"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultShutdownTimeout default time (seconds) a stopping watch waits for the runs in flight and the queued notifications and dumps
+	DefaultShutdownTimeout = 30
+
+	// workerQueueSize is how many notifications or dumps a worker can have queued before more are dropped
+	workerQueueSize = 64
+)
+
+var (
+	// gPending counts the notifications and dumps handed to a worker that it hasn't handled yet
+	gPending sync.WaitGroup
+
+	// gPendingMu guards adding to gPending, which stops once draining is set at shutdown
+	gPendingMu sync.Mutex
+	gDraining  bool
+)
+
+// enqueue hands the data to the worker reading the channel without holding up the run - it is dropped if the queue of the worker is full or the watch is shutting down
+// the worker must call handled once it is done with it
+func enqueue[T any](ch chan<- T, data T) {
+	gPendingMu.Lock()
+	defer gPendingMu.Unlock()
+
+	if gDraining {
+		Log().Errorf("Dropping a notification or dump queued after the watch started shutting down")
+		return
+	}
+	gPending.Add(1)
+	select {
+	case ch <- data:
+	default:
+		gPending.Done()
+		Log().Errorf("Dropping a notification or dump since [%d] are already queued for its worker", workerQueueSize)
+	}
+}
+
+// handled marks data a worker took off its channel as done, so shutdown stops waiting for it
+func handled() {
+	gPending.Done()
+}
+
+// watchLifecycle turns the signals a watch gets into cancellation and config reloads
+type watchLifecycle struct {
+	// stop is done once we are asked to stop, after which no run is started
+	stop context.Context
+	// runs is what the runs are derived from, it is done once the shutdown deadline passed so the browsers are closed
+	runs       context.Context
+	cancelRuns context.CancelFunc
+
+	signals chan os.Signal
+	reload  chan struct{}
+	timeout time.Duration
+
+	// config is the content of the config file the watch runs with, put back when a reloaded one is rejected
+	config []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// newWatchLifecycle starts handling SIGINT and SIGTERM (stop) and SIGHUP (reload the config), and watches the config file if watch_config is set
+func newWatchLifecycle() *watchLifecycle {
+	stop, stopped := context.WithCancel(context.Background())
+	runs, cancelRuns := context.WithCancel(context.Background())
+	l := &watchLifecycle{
+		stop:       stop,
+		runs:       runs,
+		cancelRuns: cancelRuns,
+		signals:    make(chan os.Signal, 1),
+		reload:     make(chan struct{}, 1),
+		timeout:    time.Duration(viper.GetInt("shutdown_timeout")) * time.Second,
+	}
+	if path := viper.ConfigFileUsed(); len(path) != 0 {
+		l.config, _ = os.ReadFile(path)
+	}
+	signal.Notify(l.signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	if viper.GetBool("watch_config") {
+		if err := l.watchConfigFile(); err != nil {
+			Log().Errorf("%v", err)
+		}
+	}
+
+	go func() {
+		for sig := range l.signals {
+			if sig == syscall.SIGHUP {
+				Log().Infof("Received [%s], reloading the config", sig)
+				l.requestReload()
+				continue
+			}
+
+			if l.stop.Err() != nil {
+				Log().Errorf("Received [%s] again, giving up on the runs in flight and the queued notifications", sig)
+				l.cancelRuns()
+				continue
+			}
+
+			l.mu.Lock()
+			l.deadline = time.Now().Add(l.timeout)
+			l.mu.Unlock()
+			Log().Infof("Received [%s], stopping the watch - waiting up to [%s] for the runs in flight and the queued notifications and dumps", sig, l.timeout)
+			stopped()
+			time.AfterFunc(l.timeout, func() {
+				if l.runs.Err() == nil {
+					Log().Errorf("Shutdown deadline of [%s] passed, closing the browsers of the runs in flight", l.timeout)
+					l.cancelRuns()
+				}
+			})
+		}
+	}()
+
+	return l
+}
+
+// requestReload asks the watch to reload the config before its next run
+func (l *watchLifecycle) requestReload() {
+	select {
+	case l.reload <- struct{}{}:
+	default:
+		// a reload is already waiting to happen
+	}
+}
+
+// shutdown waits for the queued notifications and dumps until the deadline and returns the exit status - 0 if everything was drained
+func (l *watchLifecycle) shutdown() int {
+	signal.Stop(l.signals)
+
+	// nothing is queued from here on, so the wait can't race new work
+	gPendingMu.Lock()
+	gDraining = true
+	gPendingMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		gPending.Wait()
+		close(drained)
+	}()
+
+	l.mu.Lock()
+	if l.deadline.IsZero() {
+		l.deadline = time.Now().Add(l.timeout)
+	}
+	remaining := time.Until(l.deadline)
+	l.mu.Unlock()
+
+	code := 0
+	select {
+	case <-drained:
+		if l.runs.Err() != nil {
+			code = 1
+		}
+	case <-l.runs.Done():
+		code = 1
+	case <-time.After(remaining):
+		code = 1
+	}
+	l.cancelRuns()
+	agents().flush()
+
+	if code != 0 {
+		Log().Errorf("Watch stopped without finishing its work in time, some notifications or dumps may be lost")
+	} else {
+		Log().Info("Watch stopped cleanly")
+	}
+	return code
+}
+
+// reloadConfig reads the config file again and, if it passes the check, applies what can change while a watch runs - the log level, rate limits, backoffs, robots policy, proxy pool, the agents or fingerprint profiles and the devices
+// a config that fails is rejected, and the one the watch runs with is put back
+func (l *watchLifecycle) reloadConfig(check func() error) error {
+	path := viper.ConfigFileUsed()
+	if len(path) == 0 {
+		return fmt.Errorf("No config file was loaded at start up, so there is nothing to reload")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to reload config file [%s]: %v", path, err)
+	}
+	if err = viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		err = fmt.Errorf("Failed to reload config file [%s]: %v", path, err)
+	} else {
+		// the profiles and devices files are read again before the check, so the names are checked against what they hold now
+		profiles := reloadFingerprintProfiles()
+		devices := reloadDevices()
+		if err = check(); err != nil {
+			restoreFingerprintProfiles(profiles)
+			restoreDevices(devices)
+		}
+	}
+	if err != nil {
+		if e := viper.ReadConfig(bytes.NewReader(l.config)); e != nil {
+			Log().Errorf("Failed to put back the previous config: %v", e)
+		}
+		return err
+	}
+	l.config = data
+
+	if viper.GetString("log_level") == "DEBUG" {
+		Log().SetLogLevel(logger.DebugLevel)
+	} else {
+		Log().SetLogLevel(logger.InfoLevel)
+	}
+	limits().reload()
+	reloadRobots()
+	reloadProxies()
+	agents().reload()
+
+	Log().Infof("Reloaded config file [%s]", path)
+	return nil
+}
@@ -0,0 +1,105 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	listStateKeyPrefix = "list-seen-"
+)
+
+// listDiffCheck notifies with the items of a listing that were added or removed since the previous run
+type listDiffCheck struct {
+	url     string
+	spec    paginationSpec
+	listing *itemListing
+}
+
+func (l listDiffCheck) detect(ctx context.Context) (*changeNotice, error) {
+	current := map[string]string{}
+	for _, it := range l.listing.snapshot() {
+		k := it.Key
+		if len(k) == 0 {
+			k = it.Href
+		}
+		if len(k) == 0 {
+			Log().Debugf("Skipping item [%s] for URL [%s] since it has no key", it.Text, l.url)
+			continue
+		}
+		current[k] = it.display(l.spec.fields)
+	}
+
+	// a page that failed to render its listing would otherwise report every seen item as removed, and then all of them as added once it renders again
+	if len(current) == 0 {
+		Log().Errorf("Found no items for list URL [%s], keeping the items seen so far until a run finds some", l.url)
+		return nil, nil
+	}
+
+	// listings of the same page are told apart by their items and what identifies them
+	key := checkStateKey(listStateKeyPrefix, l.url, l.spec.itemSelector, l.spec.keyAttr)
+	raw, found, err := state().get(key)
+	if err != nil {
+		Log().Errorf("Failed to load seen items for URL [%s]: %v", l.url, err)
+		return nil, err
+	}
+	previous := map[string]string{}
+	if found {
+		if err = json.Unmarshal([]byte(raw), &previous); err != nil {
+			Log().Errorf("Seen items for URL [%s] are corrupt, starting over: %v", l.url, err)
+			found = false
+		}
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	if err = state().set(key, string(data)); err != nil {
+		Log().Errorf("Failed to save seen items for URL [%s]: %v", l.url, err)
+		return nil, err
+	}
+
+	if !found {
+		Log().Infof("First run for list URL [%s], recorded [%d] items as the baseline", l.url, len(current))
+		return nil, nil
+	}
+
+	added := diffKeys(current, previous)
+	removed := diffKeys(previous, current)
+	Log().Infof("For list URL [%s] found [%d] items, [%d] added and [%d] removed since the last check", l.url, len(current), len(added), len(removed))
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	if len(added) != 0 {
+		fmt.Fprintf(&b, "Added %d item(s):\n", len(added))
+		for _, k := range added {
+			fmt.Fprintf(&b, "+ %s\n", current[k])
+		}
+	}
+	if len(removed) != 0 {
+		fmt.Fprintf(&b, "Removed %d item(s):\n", len(removed))
+		for _, k := range removed {
+			fmt.Fprintf(&b, "- %s\n", previous[k])
+		}
+	}
+
+	return &changeNotice{text: b.String()}, nil
+}
+
+// diffKeys returns the sorted keys of a that are not in b
+func diffKeys(a map[string]string, b map[string]string) []string {
+	var res []string
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,358 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// NextPagination clicks the next_selector to get to the next page
+	NextPagination = "next"
+
+	// URLPagination navigates to the page_url_template with the page number filled in
+	URLPagination = "url"
+
+	// ScrollPagination scrolls to the bottom of the page until no new items appear
+	ScrollPagination = "scroll"
+
+	// DefaultPaginationMaxPages default max number of pages walked for a listing
+	DefaultPaginationMaxPages = 10
+
+	// DefaultPageWait default time (seconds) we sleep after moving to the next page, to allow its items to load
+	DefaultPageWait = 2
+
+	// pageNumberPlaceholder is replaced by the page number in a page_url_template
+	pageNumberPlaceholder = "{page}"
+
+	// itemFieldSeparator separates the field selectors of an item, since commas already separate the URLs
+	itemFieldSeparator = "|"
+)
+
+// listItem is a single item of a listing page
+type listItem struct {
+	Page   int               `json:"page"`
+	Key    string            `json:"key,omitempty"`
+	Text   string            `json:"text"`
+	Href   string            `json:"href,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// paginationSpec describes how to walk the pages of a listing and which items to extract from them
+type paginationSpec struct {
+	mode         string
+	itemSelector string
+	nextSelector string
+	urlTemplate  string
+	keyAttr      string   // href, text or the attribute that identifies an item
+	fields       []string // selectors, relative to the item, of the fields shown for an item
+	maxPages     int
+	pageWait     time.Duration
+}
+
+// itemListing holds the items extracted across all pages of a listing during a single run
+type itemListing struct {
+	mu    sync.Mutex
+	items []listItem
+}
+
+// paginateActions extracts the items from every page of a listing, following the pagination spec
+type paginateActions struct {
+	url     string
+	spec    paginationSpec
+	listing *itemListing
+
+	postActionData chan dumpData // only set for fetch, to print out the items
+}
+
+func (l *itemListing) set(items []listItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items = items
+}
+
+func (l *itemListing) snapshot() []listItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := make([]listItem, len(l.items))
+	copy(res, l.items)
+	return res
+}
+
+// text joins the text of all items, one per line, for checks against an expected text
+func (l *itemListing) text() string {
+	var b strings.Builder
+	for _, it := range l.snapshot() {
+		b.WriteString(it.Text)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// ndjson writes one JSON object per item, one per line
+func (l *itemListing) ndjson() string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for _, it := range l.snapshot() {
+		enc.Encode(it)
+	}
+	return b.String()
+}
+
+func (p paginateActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
+	if len(p.spec.itemSelector) == 0 {
+		return actions
+	}
+
+	actions = append(actions,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			p.listing.set(nil)
+
+			var all []listItem
+			seen := map[string]bool{}
+			for page := 1; page <= p.spec.maxPages; page++ {
+				if page > 1 {
+					more, err := p.nextPage(ctx, page)
+					if err != nil {
+						Log().Errorf("%v", err)
+						return err
+					}
+					if !more {
+						break
+					}
+				}
+
+				items, err := extractItems(ctx, p.spec)
+				if err != nil {
+					Log().Errorf("%v", err)
+					return err
+				}
+
+				added := 0
+				for _, it := range items {
+					k := it.Key
+					if len(k) == 0 {
+						k = it.Href + "\x00" + it.Text
+					}
+					if seen[k] {
+						continue
+					}
+					seen[k] = true
+					it.Page = page
+					all = append(all, it)
+					added++
+				}
+				Log().Infof("Found [%d] items, [%d] of them new, on page [%d] for URL [%s]", len(items), added, page, p.url)
+
+				if len(p.spec.mode) == 0 {
+					break
+				}
+				if added == 0 && page > 1 {
+					Log().Infof("No new items on page [%d] for URL [%s], so we are done paginating", page, p.url)
+					break
+				}
+				if page == p.spec.maxPages {
+					Log().Infof("Reached max pages [%d] for URL [%s]", p.spec.maxPages, p.url)
+				}
+			}
+
+			p.listing.set(all)
+			Log().Infof("Extracted [%d] items in total for URL [%s]", len(all), p.url)
+
+			if p.postActionData != nil {
+				var currentURL string
+				if err := chromedp.Location(&currentURL).Do(ctx); err != nil {
+					Log().Errorf("%v", err)
+					return err
+				}
+				data := dumpData{URL: currentURL, ExtractText: p.listing.ndjson(), Ctx: ctx}
+				go func() {
+					p.postActionData <- data
+				}()
+			}
+
+			return nil
+		}))
+
+	return actions
+}
+
+// nextPage moves to the given page and reports false when there are no more pages
+func (p paginateActions) nextPage(ctx context.Context, page int) (bool, error) {
+	switch p.spec.mode {
+	case NextPagination:
+		var available bool
+		err := chromedp.Evaluate(fmt.Sprintf(`(() => {
+			const e = document.querySelector(%s);
+			return !!e && !e.disabled && e.getAttribute('aria-disabled') !== 'true';
+		})()`, jsString(p.spec.nextSelector)), &available).Do(ctx)
+		if err != nil {
+			return false, err
+		}
+		if !available {
+			Log().Infof("No next selector [%s] available on page [%d] for URL [%s], so we are done paginating", p.spec.nextSelector, page-1, p.url)
+			return false, nil
+		}
+		Log().Infof("Clicking next selector [%s] to get to page [%d] for URL [%s]", p.spec.nextSelector, page, p.url)
+		if err = chromedp.Click(p.spec.nextSelector, chromedp.ByQuery).Do(ctx); err != nil {
+			return false, err
+		}
+	case URLPagination:
+		u := strings.ReplaceAll(p.spec.urlTemplate, pageNumberPlaceholder, strconv.Itoa(page))
+		Log().Infof("Navigating to page [%d] at [%s] for URL [%s]", page, u, p.url)
+		if err := chromedp.Navigate(u).Do(ctx); err != nil {
+			return false, err
+		}
+	case ScrollPagination:
+		Log().Infof("Scrolling to the bottom to load page [%d] for URL [%s]", page, p.url)
+		if err := chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight)`, nil).Do(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	if err := chromedp.Sleep(p.spec.pageWait).Do(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func extractItems(ctx context.Context, spec paginationSpec) ([]listItem, error) {
+	fields, _ := json.Marshal(spec.fields)
+
+	var items []listItem
+	err := chromedp.Evaluate(fmt.Sprintf(`(() => {
+		const keyAttr = %s, fields = %s;
+		const text = e => e ? (e.innerText || e.textContent || '').trim() : '';
+		return Array.from(document.querySelectorAll(%s)).map(e => {
+			const a = e.matches('a[href]') ? e : e.querySelector('a[href]');
+			const item = {text: text(e), href: a ? a.href : '', fields: {}};
+			if (keyAttr === 'href') {
+				item.key = item.href;
+			} else if (keyAttr === 'text') {
+				item.key = item.text;
+			} else if (keyAttr) {
+				const k = e.hasAttribute(keyAttr) ? e : e.querySelector('[' + keyAttr + ']');
+				item.key = k ? k.getAttribute(keyAttr) : '';
+			}
+			fields.forEach(f => { item.fields[f] = text(e.querySelector(f)); });
+			return item;
+		});
+	})()`, jsString(spec.keyAttr), fields, jsString(spec.itemSelector)), &items).Do(ctx)
+
+	return items, err
+}
+
+// display is how an item is shown in notifications - its fields, in the given order, if we have any, otherwise its text
+func (it listItem) display(fields []string) string {
+	var parts []string
+	for _, f := range fields {
+		if v := it.Fields[f]; len(v) != 0 {
+			parts = append(parts, v)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, strings.Join(strings.Fields(it.Text), " "))
+	}
+	if len(it.Href) != 0 {
+		parts = append(parts, it.Href)
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// jsString quotes a string so it can be embedded in a script
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// CheckPaginationSpec validates the pagination options for a single URL
+func CheckPaginationSpec(u string, mode string, itemSelector string, nextSelector string, urlTemplate string) error {
+	switch mode {
+	case "":
+	case NextPagination:
+		if len(nextSelector) == 0 {
+			return fmt.Errorf("Pagination [%s] for URL [%s] requires a next_selector", mode, u)
+		}
+	case URLPagination:
+		if !strings.Contains(urlTemplate, pageNumberPlaceholder) {
+			return fmt.Errorf("Pagination [%s] for URL [%s] requires a page_url_template containing [%s]", mode, u, pageNumberPlaceholder)
+		}
+	case ScrollPagination:
+	default:
+		return fmt.Errorf("Unknown pagination [%s] for URL [%s] - must be one of [%s], [%s] or [%s]", mode, u, NextPagination, URLPagination, ScrollPagination)
+	}
+	if len(mode) != 0 && len(itemSelector) == 0 {
+		return fmt.Errorf("Pagination [%s] for URL [%s] requires an item_selector", mode, u)
+	}
+
+	return nil
+}
+
+// newPaginationSpec builds a pagination spec, where fields are the item field selectors separated by |
+func newPaginationSpec(mode string, itemSelector string, nextSelector string, urlTemplate string, keyAttr string, fields string) paginationSpec {
+	spec := paginationSpec{
+		mode:         mode,
+		itemSelector: itemSelector,
+		nextSelector: nextSelector,
+		urlTemplate:  urlTemplate,
+		keyAttr:      keyAttr,
+		maxPages:     viper.GetInt("pagination_max_pages"),
+		pageWait:     time.Duration(viper.GetInt("page_wait")) * time.Second,
+	}
+	for _, f := range strings.Split(fields, itemFieldSeparator) {
+		if f = strings.TrimSpace(f); len(f) != 0 {
+			spec.fields = append(spec.fields, f)
+		}
+	}
+
+	return spec
+}
+
+// fetchPaginationSpec builds the pagination spec from the fetch flags
+func fetchPaginationSpec() paginationSpec {
+	return newPaginationSpec(viper.GetString("pagination"), viper.GetString("item_selector"), viper.GetString("next_selector"), viper.GetString("page_url_template"), viper.GetString("item_key"), viper.GetString("item_fields"))
+}
+
+// watchPaginationSpec builds the pagination spec for the URL at index i from the per URL watch slices
+func watchPaginationSpec(i int) paginationSpec {
+	at := func(key string) string {
+		s := viper.GetStringSlice(key)
+		if len(s) > i {
+			return s[i]
+		}
+		return ""
+	}
+
+	return newPaginationSpec(at("paginations"), at("item_selectors"), at("next_selectors"), at("page_url_templates"), at("item_keys"), at("item_fields"))
+}
+
+// checkWatchPagination validates the per URL item and pagination slices for watch
+func checkWatchPagination(urls []string, checkTypes []string) error {
+	for _, name := range []string{"item_selectors", "paginations", "next_selectors", "page_url_templates", "item_keys", "item_fields"} {
+		s := viper.GetStringSlice(name)
+		if len(s) != 0 && len(s) != len(urls) {
+			return fmt.Errorf("Number of URLs and %s passed in must have the same length", name)
+		}
+	}
+
+	for i, u := range urls {
+		spec := watchPaginationSpec(i)
+		if err := CheckPaginationSpec(u, spec.mode, spec.itemSelector, spec.nextSelector, spec.urlTemplate); err != nil {
+			return err
+		}
+		if len(checkTypes) > i && (checkTypes[i] == "items" || checkTypes[i] == "list") && len(spec.itemSelector) == 0 {
+			return fmt.Errorf("Check type %s for URL [%s] requires an item_selector", checkTypes[i], u)
+		}
+	}
+
+	return nil
+}
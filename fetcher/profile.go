@@ -0,0 +1,277 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+var (
+	// profilePresets built in fingerprint profiles, each one consistent with a common real browser
+	profilePresets = map[string]*fingerprintProfile{
+		"windows-chrome": {
+			UserAgent:     `Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36`,
+			Platform:      "Win32",
+			Languages:     []string{"en-US", "en"},
+			Width:         1920,
+			Height:        1080,
+			DeviceScale:   1,
+			Timezone:      "America/New_York",
+			Locale:        "en-US",
+			WebGLVendor:   "Google Inc. (NVIDIA)",
+			WebGLRenderer: "ANGLE (NVIDIA, NVIDIA GeForce GTX 1660 SUPER Direct3D11 vs_5_0 ps_5_0, D3D11)",
+		},
+		"mac-chrome": {
+			UserAgent:     `Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36`,
+			Platform:      "MacIntel",
+			Languages:     []string{"en-US", "en"},
+			Width:         1440,
+			Height:        900,
+			DeviceScale:   2,
+			Timezone:      "America/Los_Angeles",
+			Locale:        "en-US",
+			WebGLVendor:   "Google Inc. (Apple)",
+			WebGLRenderer: "ANGLE (Apple, Apple M1, OpenGL 4.1)",
+		},
+		"linux-chrome": {
+			UserAgent:     `Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36`,
+			Platform:      "Linux x86_64",
+			Languages:     []string{"en-GB", "en"},
+			Width:         1366,
+			Height:        768,
+			DeviceScale:   1,
+			Timezone:      "Europe/London",
+			Locale:        "en-GB",
+			WebGLVendor:   "Google Inc. (Intel)",
+			WebGLRenderer: "ANGLE (Intel, Mesa Intel(R) UHD Graphics 620 (KBL GT2), OpenGL 4.6)",
+		},
+	}
+
+	gProfiles       profileSet
+	gProfilesLoaded bool
+	gProfilesMu     sync.Mutex
+)
+
+// profileSet is the fingerprint profiles by name, or why they couldn't be loaded
+type profileSet struct {
+	all map[string]*fingerprintProfile
+	err error
+}
+
+// fingerprintProfile is everything a page can see about the browser, applied together so the pieces don't contradict each other
+type fingerprintProfile struct {
+	Name          string   `json:"name"`
+	UserAgent     string   `json:"user_agent"`
+	Platform      string   `json:"platform"`
+	Languages     []string `json:"languages"`
+	Width         int64    `json:"width"`
+	Height        int64    `json:"height"`
+	DeviceScale   float64  `json:"device_scale"`
+	Timezone      string   `json:"timezone"`
+	Locale        string   `json:"locale"`
+	WebGLVendor   string   `json:"webgl_vendor"`
+	WebGLRenderer string   `json:"webgl_renderer"`
+}
+
+// fingerprintProfiles returns the preset profiles together with the ones from the profiles_file, by name
+func fingerprintProfiles() (map[string]*fingerprintProfile, error) {
+	gProfilesMu.Lock()
+	defer gProfilesMu.Unlock()
+
+	if !gProfilesLoaded {
+		gProfiles = loadFingerprintProfiles()
+		gProfilesLoaded = true
+	}
+	return gProfiles.all, gProfiles.err
+}
+
+// reloadFingerprintProfiles reads the profiles_file again and returns the profiles it replaced, so a config that is rejected can put them back
+func reloadFingerprintProfiles() profileSet {
+	gProfilesMu.Lock()
+	defer gProfilesMu.Unlock()
+
+	previous := gProfiles
+	gProfiles = loadFingerprintProfiles()
+	gProfilesLoaded = true
+	return previous
+}
+
+func restoreFingerprintProfiles(previous profileSet) {
+	gProfilesMu.Lock()
+	defer gProfilesMu.Unlock()
+
+	gProfiles = previous
+}
+
+func loadFingerprintProfiles() profileSet {
+	res := profileSet{all: map[string]*fingerprintProfile{}}
+	for name, p := range profilePresets {
+		c := *p
+		c.Name = name
+		res.all[name] = &c
+	}
+
+	path := viper.GetString("profiles_file")
+	if len(path) == 0 {
+		return res
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		res.err = fmt.Errorf("Failed to read profiles_file [%s]: %v", path, err)
+		return res
+	}
+	var custom []*fingerprintProfile
+	if err = json.Unmarshal(data, &custom); err != nil {
+		res.err = fmt.Errorf("Failed to parse profiles_file [%s], it must be a JSON array of profiles: %v", path, err)
+		return res
+	}
+	for _, p := range custom {
+		if err = p.check(); err != nil {
+			res.err = fmt.Errorf("Invalid profile in profiles_file [%s]: %v", path, err)
+			return res
+		}
+		res.all[p.Name] = p
+	}
+
+	return res
+}
+
+// runProfile returns the profile for the name the agent manager picked, nil if we are rotating plain user-agents
+func runProfile(name string) *fingerprintProfile {
+	if len(viper.GetStringSlice("profiles")) == 0 {
+		return nil
+	}
+	profiles, _ := fingerprintProfiles()
+	return profiles[name]
+}
+
+// checkProfiles validates the profiles flags
+func checkProfiles() error {
+	profiles, err := fingerprintProfiles()
+	if err != nil {
+		return err
+	}
+	for _, name := range viper.GetStringSlice("profiles") {
+		if _, ok := profiles[name]; !ok {
+			return fmt.Errorf("Unknown profile [%s] - must be one of the presets [windows-chrome], [mac-chrome] or [linux-chrome] or defined in the profiles_file", name)
+		}
+	}
+
+	return nil
+}
+
+func (p *fingerprintProfile) check() error {
+	if len(p.Name) == 0 {
+		return fmt.Errorf("Every profile requires a name")
+	}
+	if len(p.UserAgent) == 0 {
+		return fmt.Errorf("Profile [%s] requires a user_agent", p.Name)
+	}
+	if p.Width <= 0 || p.Height <= 0 {
+		return fmt.Errorf("Profile [%s] requires a positive width and height", p.Name)
+	}
+	if p.DeviceScale < 0 {
+		return fmt.Errorf("Profile [%s] can't have a negative device_scale", p.Name)
+	}
+
+	return nil
+}
+
+// acceptLanguage builds the Accept-Language header for the languages, in order of preference
+func (p *fingerprintProfile) acceptLanguage() string {
+	var parts []string
+	for i, l := range p.Languages {
+		if i == 0 {
+			parts = append(parts, l)
+			continue
+		}
+		q := 1 - 0.1*float64(i)
+		if q < 0.1 {
+			q = 0.1
+		}
+		parts = append(parts, fmt.Sprintf("%s;q=%.1f", l, q))
+	}
+	return strings.Join(parts, ",")
+}
+
+// script overrides what the emulation domain doesn't cover, before any script of the page runs
+func (p *fingerprintProfile) script() string {
+	data, _ := json.Marshal(p)
+	return fmt.Sprintf(`(() => {
+		const p = %s;
+		const define = (o, k, v) => Object.defineProperty(o, k, {get: () => v, configurable: true});
+		if (p.platform) {
+			define(Navigator.prototype, 'platform', p.platform);
+		}
+		if (p.languages && p.languages.length) {
+			define(Navigator.prototype, 'languages', Object.freeze(p.languages.slice()));
+			define(Navigator.prototype, 'language', p.languages[0]);
+		}
+		if (p.webgl_vendor || p.webgl_renderer) {
+			for (const c of [self.WebGLRenderingContext, self.WebGL2RenderingContext]) {
+				if (!c) {
+					continue;
+				}
+				const getParameter = c.prototype.getParameter;
+				c.prototype.getParameter = function (n) {
+					if (n === 0x9245 && p.webgl_vendor) {
+						return p.webgl_vendor;
+					}
+					if (n === 0x9246 && p.webgl_renderer) {
+						return p.webgl_renderer;
+					}
+					return getParameter.call(this, n);
+				};
+			}
+		}
+	})()`, data)
+}
+
+// emulate applies the profile to the page, it must run before we navigate
+func (p *fingerprintProfile) emulate(targetURL string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		Log().Infof("Using fingerprint profile [%s] for URL [%s]", p.Name, targetURL)
+
+		ua := emulation.SetUserAgentOverride(p.UserAgent).WithPlatform(p.Platform)
+		if len(p.Languages) != 0 {
+			ua = ua.WithAcceptLanguage(p.acceptLanguage())
+		}
+		if err := ua.Do(ctx); err != nil {
+			return err
+		}
+
+		scale := p.DeviceScale
+		if scale == 0 {
+			scale = 1
+		}
+		err := emulation.SetDeviceMetricsOverride(p.Width, p.Height, scale, false).
+			WithScreenWidth(p.Width).
+			WithScreenHeight(p.Height).
+			Do(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(p.Timezone) != 0 {
+			if err = emulation.SetTimezoneOverride(p.Timezone).Do(ctx); err != nil {
+				return fmt.Errorf("Failed to set timezone [%s] of profile [%s]: %v", p.Timezone, p.Name, err)
+			}
+		}
+		if len(p.Locale) != 0 {
+			if err = emulation.SetLocaleOverride().WithLocale(p.Locale).Do(ctx); err != nil {
+				return fmt.Errorf("Failed to set locale [%s] of profile [%s]: %v", p.Locale, p.Name, err)
+			}
+		}
+
+		_, err = page.AddScriptToEvaluateOnNewDocument(p.script()).Do(ctx)
+		return err
+	})
+}
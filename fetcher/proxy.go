@@ -0,0 +1,433 @@
+package fetcher
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// RoundRobinProxyRotation uses each proxy of the pool in turn
+	RoundRobinProxyRotation = "round-robin"
+
+	// RandomProxyRotation picks a random proxy of the pool for each run
+	RandomProxyRotation = "random"
+
+	// StickyProxyRotation keeps using the same proxy for a target until it goes bad
+	StickyProxyRotation = "sticky"
+
+	// DefaultProxyCooldown default time (seconds) a bad proxy is left out of the rotation
+	DefaultProxyCooldown = 300
+
+	// DefaultProxyHealthCheckInterval default time (seconds) between health checks of the pool
+	DefaultProxyHealthCheckInterval = 60
+)
+
+var (
+	gProxies       *proxyPool
+	gProxiesLoaded bool
+	gProxiesMu     sync.Mutex
+
+	// proxyErrors are the page load errors that mean the proxy, rather than the target, failed us
+	proxyErrors = []string{
+		"net::ERR_PROXY_CONNECTION_FAILED",
+		"net::ERR_TUNNEL_CONNECTION_FAILED",
+		"net::ERR_SOCKS_CONNECTION_FAILED",
+		"net::ERR_PROXY_AUTH_UNSUPPORTED",
+		"net::ERR_PROXY_CERTIFICATE_INVALID",
+		"net::ERR_NO_SUPPORTED_PROXIES",
+		"net::ERR_TIMED_OUT",
+		"net::ERR_CONNECTION_TIMED_OUT",
+	}
+)
+
+// proxyEntry is a single proxy of the pool
+type proxyEntry struct {
+	url *url.URL
+
+	badAt    time.Time
+	badUntil time.Time
+	failures int
+}
+
+// proxyPool rotates runs across the configured proxies and leaves out the ones that went bad until their cooldown is over
+type proxyPool struct {
+	mu       sync.Mutex
+	proxies  []*proxyEntry
+	rotation string
+	cooldown time.Duration
+	next     int
+	sticky   map[string]*proxyEntry
+
+	// closed to stop the health checks of the pool, nil if they aren't running
+	healthStop chan struct{}
+}
+
+// proxies returns the configured proxy pool, creating it on first use - nil if no proxies are configured
+func proxies() *proxyPool {
+	gProxiesMu.Lock()
+	defer gProxiesMu.Unlock()
+
+	if !gProxiesLoaded {
+		gProxies = proxiesFromFlags(nil)
+		gProxiesLoaded = true
+	}
+	return gProxies
+}
+
+// reloadProxies picks up changed proxy flags - the proxies that stay in the pool keep their failures and cooldown
+func reloadProxies() {
+	gProxiesMu.Lock()
+	defer gProxiesMu.Unlock()
+
+	gProxies = proxiesFromFlags(gProxies)
+	gProxiesLoaded = true
+}
+
+// proxiesFromFlags returns the pool for the proxy flags, updating the current one if there is one
+func proxiesFromFlags(current *proxyPool) *proxyPool {
+	urls, err := proxyURLs()
+	if err != nil {
+		// the flags are validated in the common checks, so this shouldn't happen
+		Log().Errorf("%v", err)
+		return current
+	}
+	if current != nil {
+		current.stopHealthChecks()
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	rotation := viper.GetString("proxy_rotation")
+	if len(rotation) == 0 {
+		rotation = RoundRobinProxyRotation
+	}
+	p := current
+	if p == nil {
+		p = &proxyPool{sticky: map[string]*proxyEntry{}}
+	}
+	p.update(urls, rotation, time.Duration(viper.GetInt("proxy_cooldown"))*time.Second)
+	Log().Infof("Using a pool of [%d] proxies with [%s] rotation", len(urls), rotation)
+
+	if checkURL := viper.GetString("proxy_health_check_url"); len(checkURL) != 0 {
+		p.startHealthChecks(checkURL, time.Duration(viper.GetInt("proxy_health_check_interval"))*time.Second)
+	}
+
+	return p
+}
+
+// update sets the proxies and rotation of the pool, keeping the entries of the proxies it already had
+func (p *proxyPool) update(urls []*url.URL, rotation string, cooldown time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := map[string]*proxyEntry{}
+	for _, e := range p.proxies {
+		existing[e.url.String()] = e
+	}
+	kept := map[*proxyEntry]bool{}
+	p.proxies = nil
+	for _, u := range urls {
+		e, ok := existing[u.String()]
+		if !ok {
+			e = &proxyEntry{url: u}
+		}
+		kept[e] = true
+		p.proxies = append(p.proxies, e)
+	}
+	// targets stuck to a proxy that was dropped pick another one on their next run
+	for target, e := range p.sticky {
+		if !kept[e] {
+			delete(p.sticky, target)
+		}
+	}
+	p.rotation = rotation
+	p.cooldown = cooldown
+}
+
+// proxyURLs collects the proxies from proxy_url, proxy_urls and proxy_file
+func proxyURLs() ([]*url.URL, error) {
+	raw := viper.GetStringSlice("proxy_urls")
+	if u := viper.GetString("proxy_url"); len(u) != 0 {
+		raw = append([]string{u}, raw...)
+	}
+	if path := viper.GetString("proxy_file"); len(path) != 0 {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open proxy_file [%s]: %v", path, err)
+		}
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if len(line) != 0 && !strings.HasPrefix(line, "#") {
+				raw = append(raw, line)
+			}
+		}
+		if err = s.Err(); err != nil {
+			return nil, fmt.Errorf("Failed to read proxy_file [%s]: %v", path, err)
+		}
+	}
+
+	var res []*url.URL
+	seen := map[string]bool{}
+	for _, r := range raw {
+		u, err := parseProxyURL(r)
+		if err != nil {
+			return nil, err
+		}
+		if seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		res = append(res, u)
+	}
+
+	return res, nil
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL [%s]: %v", raw, err)
+	}
+	if len(u.Host) == 0 || len(u.Port()) == 0 {
+		return nil, fmt.Errorf("Proxy URL [%s] must contain host:port", u.Redacted())
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "socks5":
+		// chrome can't authenticate against a SOCKS proxy
+		if u.User != nil {
+			return nil, fmt.Errorf("Proxy URL [%s] has credentials, which are not supported for socks5 proxies", u.Redacted())
+		}
+	default:
+		return nil, fmt.Errorf("Proxy URL [%s] must use one of the schemes [http], [https] or [socks5]", u.Redacted())
+	}
+
+	return u, nil
+}
+
+// checkProxyFlags validates the proxy flags
+func checkProxyFlags() error {
+	switch viper.GetString("proxy_rotation") {
+	case "", RoundRobinProxyRotation, RandomProxyRotation, StickyProxyRotation:
+	default:
+		return fmt.Errorf("Unknown proxy_rotation [%s] - must be one of [%s], [%s] or [%s]", viper.GetString("proxy_rotation"), RoundRobinProxyRotation, RandomProxyRotation, StickyProxyRotation)
+	}
+	if checkURL := viper.GetString("proxy_health_check_url"); len(checkURL) != 0 {
+		if _, err := url.ParseRequestURI(checkURL); err != nil {
+			return fmt.Errorf("Invalid proxy_health_check_url [%s]: %v", checkURL, err)
+		}
+	}
+
+	_, err := proxyURLs()
+	return err
+}
+
+// pick returns the proxy to use for a run against the target URL
+func (p *proxyPool) pick(targetURL string) *proxyEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.rotation == StickyProxyRotation {
+		if e, ok := p.sticky[targetURL]; ok && !e.badUntil.After(now) {
+			return e
+		}
+	}
+
+	var available []*proxyEntry
+	for _, e := range p.proxies {
+		if !e.badUntil.After(now) {
+			available = append(available, e)
+		}
+	}
+
+	var e *proxyEntry
+	switch {
+	case len(available) == 0:
+		// everything is cooling down, so go with the one that is closest to being usable again
+		e = p.proxies[0]
+		for _, c := range p.proxies[1:] {
+			if c.badUntil.Before(e.badUntil) {
+				e = c
+			}
+		}
+		Log().Warningf("All [%d] proxies are cooling down, using [%s] which is the first to come back at [%s]", len(p.proxies), e.url.Redacted(), e.badUntil.Format(time.RFC3339))
+	case p.rotation == RandomProxyRotation:
+		e = available[rand.Intn(len(available))]
+	default:
+		e = available[p.next%len(available)]
+		p.next++
+	}
+
+	if p.rotation == StickyProxyRotation {
+		p.sticky[targetURL] = e
+	}
+
+	return e
+}
+
+// markBad leaves the proxy out of the rotation for the cooldown
+func (p *proxyPool) markBad(e *proxyEntry, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e.failures++
+	e.badAt = time.Now()
+	e.badUntil = e.badAt.Add(p.cooldown)
+	Log().Errorf("Proxy [%s] went bad (%s) after [%d] consecutive failures, leaving it out of the rotation until [%s]", e.url.Redacted(), reason, e.failures, e.badUntil.Format(time.RFC3339))
+}
+
+func (p *proxyPool) markGood(e *proxyEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e.failures != 0 {
+		Log().Infof("Proxy [%s] is working again after [%d] failures", e.url.Redacted(), e.failures)
+	}
+	e.failures = 0
+	e.badUntil = time.Time{}
+}
+
+// report marks the proxy used for a run that started at the given time bad if the run failed because of it
+func (p *proxyPool) report(e *proxyEntry, started time.Time, err error) {
+	p.mu.Lock()
+	markedDuringRun := e.badAt.After(started)
+	p.mu.Unlock()
+	if markedDuringRun {
+		// something during the run, like an access denied page, already marked it
+		return
+	}
+
+	if err == nil {
+		p.markGood(e)
+		return
+	}
+	for _, pe := range proxyErrors {
+		if strings.Contains(err.Error(), pe) {
+			p.markBad(e, pe)
+			return
+		}
+	}
+}
+
+func (p *proxyPool) startHealthChecks(checkURL string, interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.healthStop = make(chan struct{})
+	go p.healthChecks(checkURL, interval, p.healthStop)
+}
+
+func (p *proxyPool) stopHealthChecks() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.healthStop != nil {
+		close(p.healthStop)
+		p.healthStop = nil
+	}
+}
+
+// healthChecks periodically requests the check URL through every proxy, so bad ones are found before a run uses them, until stop is closed
+func (p *proxyPool) healthChecks(checkURL string, interval time.Duration, stop chan struct{}) {
+	// a client for each proxy, kept for every check so their connections are reused
+	clients := map[*proxyEntry]*http.Client{}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		entries := append([]*proxyEntry(nil), p.proxies...)
+		p.mu.Unlock()
+
+		for _, e := range entries {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			client, ok := clients[e]
+			if !ok {
+				client = &http.Client{
+					Timeout:   interval,
+					Transport: &http.Transport{Proxy: http.ProxyURL(e.url)},
+				}
+				clients[e] = client
+			}
+			resp, err := client.Get(checkURL)
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode >= http.StatusBadRequest {
+					err = fmt.Errorf("health check returned [%s]", resp.Status)
+				}
+			}
+			if err != nil {
+				p.markBad(e, fmt.Sprintf("health check failed: %v", err))
+			} else {
+				Log().Debugf("Proxy [%s] passed its health check", e.url.Redacted())
+				p.markHealthy(e)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// markHealthy clears the failures of a proxy that passed its health check - one that went bad stays out of the rotation until its cooldown is over
+func (p *proxyPool) markHealthy(e *proxyEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Now().Before(e.badUntil) {
+		return
+	}
+	if e.failures != 0 {
+		Log().Infof("Proxy [%s] passed its health check after [%d] failures", e.url.Redacted(), e.failures)
+	}
+	e.failures = 0
+	e.badUntil = time.Time{}
+}
+
+// markProxyBad marks the proxy used for the run bad, for detections that happen during the run like access denied pages
+func markProxyBad(ctx context.Context, reason string) {
+	if e := runInfoFromContext(ctx).proxy; e != nil {
+		// a reload may have dropped the pool while the run was in flight
+		if p := proxies(); p != nil {
+			p.markBad(e, reason)
+		}
+	}
+}
+
+// setupProxyForChrome creates Chrome options for the proxy - credentials are answered through request interception
+func setupProxyForChrome(e *proxyEntry) []chromedp.ExecAllocatorOption {
+	if e == nil {
+		return nil // No proxy configuration needed
+	}
+
+	Log().Infof("Using proxy server [%s://%s] for Chrome", e.url.Scheme, e.url.Host)
+
+	return []chromedp.ExecAllocatorOption{
+		chromedp.ProxyServer(e.url.Scheme + "://" + e.url.Host),
+	}
+}
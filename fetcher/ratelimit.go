@@ -0,0 +1,235 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultRateBurst default number of requests a host can take at once before the rate_limit kicks in
+	DefaultRateBurst = 1
+
+	// DefaultBackoffAfter default number of failed runs in a row before a target backs off
+	DefaultBackoffAfter = 3
+
+	// DefaultBackoffBase default time (seconds) a target backs off for the first time
+	DefaultBackoffBase = 60
+
+	// DefaultMaxBackoff default max time (seconds) a target that keeps failing backs off for
+	DefaultMaxBackoff = 3600
+
+	// DefaultBackoffJitter default fraction of the backoff that is randomly added or taken away
+	DefaultBackoffJitter = 0.2
+)
+
+var (
+	gLimiter     *rateLimiter
+	gLimiterOnce sync.Once
+)
+
+// tokenBucket allows a steady rate of requests to a host, with bursts of up to its size
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// targetBackoff is the failure streak of a target and how long it stays away because of it
+type targetBackoff struct {
+	failures int
+	blocked  bool // a block rule asked for a backoff during the current run
+	delay    time.Duration
+	until    time.Time
+}
+
+// rateLimiter spaces out the runs against each host and backs off from targets that keep failing, it is shared by every run of the process
+type rateLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // requests per second for each host, zero means no limit
+	burst    float64
+	buckets  map[string]*tokenBucket
+	backoffs map[string]*targetBackoff
+
+	after  int
+	base   time.Duration
+	max    time.Duration
+	jitter float64
+
+	now func() time.Time // the clock, tests replace it
+}
+
+// limits returns the rate limiter, creating it from the flags on first use
+func limits() *rateLimiter {
+	gLimiterOnce.Do(func() {
+		gLimiter = &rateLimiter{
+			rate:     viper.GetFloat64("rate_limit"),
+			burst:    float64(viper.GetInt("rate_burst")),
+			buckets:  map[string]*tokenBucket{},
+			backoffs: map[string]*targetBackoff{},
+			after:    viper.GetInt("backoff_after"),
+			base:     time.Duration(viper.GetInt("backoff_base")) * time.Second,
+			max:      time.Duration(viper.GetInt("max_backoff")) * time.Second,
+			jitter:   viper.GetFloat64("backoff_jitter"),
+			now:      time.Now,
+		}
+		if gLimiter.burst < 1 {
+			gLimiter.burst = 1
+		}
+		if gLimiter.rate > 0 {
+			Log().Infof("Limiting runs to [%.2f] per second for each host, with bursts of up to [%d]", gLimiter.rate, int(gLimiter.burst))
+		}
+	})
+
+	return gLimiter
+}
+
+// reload picks up changed rate limit and backoff flags, keeping the buckets and backoffs as they are
+func (l *rateLimiter) reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = viper.GetFloat64("rate_limit")
+	l.burst = math.Max(1, float64(viper.GetInt("rate_burst")))
+	l.after = viper.GetInt("backoff_after")
+	l.base = time.Duration(viper.GetInt("backoff_base")) * time.Second
+	l.max = time.Duration(viper.GetInt("max_backoff")) * time.Second
+	l.jitter = viper.GetFloat64("backoff_jitter")
+}
+
+// checkRateLimitFlags validates the rate limit and backoff flags
+func checkRateLimitFlags() error {
+	if viper.GetFloat64("rate_limit") < 0 {
+		return fmt.Errorf("The rate_limit can't be negative")
+	}
+	if viper.GetInt("rate_burst") < 1 {
+		return fmt.Errorf("The rate_burst must be at least 1")
+	}
+	if viper.GetInt("backoff_after") < 1 {
+		return fmt.Errorf("The backoff_after must be at least 1")
+	}
+	if viper.GetInt("backoff_base") <= 0 || viper.GetInt("max_backoff") < viper.GetInt("backoff_base") {
+		return fmt.Errorf("The backoff_base must be positive and no larger than the max_backoff")
+	}
+	if j := viper.GetFloat64("backoff_jitter"); j < 0 || j >= 1 {
+		return fmt.Errorf("The backoff_jitter must be at least 0 and less than 1")
+	}
+
+	return nil
+}
+
+// wait blocks until the host of the target URL has a token for the run, or the context is done
+func (l *rateLimiter) wait(ctx context.Context, targetURL string) error {
+	host := hostOf(targetURL)
+	delay := l.reserve(host, robots().crawlDelay(targetURL))
+	if delay <= 0 {
+		return nil
+	}
+	Log().Infof("Rate limiting host [%s], waiting [%s] before the run for URL [%s]", host, delay.Round(time.Millisecond), targetURL)
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token from the bucket of the host and returns how long to wait before using it
+func (l *rateLimiter) reserve(host string, crawlDelay time.Duration) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rate, burst := l.rate, l.burst
+	// a Crawl-delay from robots.txt wins when it is slower than our own limit
+	if crawlDelay > 0 && (rate <= 0 || 1/crawlDelay.Seconds() < rate) {
+		rate, burst = 1/crawlDelay.Seconds(), 1
+	}
+	if rate <= 0 {
+		return 0
+	}
+	now := l.now()
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[host] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	// the token is taken right away, so runs that wait at the same time queue up behind each other
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// backingOff returns when the target may run again, if it is backing off
+func (l *rateLimiter) backingOff(targetURL string) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.backoffs[targetURL]
+	if !ok || !b.until.After(l.now()) {
+		return time.Time{}, false
+	}
+	return b.until, true
+}
+
+// block makes the current run of the target back off once it fails, however short its failure streak is
+func (l *rateLimiter) block(targetURL string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.backoff(targetURL).blocked = true
+}
+
+// record updates the failure streak of the target with the result of a run, backing off once it is long enough
+func (l *rateLimiter) record(targetURL string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.backoff(targetURL)
+	if err == nil {
+		if b.failures >= l.after || b.delay > 0 {
+			Log().Infof("Target [%s] succeeded after [%d] failures in a row, done backing off and back to its normal interval", targetURL, b.failures)
+		}
+		delete(l.backoffs, targetURL)
+		return
+	}
+
+	b.failures++
+	if b.failures < l.after && !b.blocked {
+		Log().Infof("Target [%s] failed [%d] times in a row, it will back off after [%d]", targetURL, b.failures, l.after)
+		return
+	}
+
+	// doubles with every failure from here on, up to the max
+	if b.delay == 0 {
+		b.delay = l.base
+	} else {
+		b.delay *= 2
+	}
+	if b.delay > l.max {
+		b.delay = l.max
+	}
+	delay := time.Duration(float64(b.delay) * (1 + l.jitter*(2*rand.Float64()-1)))
+	b.until = l.now().Add(delay)
+	b.blocked = false
+	Log().Warningf("Target [%s] failed [%d] times in a row, backing off for [%s] until [%s]", targetURL, b.failures, delay.Round(time.Second), b.until.Format(time.RFC3339))
+}
+
+// backoff returns the backoff state of the target, creating it if needed - must hold mu
+func (l *rateLimiter) backoff(targetURL string) *targetBackoff {
+	b, ok := l.backoffs[targetURL]
+	if !ok {
+		b = &targetBackoff{}
+		l.backoffs[targetURL] = b
+	}
+	return b
+}
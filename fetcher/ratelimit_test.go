@@ -0,0 +1,260 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// testLimiter returns a limiter on the clock that backs off after 3 failures, from 1 minute up to 5 minutes, without jitter
+func testLimiter(clock *fakeClock, rate float64, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:     rate,
+		burst:    burst,
+		buckets:  map[string]*tokenBucket{},
+		backoffs: map[string]*targetBackoff{},
+		after:    3,
+		base:     time.Minute,
+		max:      5 * time.Minute,
+		now:      clock.now,
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	// each step takes a token for the host after advancing the clock, and expects the wait
+	type step struct {
+		advance    time.Duration
+		host       string
+		crawlDelay time.Duration
+		want       time.Duration
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst float64
+		steps []step
+	}{
+		{
+			name: "no limit",
+			steps: []step{
+				{host: "a"},
+				{host: "a"},
+				{host: "a"},
+			},
+		},
+		{
+			name:  "burst then waiting in turn",
+			rate:  2,
+			burst: 3,
+			steps: []step{
+				{host: "a"},
+				{host: "a"},
+				{host: "a"},
+				{host: "a", want: 500 * time.Millisecond},
+				{host: "a", want: time.Second},
+			},
+		},
+		{
+			name:  "refill",
+			rate:  2,
+			burst: 1,
+			steps: []step{
+				{host: "a"},
+				{host: "a", want: 500 * time.Millisecond},
+				// the waiting run used the token refilled in that time
+				{advance: 500 * time.Millisecond, host: "a", want: 500 * time.Millisecond},
+				{advance: time.Second, host: "a"},
+			},
+		},
+		{
+			name:  "refill is capped at the burst",
+			rate:  2,
+			burst: 2,
+			steps: []step{
+				{host: "a"},
+				{advance: time.Hour, host: "a"},
+				{host: "a"},
+				{host: "a", want: 500 * time.Millisecond},
+			},
+		},
+		{
+			name:  "hosts have their own buckets",
+			rate:  1,
+			burst: 1,
+			steps: []step{
+				{host: "a"},
+				{host: "b"},
+				{host: "a", want: time.Second},
+				{host: "b", want: time.Second},
+			},
+		},
+		{
+			name:  "slower crawl delay wins",
+			rate:  10,
+			burst: 5,
+			steps: []step{
+				{host: "a", crawlDelay: 2 * time.Second},
+				{host: "a", crawlDelay: 2 * time.Second, want: 2 * time.Second},
+			},
+		},
+		{
+			name:  "faster crawl delay is ignored",
+			rate:  1,
+			burst: 1,
+			steps: []step{
+				{host: "a", crawlDelay: 100 * time.Millisecond},
+				{host: "a", crawlDelay: 100 * time.Millisecond, want: time.Second},
+			},
+		},
+		{
+			name: "crawl delay without a limit",
+			steps: []step{
+				{host: "a", crawlDelay: time.Second},
+				{host: "a", crawlDelay: time.Second, want: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			l := testLimiter(clock, tt.rate, tt.burst)
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				if got := l.reserve(s.host, s.crawlDelay); got != s.want {
+					t.Errorf("Step [%d]: reserve(%s) = %s, want %s", i, s.host, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := testLimiter(clock, 1.0/3600, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := l.wait(ctx, "https://example.com/page"); err != nil {
+		t.Fatalf("wait() = %v for the first run, want no error", err)
+	}
+	cancel()
+	if err := l.wait(ctx, "https://example.com/page"); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait() = %v once canceled, want %v", err, context.Canceled)
+	}
+}
+
+func TestRateLimiterBackoff(t *testing.T) {
+	const target = "https://example.com/page"
+
+	// each step advances the clock, records a failed or succeeded run unless it only looks, and expects the backoff left afterwards
+	type step struct {
+		advance time.Duration
+		look    bool
+		block   bool
+		ok      bool
+		want    time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "backs off after a streak and doubles up to the max",
+			steps: []step{
+				{},
+				{},
+				{want: time.Minute},
+				{advance: time.Minute, want: 2 * time.Minute},
+				{advance: 2 * time.Minute, want: 4 * time.Minute},
+				{advance: 4 * time.Minute, want: 5 * time.Minute},
+				{advance: 5 * time.Minute, want: 5 * time.Minute},
+			},
+		},
+		{
+			name: "expires",
+			steps: []step{
+				{},
+				{},
+				{want: time.Minute},
+				{advance: 59 * time.Second, look: true, want: time.Second},
+				{advance: time.Second, look: true},
+			},
+		},
+		{
+			name: "success resets the streak",
+			steps: []step{
+				{},
+				{},
+				{want: time.Minute},
+				{advance: time.Minute, ok: true},
+				{},
+				{},
+				{want: time.Minute},
+			},
+		},
+		{
+			name: "a block backs off right away",
+			steps: []step{
+				{block: true, want: time.Minute},
+				// the streak carries on from the blocked run
+				{advance: time.Minute},
+				{want: 2 * time.Minute},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			l := testLimiter(clock, 0, 1)
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				if s.block {
+					l.block(target)
+				}
+				if s.ok {
+					l.record(target, nil)
+				} else if !s.look {
+					l.record(target, errors.New("failed"))
+				}
+
+				until, ok := l.backingOff(target)
+				if got := until.Sub(clock.now()); ok != (s.want > 0) || (ok && got != s.want) {
+					t.Errorf("Step [%d]: backing off = %t for [%s], want [%s]", i, ok, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterBackoffJitter(t *testing.T) {
+	const target = "https://example.com/page"
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := testLimiter(clock, 0, 1)
+	l.after = 1
+	l.jitter = 0.2
+
+	for i := 0; i < 100; i++ {
+		l.backoffs = map[string]*targetBackoff{}
+		l.record(target, errors.New("failed"))
+		until, ok := l.backingOff(target)
+		if d := until.Sub(clock.now()); !ok || d < 48*time.Second || d > 72*time.Second {
+			t.Fatalf("Backing off = %t for [%s], want within 20%% of [%s]", ok, d, time.Minute)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package fetcher
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultRobotsAgent default product token that robots.txt rules are evaluated for
+	DefaultRobotsAgent = "go-scraper"
+
+	// DefaultRobotsCacheTTL default time (seconds) a robots.txt is cached for
+	DefaultRobotsCacheTTL = 3600
+
+	// robotsMaxSize is how much of a robots.txt is read, the rest is ignored like RFC 9309 allows
+	robotsMaxSize = 500 * 1024
+
+	robotsFetchTimeout = 10 * time.Second
+
+	// robotsErrorTTL is how long a robots.txt that couldn't be fetched disallows its host, before we try again
+	robotsErrorTTL = time.Minute
+)
+
+var (
+	gRobots       *robotsPolicy
+	gRobotsLoaded bool
+	gRobotsMu     sync.Mutex
+)
+
+// robotsRule is a single allow or disallow line of a robots.txt group
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robotsGroup is the rules of a robots.txt for a set of user-agents
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsFile is the parsed robots.txt of a host
+type robotsFile struct {
+	groups []*robotsGroup
+
+	// set when the robots.txt couldn't be fetched, instead of any rules
+	allowAll    bool
+	disallowAll bool
+	// the fetch failed in a way that may pass, so it is only cached for the robotsErrorTTL
+	transient bool
+
+	fetchedAt time.Time
+}
+
+// robotsPolicy fetches and caches the robots.txt of each host and evaluates it for our agent token
+type robotsPolicy struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	agent    string
+	ttl      time.Duration
+	cache    map[string]*robotsFile  // by scheme://host
+	fetching map[string]*robotsFetch // by scheme://host
+}
+
+// robotsFetch is a fetch of a robots.txt in flight, which the other runs for its host wait for
+type robotsFetch struct {
+	done chan struct{}
+	file *robotsFile
+}
+
+// robots returns the robots policy if the robots flag is set, nil if we don't obey robots.txt
+func robots() *robotsPolicy {
+	gRobotsMu.Lock()
+	defer gRobotsMu.Unlock()
+
+	if !gRobotsLoaded {
+		gRobots = robotsFromFlags(nil)
+		gRobotsLoaded = true
+	}
+	return gRobots
+}
+
+// reloadRobots picks up changed robots flags, keeping the cached robots.txt files while the agent token stays the same
+func reloadRobots() {
+	gRobotsMu.Lock()
+	defer gRobotsMu.Unlock()
+
+	gRobots = robotsFromFlags(gRobots)
+	gRobotsLoaded = true
+}
+
+// robotsFromFlags returns the policy for the robots flags, reusing the current one if there is one
+func robotsFromFlags(current *robotsPolicy) *robotsPolicy {
+	if !viper.GetBool("robots") {
+		return nil
+	}
+	agent, ttl := viper.GetString("robots_agent"), time.Duration(viper.GetInt("robots_cache_ttl"))*time.Second
+	if current == nil {
+		current = newRobotsPolicy(agent, ttl, &http.Client{Timeout: robotsFetchTimeout})
+	} else {
+		current.reload(agent, ttl)
+	}
+	Log().Infof("Obeying robots.txt for agent token [%s]", agent)
+
+	return current
+}
+
+func newRobotsPolicy(agent string, ttl time.Duration, client *http.Client) *robotsPolicy {
+	return &robotsPolicy{agent: agent, ttl: ttl, client: client, cache: map[string]*robotsFile{}, fetching: map[string]*robotsFetch{}}
+}
+
+// reload changes the agent token and cache TTL of the policy - the cached files are dropped if the agent token changed, since their rules were picked for the old one
+func (p *robotsPolicy) reload(agent string, ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if agent != p.agent {
+		p.cache = map[string]*robotsFile{}
+	}
+	p.agent, p.ttl = agent, ttl
+}
+
+// agentToken returns the agent token the rules are evaluated for
+func (p *robotsPolicy) agentToken() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.agent
+}
+
+// checkRobotsFlags validates the robots flags
+func checkRobotsFlags() error {
+	if !viper.GetBool("robots") {
+		return nil
+	}
+	if len(strings.TrimSpace(viper.GetString("robots_agent"))) == 0 {
+		return fmt.Errorf("We require a non-empty robots_agent to obey robots.txt")
+	}
+	if viper.GetInt("robots_cache_ttl") < 0 {
+		return fmt.Errorf("The robots_cache_ttl can't be negative")
+	}
+
+	return nil
+}
+
+// allowed reports whether the robots.txt of its host allows us to load the target URL
+func (p *robotsPolicy) allowed(targetURL string) bool {
+	if p == nil {
+		return true
+	}
+	u, err := url.Parse(targetURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return true
+	}
+	if u.EscapedPath() == "/robots.txt" {
+		return true
+	}
+
+	f := p.file(u)
+	if f.allowAll {
+		return true
+	}
+	if f.disallowAll {
+		return false
+	}
+
+	path := u.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	if len(u.RawQuery) != 0 {
+		path += "?" + u.RawQuery
+	}
+
+	// the longest matching pattern wins, allow winning a tie
+	var best *robotsRule
+	for _, g := range f.match(p.agentToken()) {
+		for i, r := range g.rules {
+			if !r.re.MatchString(path) {
+				continue
+			}
+			if best == nil || len(r.pattern) > len(best.pattern) || (len(r.pattern) == len(best.pattern) && r.allow) {
+				best = &g.rules[i]
+			}
+		}
+	}
+
+	return best == nil || best.allow
+}
+
+// crawlDelay returns the Crawl-delay the robots.txt of the host of the target URL asks of us, zero if it doesn't
+func (p *robotsPolicy) crawlDelay(targetURL string) time.Duration {
+	if p == nil {
+		return 0
+	}
+	u, err := url.Parse(targetURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0
+	}
+
+	var delay time.Duration
+	for _, g := range p.file(u).match(p.agentToken()) {
+		if g.crawlDelay > delay {
+			delay = g.crawlDelay
+		}
+	}
+	return delay
+}
+
+// file returns the cached robots.txt for the origin of the URL, fetching it if it isn't cached or has expired
+func (p *robotsPolicy) file(u *url.URL) *robotsFile {
+	origin := u.Scheme + "://" + u.Host
+
+	p.mu.Lock()
+	if f, ok := p.cache[origin]; ok {
+		ttl := p.ttl
+		if f.transient && robotsErrorTTL < ttl {
+			ttl = robotsErrorTTL
+		}
+		if time.Since(f.fetchedAt) < ttl {
+			p.mu.Unlock()
+			return f
+		}
+	}
+	// only one fetch for each origin at a time, the other runs for it wait for its result
+	if c, ok := p.fetching[origin]; ok {
+		p.mu.Unlock()
+		<-c.done
+		return c.file
+	}
+	c := &robotsFetch{done: make(chan struct{})}
+	p.fetching[origin] = c
+	p.mu.Unlock()
+
+	// fetched without holding the lock, so a slow host doesn't hold up the others
+	c.file = p.fetch(origin)
+	p.mu.Lock()
+	p.cache[origin] = c.file
+	delete(p.fetching, origin)
+	p.mu.Unlock()
+	close(c.done)
+	return c.file
+}
+
+// fetch gets and parses the robots.txt of the origin - a missing one allows everything, an unreachable one disallows everything
+func (p *robotsPolicy) fetch(origin string) *robotsFile {
+	robotsURL := origin + "/robots.txt"
+	resp, err := p.client.Get(robotsURL)
+	if err != nil {
+		Log().Errorf("Failed to fetch [%s], so we will not load any URL of its host until we try again in [%s]: %v", robotsURL, robotsErrorTTL, err)
+		return &robotsFile{disallowAll: true, transient: true, fetchedAt: time.Now()}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		Log().Errorf("Fetching [%s] returned [%s], so we will not load any URL of its host until we try again in [%s]", robotsURL, resp.Status, robotsErrorTTL)
+		return &robotsFile{disallowAll: true, transient: true, fetchedAt: time.Now()}
+	case resp.StatusCode >= http.StatusBadRequest:
+		Log().Infof("Fetching [%s] returned [%s], so every URL of its host is allowed", robotsURL, resp.Status)
+		return &robotsFile{allowAll: true, fetchedAt: time.Now()}
+	}
+
+	f := parseRobots(io.LimitReader(resp.Body, robotsMaxSize))
+	f.fetchedAt = time.Now()
+	Log().Infof("Fetched [%s] with [%d] groups", robotsURL, len(f.groups))
+	return f
+}
+
+// parseRobots parses a robots.txt - lines it doesn't understand are skipped
+func parseRobots(r io.Reader) *robotsFile {
+	f := &robotsFile{}
+	var g *robotsGroup
+	inAgents := false
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			// consecutive user-agent lines share the group that follows them
+			if !inAgents {
+				g = &robotsGroup{}
+				f.groups = append(f.groups, g)
+				inAgents = true
+			}
+			g.agents = append(g.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			if g == nil || len(value) == 0 {
+				continue
+			}
+			g.rules = append(g.rules, robotsRule{allow: key == "allow", pattern: value, re: robotsPattern(value)})
+		case "crawl-delay":
+			inAgents = false
+			if g == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				g.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	return f
+}
+
+// robotsPattern turns a path pattern, where * matches anything and a trailing $ anchors the end, into a regex
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// match returns the groups for the agent token, or the * groups if none name it
+func (f *robotsFile) match(agent string) []*robotsGroup {
+	token := strings.ToLower(strings.SplitN(agent, "/", 2)[0])
+
+	var named, any []*robotsGroup
+	for _, g := range f.groups {
+		isNamed, isAny := false, false
+		for _, a := range g.agents {
+			isNamed = isNamed || a == token
+			isAny = isAny || a == "*"
+		}
+		if isNamed {
+			named = append(named, g)
+		} else if isAny {
+			any = append(any, g)
+		}
+	}
+
+	if len(named) != 0 {
+		return named
+	}
+	return any
+}
@@ -0,0 +1,295 @@
+package fetcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cachedRobotsPolicy returns a policy for the agent with the robots.txt already cached for https://example.com
+func cachedRobotsPolicy(agent string, robots string) *robotsPolicy {
+	p := newRobotsPolicy(agent, time.Hour, nil)
+	f := parseRobots(strings.NewReader(robots))
+	f.fetchedAt = time.Now()
+	p.cache["https://example.com"] = f
+	return p
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		robots string
+		agent  string
+		path   string
+		want   bool
+	}{
+		{
+			name:   "no rules",
+			robots: "User-agent: *\n",
+			path:   "/anything",
+			want:   true,
+		},
+		{
+			name:   "disallowed prefix",
+			robots: "User-agent: *\nDisallow: /private\n",
+			path:   "/private/page",
+			want:   false,
+		},
+		{
+			name:   "longest match wins",
+			robots: "User-agent: *\nDisallow: /shop\nAllow: /shop/items\n",
+			path:   "/shop/items/1",
+			want:   true,
+		},
+		{
+			name:   "longest match wins over a later shorter allow",
+			robots: "User-agent: *\nDisallow: /shop/cart\nAllow: /shop\n",
+			path:   "/shop/cart",
+			want:   false,
+		},
+		{
+			name:   "allow wins a tie",
+			robots: "User-agent: *\nDisallow: /page\nAllow: /page\n",
+			path:   "/page",
+			want:   true,
+		},
+		{
+			name:   "wildcard",
+			robots: "User-agent: *\nDisallow: /*/edit\n",
+			path:   "/docs/edit",
+			want:   false,
+		},
+		{
+			name:   "wildcard needs its suffix",
+			robots: "User-agent: *\nDisallow: /*/edit\n",
+			path:   "/docs/view",
+			want:   true,
+		},
+		{
+			name:   "end anchor matches the end",
+			robots: "User-agent: *\nDisallow: /*.pdf$\n",
+			path:   "/files/report.pdf",
+			want:   false,
+		},
+		{
+			name:   "end anchor doesn't match a longer path",
+			robots: "User-agent: *\nDisallow: /*.pdf$\n",
+			path:   "/files/report.pdf.html",
+			want:   true,
+		},
+		{
+			name:   "query is matched",
+			robots: "User-agent: *\nDisallow: /search?q=\n",
+			path:   "/search?q=shoes",
+			want:   false,
+		},
+		{
+			name:   "named group wins over *",
+			robots: "User-agent: *\nDisallow: /\n\nUser-agent: go-scraper\nAllow: /\n",
+			path:   "/page",
+			want:   true,
+		},
+		{
+			name:   "agent token is matched without its version and case",
+			robots: "User-agent: *\nAllow: /\n\nUser-agent: Go-Scraper\nDisallow: /\n",
+			agent:  "go-scraper/1.0",
+			path:   "/page",
+			want:   false,
+		},
+		{
+			name:   "other agents' groups are ignored",
+			robots: "User-agent: otherbot\nDisallow: /\n",
+			path:   "/page",
+			want:   true,
+		},
+		{
+			name:   "consecutive user-agents share a group",
+			robots: "User-agent: otherbot\nUser-agent: go-scraper\nDisallow: /private\n",
+			path:   "/private",
+			want:   false,
+		},
+		{
+			name:   "robots.txt itself is always allowed",
+			robots: "User-agent: *\nDisallow: /\n",
+			path:   "/robots.txt",
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := tt.agent
+			if len(agent) == 0 {
+				agent = DefaultRobotsAgent
+			}
+			p := cachedRobotsPolicy(agent, tt.robots)
+			if got := p.allowed("https://example.com" + tt.path); got != tt.want {
+				t.Errorf("allowed(%s) = %t, want %t", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobotsCrawlDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		robots string
+		want   time.Duration
+	}{
+		{
+			name:   "none",
+			robots: "User-agent: *\nDisallow: /private\n",
+		},
+		{
+			name:   "seconds",
+			robots: "User-agent: *\nCrawl-delay: 5\n",
+			want:   5 * time.Second,
+		},
+		{
+			name:   "fraction",
+			robots: "User-agent: *\nCrawl-delay: 0.5\n",
+			want:   500 * time.Millisecond,
+		},
+		{
+			name:   "of the named group",
+			robots: "User-agent: *\nCrawl-delay: 10\n\nUser-agent: go-scraper\nCrawl-delay: 2\n",
+			want:   2 * time.Second,
+		},
+		{
+			name:   "invalid is ignored",
+			robots: "User-agent: *\nCrawl-delay: soon\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := cachedRobotsPolicy(DefaultRobotsAgent, tt.robots)
+			if got := p.crawlDelay("https://example.com/page"); got != tt.want {
+				t.Errorf("crawlDelay() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobotsFetch(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		allowed   bool
+		transient bool
+	}{
+		{
+			name:    "ok",
+			status:  http.StatusOK,
+			body:    "User-agent: *\nDisallow: /private\n",
+			allowed: false,
+		},
+		{
+			name:    "not found allows everything",
+			status:  http.StatusNotFound,
+			allowed: true,
+		},
+		{
+			name:      "server error disallows everything for a while",
+			status:    http.StatusServiceUnavailable,
+			allowed:   false,
+			transient: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/robots.txt" {
+					t.Errorf("Fetched [%s], want /robots.txt", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			p := newRobotsPolicy(DefaultRobotsAgent, time.Hour, srv.Client())
+			if got := p.allowed(srv.URL + "/private"); got != tt.allowed {
+				t.Errorf("allowed() = %t, want %t", got, tt.allowed)
+			}
+			if f := p.cache[srv.URL]; f == nil || f.transient != tt.transient {
+				t.Errorf("cached file = %+v, want transient %t", f, tt.transient)
+			}
+		})
+	}
+}
+
+func TestRobotsFetchNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	origin := srv.URL
+	srv.Close()
+
+	p := newRobotsPolicy(DefaultRobotsAgent, time.Hour, &http.Client{Timeout: time.Second})
+	if p.allowed(origin + "/page") {
+		t.Errorf("allowed() = true for an unreachable host, want false")
+	}
+	if f := p.cache[origin]; f == nil || !f.transient {
+		t.Errorf("cached file = %+v, want a transient one", f)
+	}
+}
+
+func TestRobotsErrorTTL(t *testing.T) {
+	var fetches int32
+	var status int32 = http.StatusInternalServerError
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	p := newRobotsPolicy(DefaultRobotsAgent, time.Hour, srv.Client())
+	if p.allowed(srv.URL + "/page") {
+		t.Fatalf("allowed() = true after a server error, want false")
+	}
+	p.allowed(srv.URL + "/page")
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("Fetched [%d] times within the error TTL, want 1", n)
+	}
+
+	// past the error TTL, but well within the TTL of a fetched robots.txt
+	p.cache[srv.URL].fetchedAt = time.Now().Add(-robotsErrorTTL - time.Second)
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	if !p.allowed(srv.URL + "/page") {
+		t.Errorf("allowed() = false once the host recovered, want true")
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("Fetched [%d] times, want 2", n)
+	}
+}
+
+func TestRobotsFetchDeduped(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	}))
+	defer srv.Close()
+
+	p := newRobotsPolicy(DefaultRobotsAgent, time.Hour, srv.Client())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.allowed(srv.URL + "/private") {
+				t.Errorf("allowed() = true, want false")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Fetched [%d] times for concurrent runs, want 1", n)
+	}
+}
@@ -0,0 +1,592 @@
+package fetcher
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultWatchConcurrency default number of targets of a watch that run at once
+	DefaultWatchConcurrency = 1
+
+	// cronSearchLimit bounds how far ahead we look for the next time of a cron expression that can never match (e.g. Feb 30)
+	cronSearchLimit = 5 * 366 * 24 * time.Hour
+)
+
+var (
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// cronSchedule is a parsed standard 5 field cron expression - minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	expr   string
+	minute []bool
+	hour   []bool
+	dom    []bool
+	month  []bool
+	dow    []bool
+
+	// when both days are restricted either one may match, like cron does
+	domAny bool
+	dowAny bool
+}
+
+// activeWindow is a time of day range on some days of the week - an end before the start runs past midnight
+type activeWindow struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int
+}
+
+// targetSchedule decides when a watched URL runs next
+type targetSchedule struct {
+	interval time.Duration
+	jitter   float64
+	cron     *cronSchedule
+	windows  []activeWindow
+}
+
+// watchRun is a run of the watch in flight - its index follows its target when the targets change, and is -1 once the target is removed
+type watchRun struct {
+	index int
+	url   string
+	res   *runResult
+	err   error
+
+	// set when the watch was stopped before the run got a browser, so it never ran
+	skipped bool
+}
+
+// scheduledRun is a URL of the watch waiting for its next run
+type scheduledRun struct {
+	index int
+	at    time.Time
+}
+
+// runQueue orders the scheduled runs by time, earliest first
+type runQueue []scheduledRun
+
+func (q runQueue) Len() int            { return len(q) }
+func (q runQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q runQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *runQueue) Push(x interface{}) { *q = append(*q, x.(scheduledRun)) }
+func (q *runQueue) Pop() interface{} {
+	old := *q
+	r := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return r
+}
+
+// parseCron parses a 5 field cron expression or one of the @ descriptors
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		c, err := parseCron(d)
+		if c != nil {
+			c.expr = expr
+		}
+		return c, err
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression [%s] - it needs 5 fields (minute, hour, day of month, month and day of week) or one of @hourly, @daily, @weekly, @monthly or @yearly", expr)
+	}
+
+	c := &cronSchedule{expr: expr, domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Invalid minute field of cron expression [%s]: %v", expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("Invalid hour field of cron expression [%s]: %v", expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("Invalid day of month field of cron expression [%s]: %v", expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("Invalid month field of cron expression [%s]: %v", expr, err)
+	}
+	// 7 is sunday too
+	if c.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("Invalid day of week field of cron expression [%s]: %v", expr, err)
+	}
+	c.dow[0] = c.dow[0] || c.dow[7]
+
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of *, values, ranges and steps into the set of values it allows
+func parseCronField(field string, min int, max int, names []string) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("Invalid step [%s]", part[i+1:])
+			}
+			step = s
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = cronValue(bounds[0], min, names); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = cronValue(bounds[1], min, names); err != nil {
+					return nil, err
+				}
+			} else if step != 1 {
+				// a/n means from a to the end
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("Range [%s] must be within [%d-%d]", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+
+	return set, nil
+}
+
+func cronValue(s string, min int, names []string) (int, error) {
+	for i, n := range names {
+		if strings.EqualFold(s, n) {
+			return i + min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value [%s]", s)
+	}
+	return v, nil
+}
+
+// next returns the first time after t that the expression matches
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	// can't happen for an expression that matches at all, so just try again later
+	return limit
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// parseActiveHours parses windows like "mon-fri 09:00-18:00|sat 10:00-14:00" - the days are optional and default to every day
+func parseActiveHours(spec string) ([]activeWindow, error) {
+	var windows []activeWindow
+	for _, part := range strings.Split(spec, "|") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("Invalid active hours window [%s] - must be HH:MM-HH:MM, optionally after days like mon-fri or sat", part)
+		}
+
+		var w activeWindow
+		if len(fields) == 1 {
+			for d := range w.days {
+				w.days[d] = true
+			}
+		} else {
+			days, err := parseCronField(fields[0], 0, 6, dayNames)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid days [%s] of active hours window [%s]: %v", fields[0], part, err)
+			}
+			copy(w.days[:], days)
+		}
+
+		times := strings.SplitN(fields[len(fields)-1], "-", 2)
+		if len(times) != 2 {
+			return nil, fmt.Errorf("Invalid active hours window [%s] - must be HH:MM-HH:MM", part)
+		}
+		var err error
+		if w.start, err = parseClock(times[0]); err != nil {
+			return nil, fmt.Errorf("Invalid start of active hours window [%s]: %v", part, err)
+		}
+		if w.end, err = parseClock(times[1]); err != nil {
+			return nil, fmt.Errorf("Invalid end of active hours window [%s]: %v", part, err)
+		}
+		if w.start == w.end {
+			return nil, fmt.Errorf("Active hours window [%s] is empty", part)
+		}
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight, 24:00 being the end of the day
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("Invalid time [%s] - must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether t falls within the window
+func (w activeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if w.start < w.end {
+		return w.days[day] && m >= w.start && m < w.end
+	}
+	// past midnight the window belongs to the day it started on
+	return (w.days[day] && m >= w.start) || (w.days[(day+6)%7] && m < w.end)
+}
+
+// nextStart returns the first start of the window after t
+func (w activeWindow) nextStart(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for d := 0; d <= 7; d++ {
+		day := midnight.AddDate(0, 0, d)
+		start := day.Add(time.Duration(w.start) * time.Minute)
+		if w.days[int(day.Weekday())] && start.After(t) {
+			return start
+		}
+	}
+	return t
+}
+
+// newTargetSchedule builds the schedule of the URL at index i from the interval, intervals, schedules, active_hours and interval_jitter flags
+func newTargetSchedule(i int) (*targetSchedule, error) {
+	s := &targetSchedule{
+		interval: time.Duration(viper.GetInt("interval")) * time.Second,
+		jitter:   viper.GetFloat64("interval_jitter"),
+	}
+
+	if intervals := viper.GetStringSlice("intervals"); len(intervals) > i && len(intervals[i]) != 0 {
+		secs, err := strconv.Atoi(intervals[i])
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("Invalid interval [%s] - must be a positive number of seconds", intervals[i])
+		}
+		s.interval = time.Duration(secs) * time.Second
+	}
+	if schedules := viper.GetStringSlice("schedules"); len(schedules) > i && len(schedules[i]) != 0 {
+		c, err := parseCron(schedules[i])
+		if err != nil {
+			return nil, err
+		}
+		s.cron = c
+	}
+	if hours := viper.GetStringSlice("active_hours"); len(hours) > i && len(hours[i]) != 0 {
+		windows, err := parseActiveHours(hours[i])
+		if err != nil {
+			return nil, err
+		}
+		s.windows = windows
+	}
+
+	return s, nil
+}
+
+// checkSchedules validates the schedule flags of the watched URLs
+func checkSchedules(urls []string) error {
+	if viper.GetInt("interval") <= 0 {
+		return fmt.Errorf("The interval must be a positive number of seconds")
+	}
+	if j := viper.GetFloat64("interval_jitter"); j < 0 || j >= 1 {
+		return fmt.Errorf("The interval_jitter must be at least 0 and less than 1")
+	}
+	if n := viper.GetInt("concurrency"); n < 1 {
+		return fmt.Errorf("The concurrency must be at least 1")
+	} else if n > 1 && !viper.GetBool("headless") && len(viper.GetStringSlice("override_flags")) == 0 {
+		return fmt.Errorf("A concurrency above 1 requires headless, since browsers that aren't share the user_data_dir")
+	}
+	for _, flag := range []string{"intervals", "schedules", "active_hours"} {
+		if v := viper.GetStringSlice(flag); len(v) != 0 && len(v) != len(urls) {
+			return fmt.Errorf("Number of URLs and %s passed in must have the same length", flag)
+		}
+	}
+	for i, u := range urls {
+		if _, err := newTargetSchedule(i); err != nil {
+			return fmt.Errorf("Invalid schedule for URL [%s]: %v", u, err)
+		}
+	}
+
+	return nil
+}
+
+// active reports whether t is within the active hours, which is always the case without any
+func (s *targetSchedule) active(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// first returns when the URL runs for the first time - right away unless we are outside its active hours
+func (s *targetSchedule) first(now time.Time) time.Time {
+	if s.cron != nil {
+		return s.next(now)
+	}
+	return s.fit(now)
+}
+
+// next returns when the URL runs again after a run that ended at t
+func (s *targetSchedule) next(t time.Time) time.Time {
+	var at time.Time
+	if s.cron != nil {
+		// cron times are only ever pushed back, by up to the jitter fraction of the wait for them
+		at = s.cron.next(t)
+		at = at.Add(time.Duration(rand.Float64() * s.jitter * float64(at.Sub(t))))
+	} else {
+		at = t.Add(time.Duration(float64(s.interval) * (1 + s.jitter*(2*rand.Float64()-1))))
+	}
+
+	return s.fit(at)
+}
+
+// fit moves t to the start of the next active hours window if it falls outside of them
+func (s *targetSchedule) fit(t time.Time) time.Time {
+	if s.active(t) {
+		return t
+	}
+	var res time.Time
+	for _, w := range s.windows {
+		if start := w.nextStart(t); res.IsZero() || start.Before(res) {
+			res = start
+		}
+	}
+	return res
+}
+
+// describe explains the schedule for the logs
+func (s *targetSchedule) describe() string {
+	var d string
+	if s.cron != nil {
+		d = fmt.Sprintf("on cron schedule [%s]", s.cron.expr)
+	} else {
+		d = fmt.Sprintf("every [%s]", s.interval)
+	}
+	if s.jitter > 0 {
+		d += fmt.Sprintf(" with [%.0f%%] jitter", s.jitter*100)
+	}
+	if len(s.windows) != 0 {
+		d += " within its active hours"
+	}
+	return d
+}
+
+// schedule runs each watched URL when it is due, until the watch is stopped - the runs are started in the background, as many at once as the pool has browsers,
+// so reloads and the calls of serve are handled while they are in flight
+func (w *watchExecutor) schedule(l *watchLifecycle) {
+	q := &runQueue{}
+	now := time.Now()
+	for i, s := range w.schedules {
+		r := scheduledRun{index: i, at: s.first(now)}
+		heap.Push(q, r)
+		Log().Infof("Will check URL [%s] %s", w.urls[i], s.describe())
+		w.scheduled(r)
+	}
+	w.done = make(chan *watchRun)
+	if w.pool == nil {
+		// serve shares its pool with its fetches, the watch alone has its own
+		w.pool = newBrowserPool(viper.GetInt("concurrency"), 0)
+	}
+
+	for {
+		// a watch without targets, like serve before any are added, waits for some
+		var timer *time.Timer
+		var due <-chan time.Time
+		if q.Len() != 0 {
+			timer = time.NewTimer(time.Until((*q)[0].at))
+			due = timer.C
+		}
+		select {
+		case <-l.stop.Done():
+		case <-l.reload:
+			q = w.reload(l, q)
+			heap.Init(q)
+		case call := <-w.calls:
+			q = call(q)
+			heap.Init(q)
+		case r := <-w.done:
+			w.finish(r, q)
+		case <-due:
+			w.start(l, heap.Pop(q).(scheduledRun))
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if l.stop.Err() != nil {
+			// the shutdown deadline cancels what is still in flight through the runs context
+			for len(w.running) != 0 {
+				w.finish(<-w.done, q)
+			}
+			return
+		}
+	}
+}
+
+// start runs the URL in the background - the run is handed back through done once it finished
+func (w *watchExecutor) start(l *watchLifecycle, r scheduledRun) {
+	wr := &watchRun{index: r.index, url: w.urls[r.index]}
+	w.running = append(w.running, wr)
+	ctx := l.runs
+	if w.observer != nil {
+		wr.res = &runResult{started: time.Now(), keepPageOnError: true}
+		ctx = context.WithValue(ctx, runResultContextKey{}, wr.res)
+	}
+
+	actions := w.actions[r.index]
+	go func() {
+		err := w.acquire(l, ctx)
+		if err == nil {
+			err = run(ctx, actions, wr.url)
+			w.release()
+		} else if l.stop.Err() != nil {
+			wr.skipped = true
+		}
+		wr.err = err
+		w.done <- wr
+	}()
+}
+
+// finish tells the observer about the run and schedules the next run of its URL, unless the target was removed while it ran
+func (w *watchExecutor) finish(wr *watchRun, q *runQueue) {
+	for i, o := range w.running {
+		if o == wr {
+			w.running = append(w.running[:i], w.running[i+1:]...)
+			break
+		}
+	}
+	if wr.skipped {
+		Log().Infof("Not checking URL [%s] since the watch is stopping", wr.url)
+		return
+	}
+	if wr.err != nil {
+		Log().Errorf("Data for %s was not available during this check - received error %s\n", wr.url, wr.err.Error())
+	}
+	if wr.index < 0 {
+		Log().Infof("Target for URL [%s] was removed while it ran, it won't run again", wr.url)
+		return
+	}
+	if wr.res != nil {
+		wr.res.err = wr.err
+		wr.res.duration = time.Since(wr.res.started)
+		w.observer.finished(wr.index, wr.res)
+	}
+
+	next := w.schedules[wr.index].next(time.Now())
+	// a URL that is backing off waits it out, then goes back to its schedule
+	if until, ok := limits().backingOff(wr.url); ok && until.After(next) {
+		next = w.schedules[wr.index].fit(until)
+	}
+	Log().Infof("Next check of URL [%s] is at [%s]", wr.url, next.Format(time.RFC3339))
+	r := scheduledRun{index: wr.index, at: next}
+	heap.Push(q, r)
+	w.scheduled(r)
+}
+
+// acquire waits for a browser of the pool - it gives up once the watch is stopping, since no run starts after that
+func (w *watchExecutor) acquire(l *watchLifecycle, ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(l.stop, cancel)()
+
+	if err := w.pool.acquire(ctx, false); err != nil {
+		return err
+	}
+	// a free browser and the stop may both be ready, so check again
+	if err := l.stop.Err(); err != nil {
+		w.pool.release()
+		return err
+	}
+	return nil
+}
+
+func (w *watchExecutor) release() {
+	w.pool.release()
+}
+
+// scheduled tells the observer of the watch, if it has one, when a URL runs next
+func (w *watchExecutor) scheduled(r scheduledRun) {
+	if w.observer != nil {
+		w.observer.scheduled(r.index, r.at)
+	}
+}
+
+// reload reloads the config and applies the targets and schedules from it, returning the queue of the new targets - an invalid config is rejected and the current targets keep running
+func (w *watchExecutor) reload(l *watchLifecycle, q *runQueue) *runQueue {
+	gConfigMu.Lock()
+	defer gConfigMu.Unlock()
+
+	var actionGens [][]actionGenerator
+	var urls []string
+	err := l.reloadConfig(func() error {
+		if err := w.check(); err != nil {
+			return err
+		}
+		var err error
+		actionGens, urls, err = w.build()
+		return err
+	})
+	if err != nil {
+		Log().Errorf("%v, keeping the current config and targets", err)
+		return q
+	}
+
+	return w.applyTargets(actionGens, urls, q)
+}
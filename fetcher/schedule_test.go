@@ -0,0 +1,246 @@
+package fetcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// at parses a UTC time like 2024-01-01 09:30 - 2024-01-01 is a Monday
+func at(t *testing.T, s string) time.Time {
+	t.Helper()
+	res, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		t.Fatalf("Invalid test time [%s]: %v", s, err)
+	}
+	return res
+}
+
+// setValues returns the values a parsed cron field allows
+func setValues(set []bool) []int {
+	var res []int
+	for v, ok := range set {
+		if ok {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		min   int
+		max   int
+		names []string
+		want  []int
+		err   bool
+	}{
+		{name: "any", field: "*", min: 1, max: 5, want: []int{1, 2, 3, 4, 5}},
+		{name: "value", field: "5", max: 59, want: []int{5}},
+		{name: "range", field: "1-3", max: 59, want: []int{1, 2, 3}},
+		{name: "list", field: "1,3,5", max: 59, want: []int{1, 3, 5}},
+		{name: "step of any", field: "*/15", max: 59, want: []int{0, 15, 30, 45}},
+		{name: "step of a range", field: "10-20/5", max: 59, want: []int{10, 15, 20}},
+		{name: "step from a value runs to the end", field: "50/5", max: 59, want: []int{50, 55}},
+		{name: "day names", field: "mon-fri", max: 7, names: dayNames, want: []int{1, 2, 3, 4, 5}},
+		{name: "names ignore case", field: "SAT,sun", max: 7, names: dayNames, want: []int{0, 6}},
+		{name: "month names start at the min", field: "jan,dec", min: 1, max: 12, names: monthNames, want: []int{1, 12}},
+		{name: "above the max", field: "60", max: 59, err: true},
+		{name: "below the min", field: "0", min: 1, max: 31, err: true},
+		{name: "reversed range", field: "5-1", max: 59, err: true},
+		{name: "zero step", field: "*/0", max: 59, err: true},
+		{name: "invalid step", field: "*/x", max: 59, err: true},
+		{name: "unknown name", field: "someday", max: 7, names: dayNames, err: true},
+		{name: "empty part", field: "1,", max: 59, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := parseCronField(tt.field, tt.min, tt.max, tt.names)
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseCronField(%s) = %v, want an error", tt.field, setValues(set))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCronField(%s) failed: %v", tt.field, err)
+			}
+			if got := setValues(set); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCronField(%s) = %v, want %v", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@often",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * foo *",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := parseCron(expr); err == nil {
+				t.Errorf("parseCron(%s) succeeded, want an error", expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{name: "next step", expr: "*/15 * * * *", from: "2024-01-01 10:07", want: "2024-01-01 10:15"},
+		{name: "strictly after", expr: "*/15 * * * *", from: "2024-01-01 10:15", want: "2024-01-01 10:30"},
+		{name: "hour range", expr: "*/2 9-17 * * *", from: "2024-01-01 17:59", want: "2024-01-02 09:00"},
+		{name: "across the month end", expr: "0 0 1 * *", from: "2024-01-31 12:00", want: "2024-02-01 00:00"},
+		{name: "across the year end", expr: "0 0 * * *", from: "2024-12-31 23:59", want: "2025-01-01 00:00"},
+		{name: "once a year", expr: "30 23 31 12 *", from: "2024-12-31 23:30", want: "2025-12-31 23:30"},
+		{name: "leap day", expr: "0 0 29 2 *", from: "2024-03-01 00:00", want: "2028-02-29 00:00"},
+		{name: "short months are skipped", expr: "0 0 31 * *", from: "2024-03-31 12:00", want: "2024-05-31 00:00"},
+		{name: "month names", expr: "0 0 1 jun,sep *", from: "2024-07-01 00:00", want: "2024-09-01 00:00"},
+		{name: "weekdays", expr: "0 9 * * mon-fri", from: "2024-01-05 10:00", want: "2024-01-08 09:00"},
+		{name: "sunday as 7", expr: "0 12 * * 7", from: "2024-01-01 00:00", want: "2024-01-07 12:00"},
+		{name: "descriptor", expr: "@weekly", from: "2024-01-01 00:00", want: "2024-01-07 00:00"},
+		{name: "day of month alone", expr: "0 0 13 * *", from: "2024-01-01 00:00", want: "2024-01-13 00:00"},
+		{name: "day of week alone", expr: "0 0 * * fri", from: "2024-01-01 00:00", want: "2024-01-05 00:00"},
+		{name: "day of month or week matches the day of week", expr: "0 0 13 * fri", from: "2024-01-01 00:00", want: "2024-01-05 00:00"},
+		{name: "day of month or week matches the day of month", expr: "0 0 13 * fri", from: "2024-01-12 00:00", want: "2024-01-13 00:00"},
+		{name: "day of month or week skips days that are neither", expr: "0 0 13 * fri", from: "2024-01-13 00:00", want: "2024-01-19 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%s) failed: %v", tt.expr, err)
+			}
+			if got, want := c.next(at(t, tt.from)), at(t, tt.want); !got.Equal(want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got.Format(time.RFC3339), want.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestCronNextNeverMatches(t *testing.T) {
+	c, err := parseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("parseCron() failed: %v", err)
+	}
+	from := at(t, "2024-01-01 00:00")
+	if got, want := c.next(from), from.Add(time.Minute+cronSearchLimit); !got.Equal(want) {
+		t.Errorf("next() = %s, want the search limit %s", got.Format(time.RFC3339), want.Format(time.RFC3339))
+	}
+}
+
+func TestParseActiveHoursInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"mon",
+		"09:00",
+		"mon-fri 09:00",
+		"mon fri 09:00-10:00",
+		"someday 09:00-10:00",
+		"mon 9am-5pm",
+		"mon 25:00-26:00",
+		"09:00-09:00",
+		"mon-fri 09:00-18:00|",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := parseActiveHours(spec); err == nil {
+				t.Errorf("parseActiveHours(%s) succeeded, want an error", spec)
+			}
+		})
+	}
+}
+
+func TestActiveHours(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		t      string
+		active bool
+		fit    string // only for a time outside the windows
+	}{
+		{name: "within", spec: "mon-fri 09:00-18:00", t: "2024-01-01 10:00", active: true},
+		{name: "before the start", spec: "mon-fri 09:00-18:00", t: "2024-01-01 08:00", fit: "2024-01-01 09:00"},
+		{name: "the end is excluded", spec: "mon-fri 09:00-18:00", t: "2024-01-05 18:00", fit: "2024-01-08 09:00"},
+		{name: "weekend", spec: "mon-fri 09:00-18:00", t: "2024-01-06 12:00", fit: "2024-01-08 09:00"},
+		{name: "until the end of the day", spec: "18:00-24:00", t: "2024-01-01 23:59", active: true},
+		{name: "between windows", spec: "mon-fri 09:00-12:00|mon-fri 13:00-17:00", t: "2024-01-01 12:30", fit: "2024-01-01 13:00"},
+		{name: "past midnight before it", spec: "22:00-06:00", t: "2024-01-01 23:00", active: true},
+		{name: "past midnight after it", spec: "22:00-06:00", t: "2024-01-02 05:59", active: true},
+		{name: "past midnight at the end", spec: "22:00-06:00", t: "2024-01-02 06:00", fit: "2024-01-02 22:00"},
+		{name: "past midnight belongs to the day it started on", spec: "fri 22:00-02:00", t: "2024-01-06 01:30", active: true},
+		{name: "past midnight of a day it didn't start on", spec: "fri 22:00-02:00", t: "2024-01-05 01:30", fit: "2024-01-05 22:00"},
+		{name: "past midnight the next week", spec: "fri 22:00-02:00", t: "2024-01-06 02:00", fit: "2024-01-12 22:00"},
+		{name: "past midnight into the next week", spec: "sat 23:00-01:00", t: "2024-01-07 00:30", active: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			windows, err := parseActiveHours(tt.spec)
+			if err != nil {
+				t.Fatalf("parseActiveHours(%s) failed: %v", tt.spec, err)
+			}
+			s := &targetSchedule{windows: windows}
+			now := at(t, tt.t)
+			if got := s.active(now); got != tt.active {
+				t.Errorf("active(%s) = %t, want %t", tt.t, got, tt.active)
+			}
+			want := now
+			if !tt.active {
+				want = at(t, tt.fit)
+			}
+			if got := s.fit(now); !got.Equal(want) {
+				t.Errorf("fit(%s) = %s, want %s", tt.t, got.Format(time.RFC3339), want.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestTargetScheduleNext(t *testing.T) {
+	windows, err := parseActiveHours("mon-fri 09:00-17:00")
+	if err != nil {
+		t.Fatalf("parseActiveHours() failed: %v", err)
+	}
+	c, err := parseCron("*/30 * * * *")
+	if err != nil {
+		t.Fatalf("parseCron() failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		s    *targetSchedule
+		from string
+		want string
+	}{
+		{name: "interval", s: &targetSchedule{interval: time.Hour}, from: "2024-01-01 10:00", want: "2024-01-01 11:00"},
+		{name: "interval within active hours", s: &targetSchedule{interval: time.Hour, windows: windows}, from: "2024-01-01 10:00", want: "2024-01-01 11:00"},
+		{name: "interval past active hours", s: &targetSchedule{interval: time.Hour, windows: windows}, from: "2024-01-05 16:30", want: "2024-01-08 09:00"},
+		{name: "cron", s: &targetSchedule{cron: c}, from: "2024-01-01 10:10", want: "2024-01-01 10:30"},
+		{name: "cron past active hours", s: &targetSchedule{cron: c, windows: windows}, from: "2024-01-01 16:45", want: "2024-01-02 09:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := tt.s.next(at(t, tt.from)), at(t, tt.want); !got.Equal(want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got.Format(time.RFC3339), want.Format(time.RFC3339))
+			}
+		})
+	}
+}
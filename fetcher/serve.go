@@ -0,0 +1,992 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultListenAddress default address the API of serve listens on
+	DefaultListenAddress = "127.0.0.1:8080"
+
+	// LogNotifier only logs what serve would notify about
+	LogNotifier = "log"
+
+	// EmailNotifier emails what serve notifies about, like watch email
+	EmailNotifier = "email"
+
+	// DiscordNotifier posts what serve notifies about to a Discord webhook, like watch discord
+	DiscordNotifier = "discord"
+
+	serveTargetsKey     = "serve-targets"
+	serveHistoryPrefix  = "serve-history-"
+	serveHistorySize    = 100
+	serveMaxRequestSize = 1 << 20
+	serveStopTimeout    = 5 * time.Second
+	serveEventBuffer    = 64
+)
+
+var (
+	// servedKeys are the per-URL flags of watch that the targets of serve set
+	servedKeys = append([]string{"intervals", "schedules", "active_hours"}, perTargetKeys...)
+)
+
+// watchObserver is told about the runs of a watch - serve keeps the status of its targets with it
+type watchObserver interface {
+	scheduled(index int, at time.Time)
+	finished(index int, res *runResult)
+}
+
+type runResultContextKey struct{}
+
+// runResult is what a run left behind, collected by run when the context it is given carries one
+type runResult struct {
+	started  time.Time
+	duration time.Duration
+	err      error
+
+	// what of the page the run ended on to keep - the watch keeps both, only when the run failed
+	keepHTML        bool
+	keepScreenshot  bool
+	keepPageOnError bool
+
+	value      string
+	notified   bool
+	agent      string
+	proxy      string
+	status     int64
+	finalURL   string
+	html       string
+	screenshot []byte
+}
+
+func runResultFromContext(ctx context.Context) *runResult {
+	res, _ := ctx.Value(runResultContextKey{}).(*runResult)
+	return res
+}
+
+// collect keeps what the check of the run found and, if the browser is still up, what was asked for of the page it ended on
+func (res *runResult) collect(ctx context.Context, info *runInfo, runErr error) {
+	res.value = info.value
+	res.notified = info.notified
+	res.agent = info.agent
+	if info.proxy != nil {
+		res.proxy = info.proxy.url.Redacted()
+	}
+	res.status, res.finalURL = info.document.get()
+	if ctx.Err() != nil {
+		return
+	}
+
+	failed := res.keepPageOnError && runErr != nil
+	var page chromedp.Tasks
+	if res.keepHTML || failed {
+		page = append(page, chromedp.OuterHTML("html", &res.html, chromedp.ByQuery))
+	}
+	if res.keepScreenshot || failed {
+		page = append(page, chromedp.CaptureScreenshot(&res.screenshot))
+	}
+	if err := chromedp.Run(ctx, page...); err != nil {
+		Log().Errorf("Failed to keep the page of the run for URL [%s]: %v", info.targetURL, err)
+	}
+}
+
+// serveTarget is a target of serve - its fields are the per-URL flags of watch for a single URL
+type serveTarget struct {
+	ID                        string `json:"id"`
+	URL                       string `json:"url"`
+	WaitSelector              string `json:"wait_selector,omitempty"`
+	CheckSelector             string `json:"check_selector,omitempty"`
+	CheckType                 string `json:"check_type,omitempty"`
+	ExpectedText              string `json:"expected_text,omitempty"`
+	JSONURLPattern            string `json:"json_url_pattern,omitempty"`
+	ItemSelector              string `json:"item_selector,omitempty"`
+	ItemKey                   string `json:"item_key,omitempty"`
+	ItemFields                string `json:"item_fields,omitempty"`
+	Pagination                string `json:"pagination,omitempty"`
+	NextSelector              string `json:"next_selector,omitempty"`
+	PageURLTemplate           string `json:"page_url_template,omitempty"`
+	ResourcePolicy            string `json:"resource_policy,omitempty"`
+	Device                    string `json:"device,omitempty"`
+	VisualIgnoreRegions       string `json:"visual_ignore_regions,omitempty"`
+	NotifyPath                string `json:"notify_path,omitempty"`
+	CaptchaWaitSelector       string `json:"captcha_wait_selector,omitempty"`
+	CaptchaClickSelector      string `json:"captcha_click_selector,omitempty"`
+	CaptchaIframeWaitSelector string `json:"captcha_iframe_wait_selector,omitempty"`
+	Interval                  int    `json:"interval,omitempty"` // seconds
+	Schedule                  string `json:"schedule,omitempty"`
+	ActiveHours               string `json:"active_hours,omitempty"`
+	Paused                    bool   `json:"paused"`
+}
+
+// targetStatus is what the runs of a target found so far
+type targetStatus struct {
+	State      string     `json:"state"` // pending, ok, error or paused
+	Value      string     `json:"value,omitempty"`
+	Notified   bool       `json:"notified"`
+	Error      string     `json:"error,omitempty"`
+	Failures   int        `json:"failures"` // in a row
+	HTTPStatus int64      `json:"http_status,omitempty"`
+	Agent      string     `json:"agent,omitempty"`
+	Proxy      string     `json:"proxy,omitempty"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	NextRun    *time.Time `json:"next_run,omitempty"`
+
+	html       string
+	screenshot []byte
+	history    []historyPoint
+}
+
+// historyPoint is what a run of a target found, the last serveHistorySize of them are kept in the state store
+type historyPoint struct {
+	At    time.Time `json:"at"`
+	State string    `json:"state"`
+	Value string    `json:"value,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// targetView is a target with its status, as the API returns it
+type targetView struct {
+	*serveTarget
+	Status targetStatus `json:"status"`
+}
+
+// targetEvent is a finished run of a target, as it is sent to the subscribers of the server
+type targetEvent struct {
+	ID         string
+	URL        string
+	State      string // ok or error
+	Notified   bool
+	Value      string
+	Error      string
+	HTTPStatus int64
+	Time       time.Time
+	Duration   time.Duration
+}
+
+// apiError is an error the API answers with its own status code
+type apiError struct {
+	code int
+	msg  string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// watchServer runs the watch of serve and the API that manages its targets
+type watchServer struct {
+	w     *watchExecutor
+	l     *watchLifecycle
+	pool  *browserPool
+	token string
+
+	mu       sync.Mutex
+	targets  []*serveTarget // in the order they were added
+	active   []string       // ids of the targets that aren't paused, by their index in the watch
+	statuses map[string]*targetStatus
+
+	// told about every finished run, a subscriber that falls behind misses events
+	subscribers map[chan *targetEvent]bool
+}
+
+// values returns the value of the target for each of the servedKeys
+func (t *serveTarget) values() map[string]string {
+	var interval string
+	if t.Interval > 0 {
+		interval = strconv.Itoa(t.Interval)
+	}
+
+	return map[string]string{
+		"urls":                          t.URL,
+		"wait_selectors":                t.WaitSelector,
+		"check_selectors":               t.CheckSelector,
+		"check_types":                   t.CheckType,
+		"expected_texts":                t.ExpectedText,
+		"json_url_patterns":             t.JSONURLPattern,
+		"item_selectors":                t.ItemSelector,
+		"item_keys":                     t.ItemKey,
+		"item_fields":                   t.ItemFields,
+		"paginations":                   t.Pagination,
+		"next_selectors":                t.NextSelector,
+		"page_url_templates":            t.PageURLTemplate,
+		"resource_policies":             t.ResourcePolicy,
+		"devices":                       t.Device,
+		"visual_ignore_regions":         t.VisualIgnoreRegions,
+		"notify_paths":                  t.NotifyPath,
+		"captcha_wait_selectors":        t.CaptchaWaitSelector,
+		"captcha_click_selectors":       t.CaptchaClickSelector,
+		"captcha_iframe_wait_selectors": t.CaptchaIframeWaitSelector,
+		"intervals":                     interval,
+		"schedules":                     t.Schedule,
+		"active_hours":                  t.ActiveHours,
+	}
+}
+
+// validate checks what the watch checks can't, since they only see the flags the target sets
+func (t *serveTarget) validate() error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("A target requires an absolute http or https url, got [%s]", t.URL)
+	}
+	if t.Interval < 0 {
+		return fmt.Errorf("The interval of a target can't be negative")
+	}
+
+	return nil
+}
+
+// setTargetKeys sets the per-URL flags of the watch to the targets, in order - it returns what they were set to before
+func setTargetKeys(targets []*serveTarget) map[string][]string {
+	previous := map[string][]string{}
+	for _, k := range servedKeys {
+		previous[k] = viper.GetStringSlice(k)
+		values := make([]string, len(targets))
+		for i, t := range targets {
+			values[i] = t.values()[k]
+		}
+		viper.Set(k, values)
+	}
+
+	return previous
+}
+
+// PerTargetFlag reports whether the watch flag holds a value for each URL, which serve takes from its targets instead
+func PerTargetFlag(name string) bool {
+	for _, k := range servedKeys {
+		if k == name {
+			return true
+		}
+	}
+	return false
+}
+
+func activeTargets(targets []*serveTarget) []*serveTarget {
+	var active []*serveTarget
+	for _, t := range targets {
+		if !t.Paused {
+			active = append(active, t)
+		}
+	}
+	return active
+}
+
+func newTargetID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func loadServeTargets() ([]*serveTarget, error) {
+	data, ok, err := state().get(serveTargetsKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var targets []*serveTarget
+	if err = json.Unmarshal([]byte(data), &targets); err != nil {
+		return nil, fmt.Errorf("Failed to parse the stored targets: %v", err)
+	}
+	return targets, nil
+}
+
+func loadTargetHistory(id string) ([]historyPoint, error) {
+	data, ok, err := state().get(serveHistoryPrefix + id)
+	if err != nil || !ok || len(data) == 0 {
+		return nil, err
+	}
+	var history []historyPoint
+	if err = json.Unmarshal([]byte(data), &history); err != nil {
+		return nil, fmt.Errorf("Failed to parse the stored history of target [%s]: %v", id, err)
+	}
+	return history, nil
+}
+
+// saveTargetHistory stores the history of the target - an empty one clears it, since the store can't delete
+func saveTargetHistory(id string, history []historyPoint) error {
+	if len(history) == 0 {
+		return state().set(serveHistoryPrefix+id, "")
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return state().set(serveHistoryPrefix+id, string(data))
+}
+
+func saveServeTargets(targets []*serveTarget) error {
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	return state().set(serveTargetsKey, string(data))
+}
+
+// checkServeTargets validates the config of serve, which unlike that of watch may have no targets
+func checkServeTargets(cmd *cobra.Command) error {
+	if len(viper.GetStringSlice("urls")) == 0 {
+		return CommonRootChecks(cmd)
+	}
+	return CommonWatchChecks(cmd)
+}
+
+// CommonServeChecks checks the flags of the serve command
+func CommonServeChecks(cmd *cobra.Command) error {
+	viper.BindPFlags(cmd.Flags())
+
+	if len(viper.GetString("listen")) == 0 {
+		return fmt.Errorf("We require a non-empty listen address to serve the API on")
+	}
+	switch viper.GetString("notifier") {
+	case LogNotifier:
+	case EmailNotifier:
+		if len(viper.GetString("from")) == 0 || len(viper.GetString("to")) == 0 || len(viper.GetString("email_password")) == 0 {
+			return fmt.Errorf("We require a from and to email address and a non-empty email_password to notify by email")
+		}
+	case DiscordNotifier:
+		if len(viper.GetString("webhook")) == 0 {
+			return fmt.Errorf("We require a Discord webhook URL to notify on Discord")
+		}
+	default:
+		return fmt.Errorf("Unknown notifier [%s] - must be one of [%s], [%s] or [%s]", viper.GetString("notifier"), LogNotifier, EmailNotifier, DiscordNotifier)
+	}
+	if err := checkFetchFlags(); err != nil {
+		return err
+	}
+
+	return CommonRootChecks(cmd)
+}
+
+// serveNotifier starts the worker of the notifier and returns what builds the targets that notify it
+func serveNotifier(redisDumpOn bool) targetBuilder {
+	switch viper.GetString("notifier") {
+	case EmailNotifier:
+		emailMetaData := startEmailWorker()
+		return func() ([][]actionGenerator, []string, error) {
+			return watchTargets(emailMetaData, nil, redisDumpOn)
+		}
+	case DiscordNotifier:
+		username := viper.GetString("discord_username")
+		if len(username) == 0 {
+			username = "Go-Scraper Discord Alert"
+		}
+		discordMetaData := startDiscordWorker(viper.GetString("webhook"), username)
+		return func() ([][]actionGenerator, []string, error) {
+			return watchTargets(nil, discordMetaData, redisDumpOn)
+		}
+	default:
+		discordMetaData := make(chan discordData, workerQueueSize)
+		go func() {
+			for {
+				data := <-discordMetaData
+				Log().Infof("Notification for URL [%s]: %s", data.URL, data.Text)
+				handled()
+			}
+		}()
+		return func() ([][]actionGenerator, []string, error) {
+			return watchTargets(nil, discordMetaData, redisDumpOn)
+		}
+	}
+}
+
+// Serve runs the watch as a daemon, with its targets persisted in the state store and managed through a REST API, and returns the exit status
+func Serve(cmd *cobra.Command) int {
+	viper.BindPFlags(cmd.Flags())
+
+	redisDumpOn := viper.GetBool("redis_dumps")
+	if redisDumpOn {
+		setupRedis(cmd)
+	}
+	build := serveNotifier(redisDumpOn)
+	check := func() error { return checkServeTargets(cmd) }
+
+	targets, err := loadServeTargets()
+	if err != nil {
+		Log().Errorf("Failed to load the targets: %v", err)
+		return 1
+	}
+	active := activeTargets(targets)
+	setTargetKeys(active)
+	if err = check(); err != nil {
+		Log().Errorf("The stored targets are invalid: %v", err)
+		return 1
+	}
+	actionGens, urls, err := build()
+	if err != nil {
+		Log().Errorf("%v", err)
+		return 1
+	}
+
+	s := &watchServer{token: viper.GetString("api_token"), targets: targets, statuses: map[string]*targetStatus{}}
+	for _, t := range active {
+		s.active = append(s.active, t.ID)
+	}
+	for _, t := range targets {
+		history, err := loadTargetHistory(t.ID)
+		if err != nil {
+			Log().Errorf("%v", err)
+		}
+		if len(history) != 0 {
+			last := history[len(history)-1]
+			st := s.status(t.ID)
+			st.history, st.LastRun, st.State, st.Error = history, &last.At, last.State, last.Error
+			for i := len(history) - 1; i >= 0 && history[i].State == "error"; i-- {
+				st.Failures++
+			}
+			for i := len(history) - 1; i >= 0; i-- {
+				if history[i].State == "ok" {
+					st.Value = history[i].Value
+					break
+				}
+			}
+		}
+	}
+	e := executors["watch"].(*watchExecutor)
+	e.Init(actionGens, urls)
+	e.reloadWith(check, build)
+	e.observer = s
+	e.calls = make(chan func(q *runQueue) *runQueue)
+	e.pool = newBrowserPool(viper.GetInt("fetch_concurrency"), viper.GetInt("fetch_queue"))
+	s.w = e
+	s.pool = e.pool
+
+	ln, err := net.Listen("tcp", viper.GetString("listen"))
+	if err != nil {
+		Log().Errorf("Failed to listen on [%s]: %v", viper.GetString("listen"), err)
+		return 1
+	}
+	stopGRPC := func() {}
+	if address := viper.GetString("grpc_listen"); len(address) != 0 {
+		if stopGRPC, err = s.serveGRPC(address); err != nil {
+			ln.Close()
+			Log().Errorf("%v", err)
+			return 1
+		}
+	}
+	s.l = newWatchLifecycle()
+	srv := &http.Server{Handler: s.routes()}
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			Log().Errorf("The API stopped serving: %v", err)
+		}
+	}()
+	Log().Infof("Serving the API at [%s] for [%d] targets, [%d] of them paused", ln.Addr(), len(targets), len(targets)-len(active))
+
+	e.schedule(s.l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), serveStopTimeout)
+	srv.Shutdown(ctx)
+	cancel()
+	stopGRPC()
+	return s.l.shutdown()
+}
+
+func (s *watchServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /targets", s.list)
+	mux.HandleFunc("POST /targets", s.create)
+	mux.HandleFunc("GET /targets/{id}", s.get)
+	mux.HandleFunc("PUT /targets/{id}", s.update)
+	mux.HandleFunc("DELETE /targets/{id}", s.delete)
+	mux.HandleFunc("POST /targets/{id}/pause", s.pause)
+	mux.HandleFunc("POST /targets/{id}/resume", s.resume)
+	mux.HandleFunc("POST /targets/{id}/run", s.trigger)
+	mux.HandleFunc("GET /targets/{id}/dump", s.dump)
+	mux.HandleFunc("GET /targets/{id}/screenshot", s.screenshot)
+	mux.HandleFunc("GET /targets/{id}/history", s.history)
+	mux.HandleFunc("POST /fetch", s.fetch)
+	mux.Handle("GET /dashboard/", dashboardHandler())
+	mux.Handle("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
+
+	if len(s.token) == 0 {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the dashboard is only static files, it asks for the token to call the API with
+		if r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, "/dashboard/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			writeError(w, &apiError{http.StatusUnauthorized, "A valid bearer token is required"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// call runs the function in the watch between its runs and waits for it, so it can safely change the targets and the config
+func (s *watchServer) call(f func(q *runQueue) (*runQueue, error)) error {
+	done := make(chan error, 1)
+	call := func(q *runQueue) *runQueue {
+		q, err := f(q)
+		done <- err
+		return q
+	}
+
+	select {
+	case s.w.calls <- call:
+		return <-done
+	case <-s.l.stop.Done():
+		return &apiError{http.StatusServiceUnavailable, "The watch is stopping"}
+	}
+}
+
+// change edits a copy of the targets and applies it to the watch - the edit is only kept, and persisted, if the watch checks pass
+func (s *watchServer) change(edit func(targets []*serveTarget) ([]*serveTarget, error)) error {
+	return s.call(func(q *runQueue) (*runQueue, error) {
+		s.mu.Lock()
+		targets := append([]*serveTarget(nil), s.targets...)
+		s.mu.Unlock()
+
+		targets, err := edit(targets)
+		if err != nil {
+			return q, err
+		}
+
+		// the targets are set in the config, which fetches may be reading
+		gConfigMu.Lock()
+		defer gConfigMu.Unlock()
+		active := activeTargets(targets)
+		previous := setTargetKeys(active)
+		var actionGens [][]actionGenerator
+		var urls []string
+		err = s.w.check()
+		if err == nil {
+			actionGens, urls, err = s.w.build()
+		}
+		if err != nil {
+			for k, v := range previous {
+				viper.Set(k, v)
+			}
+			return q, &apiError{http.StatusBadRequest, err.Error()}
+		}
+		if err = saveServeTargets(targets); err != nil {
+			for k, v := range previous {
+				viper.Set(k, v)
+			}
+			return q, fmt.Errorf("Failed to persist the targets: %v", err)
+		}
+
+		s.mu.Lock()
+		s.targets = targets
+		s.active = nil
+		for _, t := range active {
+			s.active = append(s.active, t.ID)
+		}
+		kept := map[string]*targetStatus{}
+		for _, t := range targets {
+			if st, ok := s.statuses[t.ID]; ok {
+				kept[t.ID] = st
+			}
+		}
+		s.statuses = kept
+		s.mu.Unlock()
+
+		return s.w.applyTargets(actionGens, urls, q), nil
+	})
+}
+
+func (s *watchServer) scheduled(index int, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index < len(s.active) {
+		s.status(s.active[index]).NextRun = &at
+	}
+}
+
+func (s *watchServer) finished(index int, res *runResult) {
+	s.mu.Lock()
+	if index >= len(s.active) {
+		s.mu.Unlock()
+		return
+	}
+	id := s.active[index]
+	st := s.status(id)
+	st.LastRun = &res.started
+	st.Duration = res.duration.Round(time.Millisecond).String()
+	st.Notified = res.notified
+	st.HTTPStatus = res.status
+	if len(res.agent) != 0 {
+		st.Agent, st.Proxy = res.agent, res.proxy
+	}
+	if res.err != nil {
+		// the value is kept from the last run that found one
+		st.State, st.Error = "error", res.err.Error()
+		st.Failures++
+	} else {
+		st.State, st.Error, st.Value = "ok", "", res.value
+		st.Failures = 0
+	}
+	st.history = append(st.history, historyPoint{At: res.started, State: st.State, Value: res.value, Error: st.Error})
+	if len(st.history) > serveHistorySize {
+		st.history = st.history[len(st.history)-serveHistorySize:]
+	}
+	history := append([]historyPoint(nil), st.history...)
+	if len(res.html) != 0 {
+		st.html = res.html
+	}
+	if len(res.screenshot) != 0 {
+		st.screenshot = res.screenshot
+	}
+
+	ev := &targetEvent{ID: id, State: st.State, Notified: res.notified, Value: res.value, Error: st.Error, HTTPStatus: res.status, Time: res.started, Duration: res.duration}
+	for _, t := range s.targets {
+		if t.ID == ev.ID {
+			ev.URL = t.URL
+		}
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			Log().Errorf("A subscriber fell behind, it misses the run of target [%s]", ev.ID)
+		}
+	}
+	s.mu.Unlock()
+
+	if err := saveTargetHistory(id, history); err != nil {
+		Log().Errorf("Failed to save the history of target [%s]: %v", id, err)
+	}
+}
+
+// subscribe returns a channel that gets the events of the finished runs, until the returned function is called
+func (s *watchServer) subscribe() (<-chan *targetEvent, func()) {
+	ch := make(chan *targetEvent, serveEventBuffer)
+	s.mu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = map[chan *targetEvent]bool{}
+	}
+	s.subscribers[ch] = true
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// status returns the status of the target, creating it if needed - must hold mu
+func (s *watchServer) status(id string) *targetStatus {
+	st, ok := s.statuses[id]
+	if !ok {
+		st = &targetStatus{State: "pending"}
+		s.statuses[id] = st
+	}
+	return st
+}
+
+// view returns the target with the id and its status - must hold mu
+func (s *watchServer) view(id string) (*targetView, bool) {
+	for _, t := range s.targets {
+		if t.ID != id {
+			continue
+		}
+		v := &targetView{serveTarget: t, Status: targetStatus{State: "pending"}}
+		if st, ok := s.statuses[id]; ok {
+			v.Status = *st
+		}
+		if t.Paused {
+			v.Status.State, v.Status.NextRun = "paused", nil
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+func (s *watchServer) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	views := make([]*targetView, 0, len(s.targets))
+	for _, t := range s.targets {
+		v, _ := s.view(t.ID)
+		views = append(views, v)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *watchServer) get(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	v, ok := s.view(r.PathValue("id"))
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, targetNotFound(r.PathValue("id")))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (s *watchServer) create(w http.ResponseWriter, r *http.Request) {
+	t, err := readTarget(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(t.ID) == 0 {
+		t.ID = newTargetID()
+	}
+
+	err = s.change(func(targets []*serveTarget) ([]*serveTarget, error) {
+		for _, o := range targets {
+			if o.ID == t.ID {
+				return nil, &apiError{http.StatusConflict, fmt.Sprintf("Target [%s] already exists", t.ID)}
+			}
+		}
+		return append(targets, t), nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	Log().Infof("Added target [%s] for URL [%s] through the API", t.ID, t.URL)
+	s.respond(w, http.StatusCreated, t.ID)
+}
+
+func (s *watchServer) update(w http.ResponseWriter, r *http.Request) {
+	t, err := readTarget(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id := r.PathValue("id")
+	if len(t.ID) != 0 && t.ID != id {
+		writeError(w, &apiError{http.StatusBadRequest, fmt.Sprintf("The id [%s] of the target doesn't match the id [%s] of the path", t.ID, id)})
+		return
+	}
+	t.ID = id
+
+	err = s.change(func(targets []*serveTarget) ([]*serveTarget, error) {
+		for i, o := range targets {
+			if o.ID == id {
+				targets[i] = t
+				return targets, nil
+			}
+		}
+		return nil, targetNotFound(id)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	Log().Infof("Updated target [%s] for URL [%s] through the API", t.ID, t.URL)
+	s.respond(w, http.StatusOK, id)
+}
+
+func (s *watchServer) delete(w http.ResponseWriter, r *http.Request) {
+	if err := s.deleteTarget(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *watchServer) deleteTarget(id string) error {
+	err := s.change(func(targets []*serveTarget) ([]*serveTarget, error) {
+		for i, o := range targets {
+			if o.ID == id {
+				return append(targets[:i], targets[i+1:]...), nil
+			}
+		}
+		return nil, targetNotFound(id)
+	})
+	if err != nil {
+		return err
+	}
+	Log().Infof("Deleted target [%s] through the API", id)
+	if err = saveTargetHistory(id, nil); err != nil {
+		Log().Errorf("Failed to clear the history of target [%s]: %v", id, err)
+	}
+	return nil
+}
+
+func (s *watchServer) pause(w http.ResponseWriter, r *http.Request) {
+	s.setPaused(w, r.PathValue("id"), true)
+}
+
+func (s *watchServer) resume(w http.ResponseWriter, r *http.Request) {
+	s.setPaused(w, r.PathValue("id"), false)
+}
+
+func (s *watchServer) setPaused(w http.ResponseWriter, id string, paused bool) {
+	err := s.change(func(targets []*serveTarget) ([]*serveTarget, error) {
+		for i, o := range targets {
+			if o.ID == id {
+				t := *o
+				t.Paused = paused
+				targets[i] = &t
+				return targets, nil
+			}
+		}
+		return nil, targetNotFound(id)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	Log().Infof("Set paused to [%t] for target [%s] through the API", paused, id)
+	s.respond(w, http.StatusOK, id)
+}
+
+// trigger moves the next run of the target to now
+func (s *watchServer) trigger(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := s.call(func(q *runQueue) (*runQueue, error) {
+		s.mu.Lock()
+		_, exists := s.view(id)
+		index := -1
+		for i, a := range s.active {
+			if a == id {
+				index = i
+			}
+		}
+		s.mu.Unlock()
+		if !exists {
+			return q, targetNotFound(id)
+		}
+		if index < 0 {
+			return q, &apiError{http.StatusConflict, fmt.Sprintf("Target [%s] is paused, resume it first", id)}
+		}
+
+		for i := range *q {
+			if (*q)[i].index == index {
+				(*q)[i].at = time.Now()
+				s.scheduled(index, (*q)[i].at)
+				return q, nil
+			}
+		}
+		return q, &apiError{http.StatusConflict, fmt.Sprintf("Target [%s] is already running", id)}
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	Log().Infof("Triggered a run of target [%s] through the API", id)
+	s.respond(w, http.StatusAccepted, id)
+}
+
+func (s *watchServer) dump(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, exists := s.view(r.PathValue("id"))
+	var html string
+	if st, ok := s.statuses[r.PathValue("id")]; ok {
+		html = st.html
+	}
+	s.mu.Unlock()
+	if !exists {
+		writeError(w, targetNotFound(r.PathValue("id")))
+		return
+	}
+	if len(html) == 0 {
+		writeError(w, &apiError{http.StatusNotFound, fmt.Sprintf("Target [%s] has no dump of a failed run", r.PathValue("id"))})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
+
+func (s *watchServer) screenshot(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, exists := s.view(r.PathValue("id"))
+	var shot []byte
+	if st, ok := s.statuses[r.PathValue("id")]; ok {
+		shot = st.screenshot
+	}
+	s.mu.Unlock()
+	if !exists {
+		writeError(w, targetNotFound(r.PathValue("id")))
+		return
+	}
+	if len(shot) == 0 {
+		writeError(w, &apiError{http.StatusNotFound, fmt.Sprintf("Target [%s] has no screenshot of a failed run", r.PathValue("id"))})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(shot)
+}
+
+// history returns what the last runs of the target found, oldest first
+func (s *watchServer) history(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, exists := s.view(r.PathValue("id"))
+	history := []historyPoint{}
+	if st, ok := s.statuses[r.PathValue("id")]; ok {
+		history = append(history, st.history...)
+	}
+	s.mu.Unlock()
+	if !exists {
+		writeError(w, targetNotFound(r.PathValue("id")))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// respond writes the target with the id and its status
+func (s *watchServer) respond(w http.ResponseWriter, code int, id string) {
+	s.mu.Lock()
+	v, ok := s.view(id)
+	s.mu.Unlock()
+	if !ok {
+		// deleted in the meantime
+		w.WriteHeader(code)
+		return
+	}
+
+	writeJSON(w, code, v)
+}
+
+func readTarget(w http.ResponseWriter, r *http.Request) (*serveTarget, error) {
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, serveMaxRequestSize))
+	d.DisallowUnknownFields()
+	t := &serveTarget{}
+	if err := d.Decode(t); err != nil {
+		return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Invalid target: %v", err)}
+	}
+	if err := t.validate(); err != nil {
+		return nil, &apiError{http.StatusBadRequest, err.Error()}
+	}
+	return t, nil
+}
+
+func targetNotFound(id string) error {
+	return &apiError{http.StatusNotFound, fmt.Sprintf("No target [%s]", id)}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		Log().Errorf("Failed to write API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var e *apiError
+	if errors.As(err, &e) {
+		code = e.code
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,250 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+)
+
+const (
+	// DefaultStateFile default file that watch state is persisted to between runs
+	DefaultStateFile = ".go-scraper-state.json"
+
+	// FileStateBackend persists state to the state_file
+	FileStateBackend = "file"
+
+	// RedisStateBackend persists state to the redis instance at redis_url
+	RedisStateBackend = "redis"
+
+	redisStateKeyPrefix = "state-"
+
+	// redisBlobKeyPrefix is where blobs are kept under the state prefix, so they can't collide with a state key
+	redisBlobKeyPrefix = redisStateKeyPrefix + "blob-"
+
+	// blobDirSuffix is added to the state_file for the directory the file backend keeps blobs in
+	blobDirSuffix = ".blobs"
+)
+
+var (
+	gState   stateStore
+	gStateMu sync.Mutex
+)
+
+// stateStore persists what watches have seen, so it survives between ticks and restarts
+// large binary values, like screenshots, are kept as blobs beside the state, which only refers to them by name
+type stateStore interface {
+	get(key string) (string, bool, error)
+	set(key string, value string) error
+
+	getBlob(name string) ([]byte, bool, error)
+	setBlob(name string, data []byte) error
+	deleteBlob(name string) error
+}
+
+// fileStateStore keeps all state in a single JSON file
+type fileStateStore struct {
+	path string
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// redisStateStore keeps each state value under its own redis key
+type redisStateStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// state returns the configured state store, creating it on first use
+func state() stateStore {
+	gStateMu.Lock()
+	defer gStateMu.Unlock()
+
+	if gState != nil {
+		return gState
+	}
+
+	switch viper.GetString("state_backend") {
+	case RedisStateBackend:
+		Log().Infof("Persisting state to redis instance running at [%s]", viper.GetString("redis_url"))
+		gState = &redisStateStore{
+			client: redis.NewClient(&redis.Options{
+				Addr:     viper.GetString("redis_url"),
+				Password: viper.GetString("redis_password"),
+			}),
+			timeout: time.Duration(viper.GetInt("redis_write_timeout")) * time.Second,
+		}
+	default:
+		path := viper.GetString("state_file")
+		if len(path) == 0 {
+			path = DefaultStateFile
+		}
+		Log().Infof("Persisting state to file [%s]", path)
+		s := &fileStateStore{path: path, values: map[string]string{}}
+		if err := s.load(); err != nil {
+			Log().Errorf("Failed to load state file [%s], starting with empty state: %v", path, err)
+		}
+		gState = s
+	}
+
+	return gState
+}
+
+// checkStateKey returns the state key of a change check on the URL - the URL alone would be shared by every check of the page, so the key ends with a hash of what the check watches on it
+func checkStateKey(prefix string, u string, watched ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(watched, "\x00")))
+	return prefix + u + "-" + hex.EncodeToString(sum[:8])
+}
+
+// checkStateBackend validates the state backend flags
+func checkStateBackend() error {
+	switch viper.GetString("state_backend") {
+	case "", FileStateBackend:
+	case RedisStateBackend:
+		if len(viper.GetString("redis_url")) == 0 {
+			return fmt.Errorf("We require a valid redis_url to keep state in redis, specify one")
+		}
+	default:
+		return fmt.Errorf("Unknown state_backend [%s] - must be one of [%s] or [%s]", viper.GetString("state_backend"), FileStateBackend, RedisStateBackend)
+	}
+
+	return nil
+}
+
+func (f *fileStateStore) load() error {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &f.values)
+}
+
+func (f *fileStateStore) get(key string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.values[key]
+	return v, ok, nil
+}
+
+func (f *fileStateStore) set(key string, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.values[key] = value
+	data, err := json.MarshalIndent(f.values, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(f.path, data)
+}
+
+func (f *fileStateStore) getBlob(name string) ([]byte, bool, error) {
+	data, err := os.ReadFile(filepath.Join(f.path+blobDirSuffix, name))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (f *fileStateStore) setBlob(name string, data []byte) error {
+	dir := f.path + blobDirSuffix
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, name), data)
+}
+
+func (f *fileStateStore) deleteBlob(name string) error {
+	err := os.Remove(filepath.Join(f.path+blobDirSuffix, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// writeFileAtomic writes the file then renames it into place, so a crash mid-write doesn't lose what was there before
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (r *redisStateStore) get(key string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	v, err := r.client.Get(ctx, redisStateKeyPrefix+key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (r *redisStateStore) set(key string, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return r.client.Set(ctx, redisStateKeyPrefix+key, value, 0).Err()
+}
+
+func (r *redisStateStore) getBlob(name string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	v, err := r.client.Get(ctx, redisBlobKeyPrefix+name).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+func (r *redisStateStore) setBlob(name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return r.client.Set(ctx, redisBlobKeyPrefix+name, data, 0).Err()
+}
+
+func (r *redisStateStore) deleteBlob(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return r.client.Del(ctx, redisBlobKeyPrefix+name).Err()
+}
@@ -0,0 +1,116 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// ClickStep clicks the element of the selector
+	ClickStep = "click"
+
+	// TypeStep types the value into the element of the selector
+	TypeStep = "type"
+
+	// WaitStep waits for the element of the selector to be visible
+	WaitStep = "wait"
+
+	// SleepStep waits for the seconds of the step
+	SleepStep = "sleep"
+
+	// ScrollStep scrolls the element of the selector into view, or to the bottom of the page without one
+	ScrollStep = "scroll"
+
+	// EvalStep evaluates the value as a script, the way fetch --eval does
+	EvalStep = "eval"
+
+	maxSteps     = 50
+	maxStepSleep = 60
+)
+
+// pageStep is a scripted step taken on the page after it loaded, to get it into the state its content is extracted in
+type pageStep struct {
+	Action   string  `json:"action"`
+	Selector string  `json:"selector,omitempty"`
+	Value    string  `json:"value,omitempty"`
+	Seconds  float64 `json:"seconds,omitempty"`
+}
+
+// stepActions takes the steps in order, failing the run at the first one that fails
+type stepActions struct {
+	url   string
+	steps []pageStep
+}
+
+// checkSteps validates the steps - scripts can't be loaded from files, since the steps may come from a request
+func checkSteps(steps []pageStep) error {
+	if len(steps) > maxSteps {
+		return fmt.Errorf("At most [%d] steps can be taken, got [%d]", maxSteps, len(steps))
+	}
+	for i, s := range steps {
+		switch s.Action {
+		case ClickStep, TypeStep, WaitStep:
+			if len(s.Selector) == 0 {
+				return fmt.Errorf("Step [%d] requires a selector to %s", i, s.Action)
+			}
+		case SleepStep:
+			if s.Seconds <= 0 || s.Seconds > maxStepSleep {
+				return fmt.Errorf("Step [%d] requires seconds to sleep for, more than 0 and at most [%d]", i, maxStepSleep)
+			}
+		case ScrollStep:
+		case EvalStep:
+			if len(s.Value) == 0 {
+				return fmt.Errorf("Step [%d] requires a script to evaluate as its value", i)
+			}
+			if strings.HasPrefix(s.Value, scriptFilePrefix) {
+				return fmt.Errorf("Step [%d] can't load its script from a file", i)
+			}
+		default:
+			return fmt.Errorf("Unknown action [%s] for step [%d] - must be one of [%s], [%s], [%s], [%s], [%s] or [%s]", s.Action, i, ClickStep, TypeStep, WaitStep, SleepStep, ScrollStep, EvalStep)
+		}
+	}
+
+	return nil
+}
+
+func (s stepActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
+	for i, step := range s.steps {
+		i, step := i, step
+		actions = append(actions,
+			chromedp.ActionFunc(func(ctx context.Context) error {
+				Log().Infof("Taking step [%d] [%s] for URL [%s]", i, step.Action, s.url)
+
+				var err error
+				switch step.Action {
+				case ClickStep:
+					err = chromedp.Click(step.Selector, chromedp.NodeVisible).Do(ctx)
+				case TypeStep:
+					err = chromedp.SendKeys(step.Selector, step.Value, chromedp.NodeVisible).Do(ctx)
+				case WaitStep:
+					err = chromedp.WaitVisible(step.Selector).Do(ctx)
+				case SleepStep:
+					err = chromedp.Sleep(time.Duration(step.Seconds * float64(time.Second))).Do(ctx)
+				case ScrollStep:
+					if len(step.Selector) != 0 {
+						err = chromedp.ScrollIntoView(step.Selector).Do(ctx)
+					} else {
+						err = chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight)`, nil).Do(ctx)
+					}
+				case EvalStep:
+					_, err = evaluateScript(ctx, step.Value)
+				}
+				if err != nil {
+					err = fmt.Errorf("Step [%d] [%s] failed for URL [%s]: %v", i, step.Action, s.url, err)
+					Log().Errorf("%v", err)
+				}
+
+				return err
+			}))
+	}
+
+	return actions
+}
@@ -0,0 +1,199 @@
+package fetcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// configSettleTime is how long the config file has to stay unchanged before it is reloaded, editors often write it more than once
+const configSettleTime = 500 * time.Millisecond
+
+var (
+	// perTargetKeys are the flags that hold a value for each URL of the watch, in the order of the urls
+	perTargetKeys = []string{
+		"urls", "wait_selectors", "check_selectors", "check_types", "expected_texts", "json_url_patterns",
+		"item_selectors", "item_keys", "item_fields", "paginations", "next_selectors", "page_url_templates",
+		"resource_policies", "devices", "visual_ignore_regions", "notify_paths",
+		"captcha_wait_selectors", "captcha_click_selectors", "captcha_iframe_wait_selectors",
+	}
+
+	// liveKeys are applied to the running targets by a reload, so a change to them doesn't rebuild a target
+	liveKeys = map[string]bool{
+		"config": true, "log_level": true, "timeout": true, "shutdown_timeout": true, "watch_config": true,
+		"rate_limit": true, "rate_burst": true, "backoff_after": true, "backoff_base": true, "max_backoff": true, "backoff_jitter": true,
+		"robots": true, "robots_agent": true, "robots_cache_ttl": true,
+		"interval": true, "intervals": true, "interval_jitter": true, "schedules": true, "active_hours": true,
+	}
+)
+
+// targetBuilder builds the actions for each URL of the watch from the config
+type targetBuilder func() ([][]actionGenerator, []string, error)
+
+// reloadWith lets a reload rebuild the targets of the watch, validating the config with the checks of the command first
+func (w *watchExecutor) reloadWith(check func() error, build targetBuilder) {
+	w.check = check
+	w.build = build
+}
+
+// targetSignature identifies everything the URL at the index is built from, two targets with the same signature have the same actions
+func targetSignature(i int) string {
+	settings := viper.AllSettings()
+	for k := range liveKeys {
+		delete(settings, k)
+	}
+	for _, k := range perTargetKeys {
+		var v string
+		if values := viper.GetStringSlice(k); i < len(values) {
+			v = values[i]
+		}
+		settings[k] = v
+	}
+
+	// maps are printed sorted by key
+	return fmt.Sprint(settings)
+}
+
+// applyTargets swaps in the targets of a reloaded config - targets that didn't change keep their actions, and so what they saw so far, and their place in the queue
+func (w *watchExecutor) applyTargets(actionGens [][]actionGenerator, urls []string, q *runQueue) *runQueue {
+	queued := map[int]time.Time{}
+	for _, r := range *q {
+		queued[r.index] = r.at
+	}
+
+	unused := map[string][]int{}
+	for i, sig := range w.signatures {
+		unused[sig] = append(unused[sig], i)
+	}
+
+	actions := make([]chromedp.Tasks, len(urls))
+	signatures := make([]string, len(urls))
+	kept := make([]int, len(urls))
+	for i := range urls {
+		signatures[i] = targetSignature(i)
+		kept[i] = -1
+		if old := unused[signatures[i]]; len(old) != 0 {
+			kept[i] = old[0]
+			unused[signatures[i]] = old[1:]
+			actions[i] = w.actions[old[0]]
+		} else {
+			actions[i] = generate(actionGens[i])
+		}
+	}
+
+	removed := map[string]int{}
+	for _, old := range unused {
+		for _, i := range old {
+			removed[w.urls[i]]++
+		}
+	}
+	for i, u := range urls {
+		if kept[i] >= 0 {
+			continue
+		}
+		if removed[u] > 0 {
+			removed[u]--
+			Log().Infof("Target for URL [%s] changed, rebuilt it", u)
+		} else {
+			Log().Infof("Added target for URL [%s]", u)
+		}
+	}
+	for u, n := range removed {
+		for ; n > 0; n-- {
+			Log().Infof("Removed target for URL [%s]", u)
+		}
+	}
+
+	// a run in flight follows its target, which is scheduled again once the run finished
+	moved := map[int]int{}
+	for i, old := range kept {
+		if old >= 0 {
+			moved[old] = i
+		}
+	}
+	running := map[int]bool{}
+	for _, r := range w.running {
+		if i, ok := moved[r.index]; ok && r.index >= 0 {
+			r.index = i
+			running[i] = true
+		} else {
+			r.index = -1
+		}
+	}
+
+	w.urls = urls
+	w.actions = actions
+	w.signatures = signatures
+	w.schedules = nil
+	next := &runQueue{}
+	now := time.Now()
+	for i := range urls {
+		// the schedules are validated with the rest of the config, so an error can't happen here
+		sched, _ := newTargetSchedule(i)
+		w.schedules = append(w.schedules, sched)
+		Log().Infof("Will check URL [%s] %s", urls[i], sched.describe())
+
+		if running[i] {
+			continue
+		}
+		at, ok := queued[kept[i]]
+		if kept[i] < 0 || !ok {
+			at = sched.first(now)
+		}
+		r := scheduledRun{index: i, at: at}
+		*next = append(*next, r)
+		w.scheduled(r)
+	}
+
+	return next
+}
+
+// watchConfigFile reloads the watch whenever its config file changes, the same way SIGHUP does
+func (l *watchLifecycle) watchConfigFile() error {
+	path := viper.ConfigFileUsed()
+	if len(path) == 0 {
+		return fmt.Errorf("No config file was loaded at start up, so there is nothing to watch")
+	}
+	path = filepath.Clean(path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Failed to watch config file [%s]: %v", path, err)
+	}
+	// the directory is watched, since editors often replace the file rather than write to it
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("Failed to watch config file [%s]: %v", path, err)
+	}
+	Log().Infof("Watching config file [%s] for changes", path)
+
+	go func() {
+		defer watcher.Close()
+		var settle *time.Timer
+		for {
+			select {
+			case <-l.stop.Done():
+				return
+			case err := <-watcher.Errors:
+				Log().Errorf("Error watching config file [%s]: %v", path, err)
+			case ev := <-watcher.Events:
+				if filepath.Clean(ev.Name) != path || !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+					continue
+				}
+				if settle != nil {
+					settle.Stop()
+				}
+				settle = time.AfterFunc(configSettleTime, func() {
+					Log().Infof("Config file [%s] changed, reloading the config", path)
+					l.requestReload()
+				})
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,398 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/viper"
+)
+
+const (
+	// PixelVisualMode counts the pixels that differ between screenshots
+	PixelVisualMode = "pixel"
+
+	// PerceptualVisualMode compares perceptual hashes of the screenshots, which ignores small rendering differences
+	PerceptualVisualMode = "perceptual"
+
+	// DefaultVisualThreshold default percentage of changed pixels that counts as a change in pixel mode
+	DefaultVisualThreshold = 0.5
+
+	// DefaultVisualPixelTolerance default per channel difference (0-255) below which a pixel is considered unchanged
+	DefaultVisualPixelTolerance = 16
+
+	// DefaultVisualHashDistance default number of differing perceptual hash bits (out of 64) that counts as a change in perceptual mode
+	DefaultVisualHashDistance = 5
+
+	// viewportSelector is the check selector that screenshots the whole viewport rather than an element
+	viewportSelector = "viewport"
+
+	visualStateKeyPrefix = "visual-snapshot-"
+)
+
+var (
+	visualChangeColor = color.RGBA{R: 255, A: 255}
+	visualMaskColor   = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+)
+
+// visualSnapshot is the last screenshot we took of a visual check - the PNG is a blob of the state store, named by the hashes of the state key and the image
+type visualSnapshot struct {
+	Blob string    `json:"blob"`
+	At   time.Time `json:"at"`
+}
+
+// visualDiffCheck notifies with an image highlighting what changed whenever a screenshot of an element or the viewport differs from the previous run
+type visualDiffCheck struct {
+	url       string
+	selector  string // CSS selector of the element, or viewport
+	mode      string
+	threshold float64
+	tolerance int
+	distance  int
+	masks     []image.Rectangle
+}
+
+// newVisualDiffCheck builds a visual check for the URL at index i using the visual_* flags
+func newVisualDiffCheck(u string, selector string, i int) (visualDiffCheck, error) {
+	var regions string
+	if s := viper.GetStringSlice("visual_ignore_regions"); len(s) > i {
+		regions = s[i]
+	}
+	masks, err := parseVisualRegions(regions)
+	if err != nil {
+		return visualDiffCheck{}, fmt.Errorf("Invalid visual_ignore_regions for URL [%s]: %v", u, err)
+	}
+
+	mode := viper.GetString("visual_mode")
+	if len(mode) == 0 {
+		mode = PixelVisualMode
+	}
+
+	return visualDiffCheck{
+		url:       u,
+		selector:  selector,
+		mode:      mode,
+		threshold: viper.GetFloat64("visual_threshold"),
+		tolerance: viper.GetInt("visual_pixel_tolerance"),
+		distance:  viper.GetInt("visual_hash_distance"),
+		masks:     masks,
+	}, nil
+}
+
+// parseVisualRegions parses regions of the form x:y:width:height, separated by |
+func parseVisualRegions(regions string) ([]image.Rectangle, error) {
+	var res []image.Rectangle
+	for _, r := range strings.Split(regions, itemFieldSeparator) {
+		if r = strings.TrimSpace(r); len(r) == 0 {
+			continue
+		}
+
+		parts := strings.Split(r, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("Region [%s] must be of the form x:y:width:height", r)
+		}
+		var n [4]int
+		for i, p := range parts {
+			v, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Region [%s] must only contain non-negative integers", r)
+			}
+			n[i] = v
+		}
+		res = append(res, image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3]))
+	}
+
+	return res, nil
+}
+
+// checkVisualFlags validates the visual flags for watch
+func checkVisualFlags(urls []string, checkTypes []string) error {
+	switch viper.GetString("visual_mode") {
+	case "", PixelVisualMode, PerceptualVisualMode:
+	default:
+		return fmt.Errorf("Unknown visual_mode [%s] - must be one of [%s] or [%s]", viper.GetString("visual_mode"), PixelVisualMode, PerceptualVisualMode)
+	}
+
+	regions := viper.GetStringSlice("visual_ignore_regions")
+	if len(regions) != 0 && len(regions) != len(urls) {
+		return fmt.Errorf("Number of URLs and visual_ignore_regions passed in must have the same length")
+	}
+	for i, r := range regions {
+		if _, err := parseVisualRegions(r); err != nil {
+			return fmt.Errorf("Invalid visual_ignore_regions for URL [%s]: %v", urls[i], err)
+		}
+		if len(r) != 0 && checkTypes[i] != "visual" {
+			return fmt.Errorf("visual_ignore_regions for URL [%s] are only used with the visual check type", urls[i])
+		}
+	}
+
+	return nil
+}
+
+func (v visualDiffCheck) detect(ctx context.Context) (*changeNotice, error) {
+	var buf []byte
+	var err error
+	if len(v.selector) == 0 || v.selector == viewportSelector {
+		err = chromedp.CaptureScreenshot(&buf).Do(ctx)
+	} else {
+		err = chromedp.Screenshot(v.selector, &buf, chromedp.ByQuery, chromedp.NodeVisible).Do(ctx)
+	}
+	if err != nil {
+		Log().Errorf("Failed to take screenshot of [%s] for URL [%s]: %v", v.region(), v.url, err)
+		return nil, err
+	}
+	current, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		Log().Errorf("Failed to decode screenshot of [%s] for URL [%s]: %v", v.region(), v.url, err)
+		return nil, err
+	}
+
+	key := checkStateKey(visualStateKeyPrefix, v.url, v.region())
+	raw, found, err := state().get(key)
+	if err != nil {
+		Log().Errorf("Failed to load screenshot for URL [%s]: %v", v.url, err)
+		return nil, err
+	}
+	var snap visualSnapshot
+	var previous image.Image
+	if found {
+		var img []byte
+		var ok bool
+		if err = json.Unmarshal([]byte(raw), &snap); err == nil {
+			img, ok, err = state().getBlob(snap.Blob)
+		}
+		if err == nil && !ok {
+			err = fmt.Errorf("screenshot [%s] is missing", snap.Blob)
+		}
+		if err == nil {
+			previous, err = png.Decode(bytes.NewReader(img))
+		}
+		if err != nil {
+			Log().Errorf("Screenshot for URL [%s] is corrupt, starting over: %v", v.url, err)
+			found = false
+		}
+	}
+
+	var notice *changeNotice
+	if found {
+		notice = v.compare(previous, current, snap.At)
+	}
+	if found && notice == nil {
+		// keep the previous screenshot as the reference, so slow drifts below the threshold still add up to a change
+		return nil, nil
+	}
+
+	// named by the state key and the content, so no other check shares it, and deleting it when it is replaced can't take another check's screenshot
+	keySum, sum := sha256.Sum256([]byte(key)), sha256.Sum256(buf)
+	blob := hex.EncodeToString(keySum[:8]) + "-" + hex.EncodeToString(sum[:]) + ".png"
+	if err = state().setBlob(blob, buf); err != nil {
+		Log().Errorf("Failed to save screenshot for URL [%s]: %v", v.url, err)
+		return nil, err
+	}
+	data, err := json.Marshal(visualSnapshot{Blob: blob, At: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+	if err = state().set(key, string(data)); err != nil {
+		Log().Errorf("Failed to save screenshot for URL [%s]: %v", v.url, err)
+		return nil, err
+	}
+	if len(snap.Blob) != 0 && snap.Blob != blob {
+		if err = state().deleteBlob(snap.Blob); err != nil {
+			Log().Errorf("Failed to delete the previous screenshot for URL [%s]: %v", v.url, err)
+		}
+	}
+
+	if !found {
+		Log().Infof("First run for visual URL [%s], recorded a screenshot of [%s] as the baseline", v.url, v.region())
+	}
+
+	return notice, nil
+}
+
+// compare returns a notice with an image highlighting what changed, or nil if the change is below the threshold
+func (v visualDiffCheck) compare(previous image.Image, current image.Image, since time.Time) *changeNotice {
+	if previous.Bounds().Size() != current.Bounds().Size() {
+		Log().Infof("Screenshot of [%s] for URL [%s] changed size from [%v] to [%v]", v.region(), v.url, previous.Bounds().Size(), current.Bounds().Size())
+		return &changeNotice{
+			text:  fmt.Sprintf("Screenshot of %s changed size from %v to %v since %s", v.region(), previous.Bounds().Size(), current.Bounds().Size(), since.Format(time.RFC3339)),
+			image: v.highlight(current, nil, current.Bounds()),
+		}
+	}
+
+	changed, total, box, mask := v.pixelDiff(previous, current)
+	percent := 0.0
+	if total != 0 {
+		percent = 100 * float64(changed) / float64(total)
+	}
+
+	var text string
+	switch v.mode {
+	case PerceptualVisualMode:
+		d := bits.OnesCount64(v.hash(previous) ^ v.hash(current))
+		if d <= v.distance {
+			Log().Infof("Screenshot of [%s] for URL [%s] is perceptually the same as at [%s], hash distance [%d] of at most [%d]", v.region(), v.url, since.Format(time.RFC3339), d, v.distance)
+			return nil
+		}
+		text = fmt.Sprintf("Screenshot of %s changed since %s, perceptual hash distance %d (%.2f%% of pixels)", v.region(), since.Format(time.RFC3339), d, percent)
+	default:
+		if changed == 0 || percent < v.threshold {
+			Log().Infof("Screenshot of [%s] for URL [%s] changed by [%.2f%%] since [%s], below the threshold of [%.2f%%]", v.region(), v.url, percent, since.Format(time.RFC3339), v.threshold)
+			return nil
+		}
+		text = fmt.Sprintf("Screenshot of %s changed since %s, %.2f%% of pixels (%d of %d) differ", v.region(), since.Format(time.RFC3339), percent, changed, total)
+	}
+	Log().Infof("Screenshot of [%s] for URL [%s] changed since the last check", v.region(), v.url)
+
+	return &changeNotice{text: text, image: v.highlight(current, mask, box)}
+}
+
+// pixelDiff returns the number of changed pixels outside the masks, how many pixels were compared, the bounding box of the changes and the changed pixels themselves
+func (v visualDiffCheck) pixelDiff(previous image.Image, current image.Image) (int, int, image.Rectangle, []bool) {
+	b := current.Bounds()
+	po := previous.Bounds().Min
+	mask := make([]bool, b.Dx()*b.Dy())
+
+	changed, total := 0, 0
+	var box image.Rectangle
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := image.Pt(x-b.Min.X, y-b.Min.Y)
+			if v.masked(p) {
+				continue
+			}
+			total++
+
+			r1, g1, b1, _ := previous.At(po.X+p.X, po.Y+p.Y).RGBA()
+			r2, g2, b2, _ := current.At(x, y).RGBA()
+			if channelDiff(r1, r2) <= v.tolerance && channelDiff(g1, g2) <= v.tolerance && channelDiff(b1, b2) <= v.tolerance {
+				continue
+			}
+
+			changed++
+			mask[p.Y*b.Dx()+p.X] = true
+			box = box.Union(image.Rect(p.X, p.Y, p.X+1, p.Y+1))
+		}
+	}
+
+	return changed, total, box, mask
+}
+
+// hash is a 64 bit difference hash of the image, computed on a 9x8 grayscale thumbnail with the masks blanked out
+func (v visualDiffCheck) hash(img image.Image) uint64 {
+	const w, h = 9, 8
+	b := img.Bounds()
+
+	var thumb [h][w]float64
+	var counts [h][w]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			p := image.Pt(x-b.Min.X, y-b.Min.Y)
+			tx, ty := p.X*w/b.Dx(), p.Y*h/b.Dy()
+			counts[ty][tx]++
+			if v.masked(p) {
+				continue
+			}
+			r, g, bl, _ := img.At(x, y).RGBA()
+			thumb[ty][tx] += 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(bl>>8)
+		}
+	}
+
+	var res uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			res <<= 1
+			if thumb[y][x]*float64(counts[y][x+1]) < thumb[y][x+1]*float64(counts[y][x]) {
+				res |= 1
+			}
+		}
+	}
+
+	return res
+}
+
+// highlight returns a PNG of the current screenshot, faded where nothing changed, with the changed pixels in red, a box around them and the masks greyed out
+func (v visualDiffCheck) highlight(current image.Image, changed []bool, box image.Rectangle) []byte {
+	b := current.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), current, b.Min, draw.Src)
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			switch {
+			case changed != nil && changed[y*b.Dx()+x]:
+				out.SetRGBA(x, y, visualChangeColor)
+			case v.masked(image.Pt(x, y)):
+				out.SetRGBA(x, y, visualMaskColor)
+			case changed != nil:
+				c := out.RGBAAt(x, y)
+				out.SetRGBA(x, y, color.RGBA{R: fade(c.R), G: fade(c.G), B: fade(c.B), A: 255})
+			}
+		}
+	}
+
+	box = box.Sub(b.Min).Intersect(out.Bounds())
+	if !box.Empty() {
+		for t := 0; t < 2; t++ {
+			r := box.Inset(-t)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				out.SetRGBA(x, r.Min.Y, visualChangeColor)
+				out.SetRGBA(x, r.Max.Y-1, visualChangeColor)
+			}
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				out.SetRGBA(r.Min.X, y, visualChangeColor)
+				out.SetRGBA(r.Max.X-1, y, visualChangeColor)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		Log().Errorf("Failed to encode diff image for URL [%s]: %v", v.url, err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (v visualDiffCheck) masked(p image.Point) bool {
+	for _, m := range v.masks {
+		if p.In(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// region is how the screenshotted region is named in logs and notifications
+func (v visualDiffCheck) region() string {
+	if len(v.selector) == 0 || v.selector == viewportSelector {
+		return viewportSelector
+	}
+	return v.selector
+}
+
+// channelDiff is the difference of two 16 bit color channels, scaled down to 0-255
+func channelDiff(a uint32, b uint32) int {
+	d := int(a>>8) - int(b>>8)
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// fade blends a color channel most of the way towards white
+func fade(c uint8) uint8 {
+	return uint8(255 - (255-int(c))/4)
+}
@@ -0,0 +1,158 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DefaultJSONResponseTimeout default time (seconds) a json check waits for a matching network response
+	DefaultJSONResponseTimeout = 30
+)
+
+// capturedResponse is the body of a network response whose URL matched a responseCapture pattern
+type capturedResponse struct {
+	URL    string
+	Status int64
+	Body   []byte
+}
+
+// responseCapture holds the network responses that matched a URL pattern during a single run
+type responseCapture struct {
+	pattern *regexp.Regexp
+	timeout time.Duration
+
+	mu        sync.Mutex
+	pending   map[network.RequestID]*network.Response
+	responses []capturedResponse
+	updated   chan struct{}
+}
+
+// captureActions starts capturing network responses for the URL before we navigate to it, into a capture of its own for each run
+type captureActions struct {
+	url     string
+	pattern *regexp.Regexp
+	timeout time.Duration
+}
+
+func newCaptureActions(url string, pattern string, timeout int) (captureActions, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return captureActions{}, fmt.Errorf("Invalid json_url_pattern [%s]: %v", pattern, err)
+	}
+
+	return captureActions{url: url, pattern: re, timeout: time.Duration(timeout) * time.Second}, nil
+}
+
+func (c captureActions) Generate(actions chromedp.Tasks) chromedp.Tasks {
+	actions = append(actions,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			Log().Infof("Capturing network responses matching [%s] for URL [%s]", c.pattern, c.url)
+			r := newResponseCapture(c.pattern, c.timeout)
+			runInfoFromContext(ctx).responses = r
+			r.listen(ctx)
+			return nil
+		}))
+
+	return actions
+}
+
+func newResponseCapture(pattern *regexp.Regexp, timeout time.Duration) *responseCapture {
+	return &responseCapture{pattern: pattern, timeout: timeout, pending: map[network.RequestID]*network.Response{}, updated: make(chan struct{})}
+}
+
+// listen is bound to the context of the current run, so the listener goes away along with the run
+func (r *responseCapture) listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventResponseReceived:
+			if !r.pattern.MatchString(ev.Response.URL) {
+				return
+			}
+			r.mu.Lock()
+			r.pending[ev.RequestID] = ev.Response
+			r.mu.Unlock()
+		case *network.EventLoadingFinished:
+			r.mu.Lock()
+			resp, ok := r.pending[ev.RequestID]
+			delete(r.pending, ev.RequestID)
+			r.mu.Unlock()
+			if !ok {
+				return
+			}
+
+			go func() {
+				c := chromedp.FromContext(ctx)
+				if c == nil || c.Target == nil {
+					return
+				}
+				body, err := network.GetResponseBody(ev.RequestID).Do(cdp.WithExecutor(ctx, c.Target))
+				if err != nil {
+					Log().Errorf("Failed to get body of matched response [%s]: %v", resp.URL, err)
+					return
+				}
+				Log().Debugf("Captured response [%s] with status [%d] and [%d] bytes", resp.URL, resp.Status, len(body))
+				r.add(capturedResponse{URL: resp.URL, Status: resp.Status, Body: body})
+			}()
+		}
+	})
+}
+
+func (r *responseCapture) add(resp capturedResponse) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.responses = append(r.responses, resp)
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// snapshot returns the responses captured so far and a channel that is closed when the next one arrives
+func (r *responseCapture) snapshot() ([]capturedResponse, chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make([]capturedResponse, len(r.responses))
+	copy(res, r.responses)
+	return res, r.updated
+}
+
+// extract waits for a captured response that has a value at the gjson path and returns that value
+func (r *responseCapture) extract(ctx context.Context, path string) (string, error) {
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	seen := 0
+	for {
+		responses, updated := r.snapshot()
+		for _, resp := range responses[seen:] {
+			if !gjson.ValidBytes(resp.Body) {
+				Log().Debugf("Captured response [%s] is not valid JSON, skipping it", resp.URL)
+				continue
+			}
+			v := gjson.GetBytes(resp.Body, path)
+			if v.Exists() {
+				Log().Infof("Found value for path [%s] in response [%s]", path, resp.URL)
+				return v.String(), nil
+			}
+			Log().Debugf("Path [%s] does not exist in captured response [%s]", path, resp.URL)
+		}
+		seen = len(responses)
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return "", fmt.Errorf("Timed out after %s waiting for a response matching [%s] with a value for path [%s]", r.timeout, r.pattern, path)
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}